@@ -0,0 +1,212 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import "fmt"
+
+// AuditProblem is the kind of inconsistency found by Audit
+type AuditProblem int
+
+const (
+	// AuditHalfLink is a controller record without the matching
+	// responder record on the target device, or vice versa
+	AuditHalfLink AuditProblem = iota
+
+	// AuditGroupMismatch is a responder record for a different group
+	// than the controller's matching half link.  The controller's group
+	// is taken to be correct
+	AuditGroupMismatch
+
+	// AuditUnknownAddress is a record for an address that is not in
+	// the inventory and is not otherwise known to be on the network
+	AuditUnknownAddress
+
+	// AuditDuplicate is a record that is equivalent to an earlier
+	// record in the same database
+	AuditDuplicate
+
+	// AuditSelfLink is a record that points to the device itself
+	AuditSelfLink
+)
+
+func (ap AuditProblem) String() string {
+	switch ap {
+	case AuditHalfLink:
+		return "half link"
+	case AuditGroupMismatch:
+		return "group mismatch"
+	case AuditUnknownAddress:
+		return "unknown address"
+	case AuditDuplicate:
+		return "duplicate"
+	case AuditSelfLink:
+		return "self link"
+	}
+	return fmt.Sprintf("AuditProblem(%d)", int(ap))
+}
+
+// AuditFinding is a single inconsistent record found by Audit
+type AuditFinding struct {
+	Problem AuditProblem
+
+	// Device is the address of the device whose database has the record
+	Device Address
+
+	// Link is the record that has the problem
+	Link *LinkRecord
+
+	// Match is the record that Link conflicts with.  For AuditDuplicate
+	// it is the earlier copy of the record, and for AuditGroupMismatch it
+	// is the controller's record on the peer device
+	Match *LinkRecord
+}
+
+func (af *AuditFinding) String() string {
+	switch af.Problem {
+	case AuditHalfLink:
+		role := "responder"
+		if af.Link.Flags.Responder() {
+			role = "controller"
+		}
+		return fmt.Sprintf("%s: %s has no %s record for group %s (%s)", af.Device, af.Link.Address, role, af.Link.Group, af.Link)
+	case AuditGroupMismatch:
+		return fmt.Sprintf("%s: %s controls group %s rather than %s (%s)", af.Device, af.Link.Address, af.Match.Group, af.Link.Group, af.Link)
+	}
+	return fmt.Sprintf("%s: %s (%s)", af.Device, af.Problem, af.Link)
+}
+
+// counterpart returns the record the peer device is expected to have
+// for the link.  The data bytes of a new responder record turn the
+// responder fully on at the default ramp rate
+func counterpart(device Address, link *LinkRecord) *LinkRecord {
+	if link.Flags.Controller() {
+		return &LinkRecord{Flags: 0xa2, Group: link.Group, Address: device, Data: [3]byte{0xff, 0x1c, 0x01}}
+	}
+	return &LinkRecord{Flags: 0xe2, Group: link.Group, Address: device, Data: [3]byte{0x03, 0x1c, 0x01}}
+}
+
+// Audit checks the All-Link databases of the devices against each other.
+// The inventory is the set of devices included in databases.  unread is
+// the list of other devices known to be on the network (for instance from
+// the product database, or because the PLM is linked to them) whose
+// databases could not be read.  Records for those devices can't be
+// checked and are skipped, records for any other address are reported as
+// AuditUnknownAddress.  Only in-use records are checked
+func Audit(unread []Address, databases ...DeviceLinks) []*AuditFinding {
+	inventory := make(map[Address][]*LinkRecord)
+	for _, db := range databases {
+		inventory[db.Info.Address] = db.Links
+	}
+
+	findings := []*AuditFinding{}
+	for _, db := range databases {
+		device := db.Info.Address
+		for i, link := range db.Links {
+			if !link.Flags.InUse() {
+				continue
+			}
+
+			if finding := auditLink(device, link, db.Links[:i], inventory, unread); finding != nil {
+				findings = append(findings, finding)
+			}
+		}
+	}
+	return findings
+}
+
+// auditLink checks a single record.  previous is the list of records that
+// come before the link in the device's database
+func auditLink(device Address, link *LinkRecord, previous []*LinkRecord, inventory map[Address][]*LinkRecord, unread []Address) *AuditFinding {
+	finding := &AuditFinding{Device: device, Link: link}
+	if link.Address == device {
+		finding.Problem = AuditSelfLink
+		return finding
+	} else if finding.Match = findEqual(previous, link); finding.Match != nil {
+		finding.Problem = AuditDuplicate
+		return finding
+	}
+
+	peerLinks, found := inventory[link.Address]
+	if !found {
+		if containsAddress(unread, link.Address) {
+			return nil
+		}
+		finding.Problem = AuditUnknownAddress
+		return finding
+	} else if findEqual(peerLinks, counterpart(device, link)) != nil {
+		return nil
+	}
+
+	// a half link on both devices for different groups is a group
+	// mismatch, which is reported once against the responder record
+	for _, peerLink := range peerLinks {
+		if peerLink.Flags.InUse() && peerLink.Address == device && peerLink.Flags.Controller() != link.Flags.Controller() && findEqual(inventory[device], counterpart(link.Address, peerLink)) == nil {
+			if link.Flags.Controller() {
+				return nil
+			}
+			finding.Problem = AuditGroupMismatch
+			finding.Match = peerLink
+			return finding
+		}
+	}
+	finding.Problem = AuditHalfLink
+	return finding
+}
+
+func findEqual(links []*LinkRecord, link *LinkRecord) *LinkRecord {
+	for _, l := range links {
+		if l.Equal(link) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Repair fixes the findings.  Self links and links to unknown addresses
+// are removed, later copies of duplicated records are removed (keeping the
+// data of the first copy), the group of a mismatched responder record is
+// changed to the controller's group and half links are completed by
+// adding the missing record to the peer.  Findings that involve a device
+// that is not in devices, usually because its database couldn't be read,
+// are skipped and returned.  Repair stops at the first error
+func Repair(findings []*AuditFinding, devices map[Address]LinkableDevice) (skipped []*AuditFinding, err error) {
+	for _, finding := range findings {
+		device, found := devices[finding.Device]
+		peer, peerFound := devices[finding.Link.Address]
+		if !found || (finding.Problem == AuditHalfLink && !peerFound) {
+			Log.Infof("Skipping %v, the device is not in the inventory", finding)
+			skipped = append(skipped, finding)
+			continue
+		}
+
+		switch finding.Problem {
+		case AuditSelfLink, AuditUnknownAddress:
+			err = device.RemoveLinks(finding.Link)
+		case AuditDuplicate:
+			err = removeDuplicate(device, finding.Match, finding.Link)
+		case AuditGroupMismatch:
+			link := *finding.Link
+			link.Group = finding.Match.Group
+			err = UpdateLink(device, finding.Link, &link)
+		case AuditHalfLink:
+			err = peer.AddLink(counterpart(finding.Device, finding.Link))
+		}
+
+		if err != nil {
+			return skipped, fmt.Errorf("failed to repair %v: %v", finding, err)
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import "testing"
+
+func TestAudit(t *testing.T) {
+	a, b, c, d, x := Address{1, 1, 1}, Address{2, 2, 2}, Address{3, 3, 3}, Address{4, 4, 4}, Address{9, 9, 9}
+	// u is known to be on the network but its database couldn't be read
+	u := Address{5, 5, 5}
+	devices := map[Address]*testLinkable{
+		a: {address: a, links: []*LinkRecord{
+			{Flags: 0xe2, Group: 1, Address: b, Data: [3]byte{1, 2, 3}},
+			{Flags: 0xe2, Group: 1, Address: b, Data: [3]byte{4, 5, 6}},
+			{Flags: 0xe2, Group: 2, Address: c},
+			{Flags: 0xe2, Group: 3, Address: d},
+			{Flags: 0xa2, Group: 1, Address: a},
+			{Flags: 0xa2, Group: 1, Address: x},
+			{Flags: 0xa2, Group: 1, Address: u},
+			// deleted records are ignored
+			{Flags: 0x22, Group: 1, Address: x},
+		}},
+		b: {address: b, links: []*LinkRecord{{Flags: 0xa2, Group: 1, Address: a}}},
+		c: {address: c},
+		d: {address: d, links: []*LinkRecord{{Flags: 0xa2, Group: 4, Address: a}}},
+	}
+
+	audit := func() []*AuditFinding {
+		databases := []DeviceLinks{}
+		for _, address := range []Address{a, b, c, d} {
+			databases = append(databases, DeviceLinks{Info: DeviceInfo{Address: address}, Links: devices[address].links})
+		}
+		return Audit([]Address{u}, databases...)
+	}
+
+	tests := []struct {
+		problem AuditProblem
+		device  Address
+		link    *LinkRecord
+		match   *LinkRecord
+	}{
+		{AuditDuplicate, a, devices[a].links[1], devices[a].links[0]},
+		{AuditHalfLink, a, devices[a].links[2], nil},
+		{AuditSelfLink, a, devices[a].links[4], nil},
+		{AuditUnknownAddress, a, devices[a].links[5], nil},
+		{AuditGroupMismatch, d, devices[d].links[0], devices[a].links[3]},
+	}
+
+	findings := audit()
+	if len(findings) != len(tests) {
+		for _, finding := range findings {
+			t.Logf("%v", finding)
+		}
+		t.Fatalf("expected %d findings got %d", len(tests), len(findings))
+	}
+
+	for i, test := range tests {
+		finding := findings[i]
+		if finding.Problem != test.problem || finding.Device != test.device || finding.Link != test.link || finding.Match != test.match {
+			t.Errorf("tests[%d] expected %v %v %v (%v) got %v (%v)", i, test.problem, test.device, test.link, test.match, finding, finding.Match)
+		}
+	}
+
+	linkables := make(map[Address]LinkableDevice)
+	for address, device := range devices {
+		linkables[address] = device
+	}
+
+	if skipped, err := Repair(findings, linkables); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(skipped) != 0 {
+		t.Errorf("expected no skipped findings got %v", skipped)
+	}
+
+	if findings = audit(); len(findings) != 0 {
+		t.Errorf("expected no findings after repair got %v", findings)
+	}
+
+	links := devices[a].inUse()
+	if len(links) != 4 {
+		t.Errorf("expected 4 links in use got %v", links)
+	}
+
+	for _, link := range links {
+		if link.Address == b && link.Data != [3]byte{1, 2, 3} {
+			t.Errorf("expected the duplicate to keep the data of the first copy got %v", link)
+		}
+	}
+
+	if links := devices[d].inUse(); len(links) != 1 || links[0].Group != 3 {
+		t.Errorf("expected the mismatched record to be changed to group 3 got %v", links)
+	}
+}
+
+func TestRepairSkipped(t *testing.T) {
+	a, u := Address{1, 1, 1}, Address{5, 5, 5}
+	device := &testLinkable{address: a, links: []*LinkRecord{{Flags: 0xe2, Group: 1, Address: u}}}
+	devices := map[Address]LinkableDevice{a: device}
+
+	tests := []struct {
+		desc    string
+		finding *AuditFinding
+	}{
+		{"missing device", &AuditFinding{Problem: AuditSelfLink, Device: u, Link: &LinkRecord{Flags: 0xe2, Group: 1, Address: u}}},
+		{"missing peer", &AuditFinding{Problem: AuditHalfLink, Device: a, Link: device.links[0]}},
+	}
+
+	for _, test := range tests {
+		skipped, err := Repair([]*AuditFinding{test.finding}, devices)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.desc, err)
+		} else if len(skipped) != 1 || skipped[0] != test.finding {
+			t.Errorf("%s: expected %v to be skipped got %v", test.desc, test.finding, skipped)
+		}
+
+		if links := device.inUse(); len(links) != 1 {
+			t.Errorf("%s: expected links to be unchanged got %v", test.desc, links)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon"
+)
+
+var auditFixFlag bool
+
+func init() {
+	cmd := Commands.Register("audit", "", "Check the link databases of every known device for inconsistent links", auditCmd)
+	cmd.Flags.BoolVar(&auditFixFlag, "fix", false, "repair or remove the inconsistent links")
+}
+
+func auditCmd(args []string, next cli.NextFunc) error {
+	databases, linkables, unread, err := readDatabases()
+	if err != nil {
+		return err
+	}
+
+	findings := insteon.Audit(unread, databases...)
+	for _, finding := range findings {
+		fmt.Printf("%v\n", finding)
+	}
+	fmt.Printf("%d problems found in %d link databases\n", len(findings), len(databases))
+
+	if auditFixFlag && len(findings) > 0 {
+		msg := fmt.Sprintf("Repair %d problems? (y/n) ", len(findings))
+		if getResponse(msg, "y", "n") == "y" {
+			var skipped []*insteon.AuditFinding
+			skipped, err = insteon.Repair(findings, linkables)
+			for _, finding := range skipped {
+				fmt.Printf("Skipped %v\n", finding)
+			}
+		}
+	}
+	return err
+}
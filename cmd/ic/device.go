@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 
 	"github.com/abates/cli"
 	"github.com/abates/insteon"
@@ -33,10 +34,11 @@ func init() {
 	cmd.Register("link", "", "enter linking mode", devLinkCmd)
 	cmd.Register("unlink", "", "enter unlinking mode", devUnlinkCmd)
 	cmd.Register("exitlink", "", "exit linking mode", devExitLinkCmd)
-	cmd.Register("cleanup", "", "remove duplicate links in the all-link database", devCleanupCmd)
+	cmd.Register("cleanup", "", "remove duplicate and orphaned links and compact the all-link database", devCleanupCmd)
 	cmd.Register("dump", "", "dump the device all-link database", devDumpCmd)
 	cmd.Register("edit", "", "edit the device all-link database", devEditCmd)
 	cmd.Register("version", "<device id>", "Retrieve the Insteon engine version", devVersionCmd)
+	cmd.Register("quality", "[pings]", "ping the device and report hops, retries and latency", devQualityCmd)
 }
 
 func devCmd(args []string, next cli.NextFunc) (err error) {
@@ -50,14 +52,14 @@ func devCmd(args []string, next cli.NextFunc) (err error) {
 		return fmt.Errorf("invalid device address: %v", err)
 	}
 
-	device, err = devConnect(modem.Network, addr)
+	device, err = devConnect(addr)
 	if err == nil {
 		err = next()
 	}
 	return err
 }
 
-func devConnect(network *insteon.Network, addr insteon.Address) (insteon.Device, error) {
+func devConnect(addr insteon.Address) (insteon.Device, error) {
 	device, err := network.Connect(addr)
 	if err == insteon.ErrNotLinked {
 		msg := fmt.Sprintf("Device %s is not linked to the PLM.  Link now? (y/n) ", addr)
@@ -69,6 +71,12 @@ func devConnect(network *insteon.Network, addr insteon.Address) (insteon.Device,
 			device, err = network.Connect(addr)
 		}
 	}
+
+	if err == nil {
+		// commands from the command line are issued by a user waiting
+		// for the result
+		insteon.SetPriority(device, insteon.PriorityInteractive)
+	}
 	return device, err
 }
 
@@ -110,10 +118,7 @@ func devInfoCmd([]string, cli.NextFunc) (err error) {
 
 func printDevInfo(device insteon.Device, extra string) (err error) {
 	fmt.Printf("       Device: %v\n", device)
-	info, found := modem.Network.DB.Find(device.Address())
-	if !found || !info.Complete() {
-		info, err = modem.Network.IDRequest(device.Address())
-	}
+	info, err := network.DeviceInfo(device.Address())
 	if err == nil {
 		fmt.Printf("     Category: %v\n", info.DevCat)
 		fmt.Printf("     Firmware: %v\n", info.FirmwareVersion)
@@ -130,82 +135,136 @@ func printDevInfo(device insteon.Device, extra string) (err error) {
 }
 
 func devVersionCmd([]string, cli.NextFunc) error {
-	if info, found := modem.Network.DB.Find(device.Address()); found {
+	info, err := network.DeviceInfo(device.Address())
+	if err == nil {
 		fmt.Printf("Device version: %s\n", info.FirmwareVersion)
 	}
-	return nil
+	return err
 }
 
-func devEditCmd([]string, cli.NextFunc) error {
-	return devLink(func(linkable insteon.LinkableDevice) error {
-		dbLinks, _ := linkable.Links()
-		if len(dbLinks) == 0 {
-			return fmt.Errorf("No links to edit")
+func devQualityCmd(args []string, next cli.NextFunc) error {
+	pings := 5
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number of pings %q", args[0])
 		}
+		pings = n
+	}
 
-		tmpfile, err := ioutil.TempFile("", "insteon_")
-		if err != nil {
-			return err
+	failed := 0
+	for i := 0; i < pings; i++ {
+		if _, err := device.SendCommand(insteon.CmdPing, nil); err != nil {
+			failed++
 		}
-		defer os.Remove(tmpfile.Name())
-
-		buf := bytes.NewBuffer(nil)
-		fmt.Fprintf(buf, "#\n")
-		fmt.Fprintf(buf, "# Lines beginning with a # are ignored\n")
-		fmt.Fprintf(buf, "# DO NOT delete lines, this will cause the entries to\n")
-		fmt.Fprintf(buf, "# shift up and then the last entry will be in the database twice\n")
-		fmt.Fprintf(buf, "# To delete a record simply mark it 'Available' by changing the\n")
-		fmt.Fprintf(buf, "# first letter of the Flags to 'A'\n")
-		fmt.Fprintf(buf, "#\n")
-		fmt.Fprintf(buf, "# Flags Group Address    Data\n")
-		for _, link := range dbLinks {
-			output, _ := link.MarshalText()
-			fmt.Fprintf(buf, "  %s\n", string(output))
+	}
+
+	quality, err := network.LinkQuality(device.Address())
+	if err == nil {
+		fmt.Printf("       Device: %v\n", device)
+		fmt.Printf("        Pings: %d (%d failed)\n", pings, failed)
+		fmt.Printf("         Acks: %d\n", quality.Acks)
+		fmt.Printf("      Retries: %d\n", quality.Retries)
+		fmt.Printf("     Failures: %d\n", quality.Failures)
+		fmt.Printf("         Hops: %d (most recent), %d (highest)\n", quality.Hops, quality.MaxHopsUsed)
+		fmt.Printf("     Max Hops: %d\n", quality.MaxHops)
+		fmt.Printf("      Latency: %v\n", quality.Latency)
+		if quality.MaxHopsUsed >= 2 || quality.Failures > 0 {
+			fmt.Printf("\nMessages to this device are being repeated several times or lost.\nA range extender near the device may help.\n")
 		}
+	}
+	return err
+}
 
-		tmpfile.Write(buf.Bytes())
-
-		if err = tmpfile.Close(); err == nil {
-			cmd := exec.Command(EDITOR, tmpfile.Name())
-			cmd.Stdin = os.Stdin
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Start()
-			err = cmd.Wait()
-			if err == nil {
-				input, err := ioutil.ReadFile(tmpfile.Name())
-				if err == nil && !bytes.Equal(buf.Bytes(), input) {
-					i := 0
-					for _, line := range bytes.Split(input, []byte("\n")) {
-						line = bytes.TrimSpace(line)
-						if len(line) == 0 || bytes.Index(line, []byte("#")) == 0 {
-							continue
-						}
-						if i < len(dbLinks) {
-							err = dbLinks[i].UnmarshalText(line)
+func devEditCmd([]string, cli.NextFunc) error {
+	return devLink(editLinks)
+}
+
+// editLinks opens the link database in an editor and writes the changed
+// records back to the device
+func editLinks(linkable insteon.LinkableDevice) error {
+	dbLinks, _ := linkable.Links()
+	if len(dbLinks) == 0 {
+		return fmt.Errorf("No links to edit")
+	}
+
+	tmpfile, err := ioutil.TempFile("", "insteon_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "#\n")
+	fmt.Fprintf(buf, "# Lines beginning with a # are ignored\n")
+	fmt.Fprintf(buf, "# DO NOT delete lines, this will cause the entries to\n")
+	fmt.Fprintf(buf, "# shift up and then the last entry will be in the database twice\n")
+	fmt.Fprintf(buf, "# To delete a record simply mark it 'Available' by changing the\n")
+	fmt.Fprintf(buf, "# first letter of the Flags to 'A'\n")
+	fmt.Fprintf(buf, "#\n")
+	fmt.Fprintf(buf, "# Flags Group Address    Data\n")
+	for _, link := range dbLinks {
+		output, _ := link.MarshalText()
+		fmt.Fprintf(buf, "  %s\n", string(output))
+	}
+
+	tmpfile.Write(buf.Bytes())
+
+	if err = tmpfile.Close(); err == nil {
+		cmd := exec.Command(EDITOR, tmpfile.Name())
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Start()
+		err = cmd.Wait()
+		if err == nil {
+			input, err := ioutil.ReadFile(tmpfile.Name())
+			if err == nil && !bytes.Equal(buf.Bytes(), input) {
+				i := 0
+				for _, line := range bytes.Split(input, []byte("\n")) {
+					line = bytes.TrimSpace(line)
+					if len(line) == 0 || bytes.Index(line, []byte("#")) == 0 {
+						continue
+					}
+					if i < len(dbLinks) {
+						oldLink := *dbLinks[i]
+						err = dbLinks[i].UnmarshalText(line)
+						if err != nil {
+							fmt.Printf("Skipping invalid line %q: %v\n", string(line), err)
+						} else if oldLink != *dbLinks[i] {
+							fmt.Printf("Writing %s...", dbLinks[i])
+							err = insteon.UpdateLink(linkable, &oldLink, dbLinks[i])
 							if err == nil {
-								fmt.Printf("Writing %s...", dbLinks[i])
-								err = linkable.WriteLink(dbLinks[i])
-								if err == nil {
-									fmt.Printf("done\n")
-								} else {
-									fmt.Printf("%v\n", err)
-								}
+								fmt.Printf("done\n")
 							} else {
-								fmt.Printf("Skipping invalid line %q: %v\n", string(line), err)
+								fmt.Printf("%v\n", err)
 							}
-						} else {
-							fmt.Printf("Adding line %q\n", string(line))
 						}
-						i++
+					} else {
+						fmt.Printf("Adding line %q\n", string(line))
 					}
+					i++
 				}
 			}
 		}
-		return err
-	})
+	}
+	return err
 }
 
 func devCleanupCmd([]string, cli.NextFunc) error {
-	return nil
+	return devLink(cleanup)
+}
+
+// cleanup removes duplicate and orphaned links from the linkable device
+// and reports the links that were removed
+func cleanup(linkable insteon.LinkableDevice) error {
+	removed, err := insteon.Cleanup(linkable)
+	for _, link := range removed {
+		fmt.Printf("Removed %s\n", link)
+	}
+
+	if err == nil {
+		fmt.Printf("%d links removed\n", len(removed))
+	}
+	return err
 }
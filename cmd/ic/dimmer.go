@@ -55,7 +55,7 @@ func dimmerCmd(args []string, next cli.NextFunc) (err error) {
 		return fmt.Errorf("invalid device address: %v", err)
 	}
 
-	device, err := devConnect(modem.Network, addr)
+	device, err := devConnect(addr)
 	if err == nil {
 		var ok bool
 		if dimmer, ok = device.(insteon.Dimmer); ok {
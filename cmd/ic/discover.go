@@ -0,0 +1,73 @@
+// Copyright 2018 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon"
+)
+
+func init() {
+	Commands.Register("discover", "", "Scan the PLM and device link databases to find every device on the network", discoverCmd)
+}
+
+func engineName(version insteon.EngineVersion) string {
+	switch version {
+	case insteon.VerI1:
+		return "I1"
+	case insteon.VerI2:
+		return "I2"
+	case insteon.VerI2Cs:
+		return "I2CS"
+	}
+	return fmt.Sprintf("%d", int(version))
+}
+
+func discoverCmd(args []string, next cli.NextFunc) error {
+	local, ok := network.(localNetwork)
+	if !ok {
+		return fmt.Errorf("discovery requires a local PLM")
+	}
+
+	inventory, err := local.Discover(modem)
+	if err != nil {
+		return err
+	}
+
+	unreachable := 0
+	fmt.Printf("Address  DevCat Firmware Engine Links Referenced By\n")
+	for _, dd := range inventory {
+		referencedBy := []string{}
+		for _, address := range dd.ReferencedBy {
+			referencedBy = append(referencedBy, address.String())
+		}
+
+		if dd.Reachable {
+			fmt.Printf("%s %s  %-8s %-6s %5d %s\n", dd.Info.Address, dd.Info.DevCat, dd.Info.FirmwareVersion, engineName(dd.Info.EngineVersion), dd.Links, strings.Join(referencedBy, " "))
+			if dd.Err != nil {
+				fmt.Printf("         failed to read link database: %v\n", dd.Err)
+			}
+		} else {
+			unreachable++
+			fmt.Printf("%s unreachable (%v), referenced by %s\n", dd.Info.Address, dd.Err, strings.Join(referencedBy, " "))
+		}
+	}
+
+	fmt.Printf("\n%d devices found, %d unreachable\n", len(inventory)-unreachable, unreachable)
+	return nil
+}
@@ -16,14 +16,21 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/abates/cli"
 	"github.com/abates/insteon"
+	"github.com/abates/insteon/metrics"
 	"github.com/abates/insteon/plm"
+	"github.com/abates/insteon/rest"
+	"github.com/abates/insteon/sim"
 	"github.com/tarm/serial"
 )
 
@@ -52,10 +59,90 @@ func (llf *LogLevelFlag) String() string {
 	return insteon.LogLevel(*llf).String()
 }
 
+// Modem is the set of PLM functions used by ic.  Both a locally
+// connected *plm.PLM and a remote daemon's modem satisfy this interface
+type Modem interface {
+	insteon.LinkableDevice
+	insteon.AllLinkCommander
+	Info() (*plm.Info, error)
+	Reset() error
+}
+
+// Network is used to connect to devices either directly or by way
+// of a remote daemon
+type Network interface {
+	Dial(address insteon.Address) (insteon.Device, error)
+	Connect(address insteon.Address) (insteon.Device, error)
+	DeviceInfo(address insteon.Address) (insteon.DeviceInfo, error)
+	Devices() ([]insteon.DeviceInfo, error)
+	LinkQuality(address insteon.Address) (insteon.LinkQuality, error)
+}
+
+// localNetwork adds device info lookups to an *insteon.Network
+type localNetwork struct {
+	*insteon.Network
+}
+
+// DeviceInfo returns the product database information for the address.  If the
+// information is incomplete then an ID Request is sent to the device
+func (ln localNetwork) DeviceInfo(address insteon.Address) (info insteon.DeviceInfo, err error) {
+	info, found := ln.DB.Find(address)
+	if !found || !info.Complete() {
+		info, err = ln.IDRequest(address)
+	}
+	return info, err
+}
+
+// LinkQuality returns the link quality collected for the device during
+// this session.  An error is returned if no messages have been exchanged
+// with the device
+func (ln localNetwork) LinkQuality(address insteon.Address) (insteon.LinkQuality, error) {
+	quality, found := ln.Network.LinkQuality(address)
+	if !found {
+		return quality, fmt.Errorf("no link quality has been collected for %s", address)
+	}
+	return quality, nil
+}
+
+// Devices returns every device in the product database
+func (ln localNetwork) Devices() ([]insteon.DeviceInfo, error) {
+	return ln.DB.Devices(), nil
+}
+
+// loadDB restores the product database from the file, if it exists
+func loadDB(db insteon.ProductDatabase, filename string) error {
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err == nil {
+		err = json.Unmarshal(buf, db)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("failed to load %s: %v", filename, err)
+	}
+	return err
+}
+
+// saveDB writes the product database to the file
+func saveDB(db insteon.ProductDatabase, filename string) error {
+	buf, err := json.MarshalIndent(db, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filename, buf, 0644)
+	}
+	return err
+}
+
 var (
-	modem          *plm.PLM
+	modem          Modem
+	network        Network
 	logLevelFlag   LogLevelFlag
 	serialPortFlag string
+	remoteFlag     string
+	dbFlag         string
+	metricsFlag    string
+	captureFlag    string
+	port           *plm.Port
 	timeoutFlag    time.Duration
 
 	Commands = cli.New(os.Args[0], "", "", run)
@@ -63,7 +150,11 @@ var (
 
 func init() {
 	Commands.SetOutput(os.Stderr)
-	Commands.Flags.StringVar(&serialPortFlag, "port", "/dev/ttyUSB0", "serial port connected to a PLM")
+	Commands.Flags.StringVar(&serialPortFlag, "port", "/dev/ttyUSB0", "serial port connected to a PLM (sim://[address] for a simulated PLM)")
+	Commands.Flags.StringVar(&remoteFlag, "remote", "", "URL of an insteond daemon to use instead of a local PLM (http://host:port)")
+	Commands.Flags.StringVar(&dbFlag, "db", "", "file used to store the product database (device categories, firmware versions and names)")
+	Commands.Flags.StringVar(&metricsFlag, "metrics", "", "address to serve Prometheus metrics on (for instance :9100)")
+	Commands.Flags.StringVar(&captureFlag, "capture", "", "record all PLM traffic to a capture file")
 	Commands.Flags.Var(&logLevelFlag, "log", "Log Level {none|info|debug|trace}")
 	Commands.Flags.DurationVar(&timeoutFlag, "timeout", 5*time.Second, "read/write timeout duration")
 }
@@ -87,27 +178,101 @@ func getResponse(message string, acceptable ...string) (resp string) {
 	return resp
 }
 
+// newSimulator returns a simulated PLM with a dimmer (11.11.11) and a
+// switch (22.22.22) that are linked to it, for demonstrations
+func newSimulator(address insteon.Address) *sim.PLM {
+	modem := sim.NewPLM(address)
+	devices := []insteon.DeviceInfo{
+		{Address: insteon.Address{0x11, 0x11, 0x11}, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x41, EngineVersion: insteon.VerI2Cs},
+		{Address: insteon.Address{0x22, 0x22, 0x22}, DevCat: insteon.DevCat{0x02, 0x2a}, FirmwareVersion: 0x41, EngineVersion: insteon.VerI2},
+	}
+
+	for _, info := range devices {
+		device := sim.NewLightingDevice(info)
+		device.AddLink(&insteon.LinkRecord{Flags: 0xa2, Group: 1, Address: address, Data: [3]byte{0xff, 0x1c, 0x01}})
+		modem.AddLink(&insteon.LinkRecord{Flags: 0xe2, Group: 1, Address: info.Address})
+		modem.AddDevice(device)
+	}
+	return modem
+}
+
+// openPort opens the serial port connected to the PLM.  A name of the
+// form sim://[address] opens a simulated PLM instead
+func openPort(name string) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(name, "sim://") {
+		address := insteon.Address{0x01, 0x02, 0x03}
+		if str := strings.TrimPrefix(name, "sim://"); str != "" {
+			if err := address.UnmarshalText([]byte(str)); err != nil {
+				return nil, err
+			}
+		}
+		return newSimulator(address), nil
+	}
+
+	s, err := serial.OpenPort(&serial.Config{Name: name, Baud: 19200})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 func run(args []string, next cli.NextFunc) error {
 	if logLevelFlag > insteon.LevelNone {
 		insteon.Log.Level(insteon.LogLevel(logLevelFlag))
 	}
 
-	c := &serial.Config{
-		Name: serialPortFlag,
-		Baud: 19200,
+	if metricsFlag != "" {
+		m := metrics.New()
+		m.Register()
+		go func() {
+			err := http.ListenAndServe(metricsFlag, m)
+			insteon.Log.Errorf(err, "Metrics server failed: %v", err)
+		}()
+	}
+
+	if remoteFlag != "" {
+		client := rest.NewClient(remoteFlag)
+		modem = client.Modem()
+		network = client
+		return next()
 	}
 
-	s, err := serial.OpenPort(c)
+	open := func() (io.ReadWriteCloser, error) { return openPort(serialPortFlag) }
+	s, err := plm.NewReconnector(open, plm.ReconnectConfig{})
 	if err == nil {
 		defer s.Close()
 
-		modem = plm.New(plm.NewPort(s, timeoutFlag), timeoutFlag)
-		defer modem.Close()
+		port = plm.NewPort(s, timeoutFlag)
+		if captureFlag != "" {
+			var closer func() error
+			closer, err = startCapture(captureFlag)
+			if err != nil {
+				return err
+			}
+			defer closer()
+		}
+
+		local := plm.New(port, timeoutFlag)
+		defer local.Close()
+		modem = local
+		network = localNetwork{local.Network}
 		if logLevelFlag == insteon.LevelTrace {
 			//modem.StartMonitor()
 			//defer modem.StopMonitor()
 		}
-		return next()
+
+		if dbFlag == "" {
+			return next()
+		}
+
+		err = loadDB(local.Network.DB, dbFlag)
+		if err == nil {
+			err = next()
+			saveErr := saveDB(local.Network.DB, dbFlag)
+			if err == nil {
+				err = saveErr
+			}
+		}
 	}
 	return err
 }
@@ -15,19 +15,95 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 
 	"github.com/abates/cli"
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
 )
 
+var monRawFlag bool
+
 func init() {
-	Commands.Register("monitor", "", "Monitor the Insteon network", monCmd)
+	cmd := Commands.Register("monitor", "", "Monitor the Insteon network", monCmd)
+	cmd.Flags.BoolVar(&monRawFlag, "raw", false, "show every repeat of broadcast and cleanup messages")
+	Commands.Register("capture", "<file>", "Monitor the Insteon network and record all PLM traffic to a capture file", captureCmd)
+}
+
+// startCapture begins recording the PLM traffic to the file.  The
+// returned function stops recording and closes the file
+func startCapture(filename string) (func() error, error) {
+	if port == nil {
+		return nil, fmt.Errorf("capturing requires a local PLM")
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	port.SetCapture(plm.NewCaptureWriter(f))
+	return func() error {
+		port.SetCapture(nil)
+		return f.Close()
+	}, nil
 }
 
 func monCmd([]string, cli.NextFunc) error {
-	log.Printf("Starting monitor...")
-	/*for msg := range modem.Monitor() {
-		log.Printf("%s", msg)
-	}*/
-	return nil
+	local, ok := network.(localNetwork)
+	if !ok {
+		return fmt.Errorf("monitoring requires a local PLM")
+	}
+
+	recvCh := make(chan *insteon.Message, 1)
+	if monRawFlag {
+		local.SubscribeRaw(recvCh)
+	} else {
+		local.Subscribe(recvCh)
+	}
+	defer func() {
+		// keep reading until the channel is closed so that the
+		// network is not blocked delivering a message
+		go func() {
+			for range recvCh {
+			}
+		}()
+		local.Unsubscribe(recvCh)
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	log.Printf("Starting monitor, press Ctrl-C to exit...")
+	for {
+		select {
+		case msg, open := <-recvCh:
+			if !open {
+				return nil
+			}
+			log.Printf("%s", msg)
+		case <-sigCh:
+			return nil
+		}
+	}
+}
+
+func captureCmd(args []string, next cli.NextFunc) error {
+	if len(args) < 1 {
+		return fmt.Errorf("capture file must be specified")
+	}
+
+	closer, err := startCapture(args[0])
+	if err == nil {
+		err = monCmd(nil, next)
+		closeErr := closer()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
@@ -0,0 +1,139 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/mqtt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	mqttBrokerFlag   string
+	mqttClientIDFlag string
+	mqttPrefixFlag   string
+	mqttUserFlag     string
+	mqttPasswordFlag string
+	discoveryFlag    string
+)
+
+func init() {
+	cmd := Commands.Register("mqtt", "[scene config file]", "Bridge the Insteon network to an MQTT broker", mqttCmd)
+	cmd.Flags.StringVar(&mqttBrokerFlag, "broker", "tcp://localhost:1883", "MQTT broker URL")
+	cmd.Flags.StringVar(&mqttClientIDFlag, "client-id", "insteon", "MQTT client id")
+	cmd.Flags.StringVar(&mqttPrefixFlag, "prefix", mqtt.DefaultPrefix, "topic prefix")
+	cmd.Flags.StringVar(&mqttUserFlag, "username", "", "MQTT username")
+	cmd.Flags.StringVar(&mqttPasswordFlag, "password", "", "MQTT password")
+	cmd.Flags.StringVar(&discoveryFlag, "discovery", "", "publish Home Assistant discovery config using this prefix (usually homeassistant)")
+}
+
+// pahoClient adapts a paho client to the mqtt.Client interface
+type pahoClient struct {
+	paho.Client
+}
+
+func (pc pahoClient) Publish(topic string, retained bool, payload []byte) error {
+	token := pc.Client.Publish(topic, 1, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (pc pahoClient) Subscribe(filter string, handler mqtt.Handler) error {
+	token := pc.Client.Subscribe(filter, 1, func(_ paho.Client, msg paho.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (pc pahoClient) Unsubscribe(filters ...string) error {
+	token := pc.Client.Unsubscribe(filters...)
+	token.Wait()
+	return token.Error()
+}
+
+func mqttCmd(args []string, next cli.NextFunc) error {
+	config := mqtt.Config{
+		Prefix:  mqttPrefixFlag,
+		Modem:   modem,
+		Network: network,
+	}
+
+	if len(args) > 0 {
+		scenes := sceneConfig{}
+		buf, err := ioutil.ReadFile(args[0])
+		if err == nil {
+			err = json.Unmarshal(buf, &scenes)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", args[0], err)
+		}
+		config.Scenes = scenes.Scenes
+	}
+
+	// network events and state tracking are only available
+	// when the PLM is connected locally
+	if local, ok := network.(localNetwork); ok {
+		config.Subscriber = local.Network
+		config.Tracker = insteon.NewStateTracker(local.Network)
+		defer config.Tracker.Close()
+	}
+
+	options := paho.NewClientOptions()
+	options.AddBroker(mqttBrokerFlag)
+	options.SetClientID(mqttClientIDFlag)
+	options.SetUsername(mqttUserFlag)
+	options.SetPassword(mqttPasswordFlag)
+	options.SetWill(mqtt.AvailabilityTopic(mqttPrefixFlag), mqtt.Offline, 1, true)
+
+	client := paho.NewClient(options)
+	token := client.Connect()
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to connect to %s: %v", mqttBrokerFlag, token.Error())
+	}
+	defer client.Disconnect(250)
+
+	bridge := mqtt.New(pahoClient{client}, config)
+	err := bridge.Start()
+	if err == nil && discoveryFlag != "" {
+		var devices []insteon.DeviceInfo
+		devices, err = network.Devices()
+		if err == nil {
+			err = bridge.PublishDiscovery(discoveryFlag, devices)
+		}
+	}
+
+	if err == nil {
+		log.Printf("Bridging to %s, press Ctrl-C to exit", mqttBrokerFlag)
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt)
+		<-sigCh
+	}
+
+	closeErr := bridge.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -29,9 +29,19 @@ func init() {
 	cmd.Register("unlink", "<device id> ...", "Unlink the PLM from one or more devices", plmUnlinkCmd)
 	cmd.Register("crosslink", "<device id> ...", "Crosslink the PLM to one or more devices", plmCrossLinkCmd)
 	cmd.Register("alllink", "<device id> ...", "Put the PLM into linking mode for manual linking", plmAllLinkCmd)
+	cmd.Register("edit", "", "edit the PLM all-link database", plmEditCmd)
+	cmd.Register("cleanup", "", "remove duplicate and orphaned links from the PLM all-link database", plmCleanupCmd)
 	cmd.Register("reset", "", "Factory reset the IM", plmResetCmd)
 }
 
+func plmEditCmd(args []string, next cli.NextFunc) error {
+	return editLinks(modem)
+}
+
+func plmCleanupCmd(args []string, next cli.NextFunc) error {
+	return cleanup(modem)
+}
+
 func plmResetCmd(args []string, next cli.NextFunc) (err error) {
 	msg := "WARNING: This will erase the modem All-Link database and reset the modem to factory defaults\nProceed? (y/n) "
 	if getResponse(msg, "y", "n") == "y" {
@@ -71,7 +81,7 @@ func plmLink(args []string, crosslink bool) error {
 		if err == nil {
 			group := insteon.Group(0x01)
 			fmt.Printf("Linking to %s...", addr)
-			device, err := modem.Network.Dial(addr)
+			device, err := network.Dial(addr)
 			if err == insteon.ErrNotLinked {
 				err = nil
 			}
@@ -101,7 +111,7 @@ func plmLink(args []string, crosslink bool) error {
 }
 
 func plmAllLinkCmd(args []string, next cli.NextFunc) error {
-	return modem.AddManualLink(insteon.Group(0x01))
+	return modem.EnterLinkingMode(insteon.Group(0x01))
 }
 
 func plmUnlinkCmd(args []string, next cli.NextFunc) (err error) {
@@ -117,7 +127,7 @@ func plmUnlinkCmd(args []string, next cli.NextFunc) (err error) {
 		err = addr.UnmarshalText([]byte(arg))
 		if err == nil {
 			fmt.Printf("Unlinking from %s...", addr)
-			device, err = modem.Network.Dial(addr)
+			device, err = network.Dial(addr)
 
 			if linkable, ok := device.(insteon.LinkableDevice); ok {
 				if err == nil {
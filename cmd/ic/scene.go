@@ -0,0 +1,207 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon"
+)
+
+// sceneConfig is the format of the scene config file:
+//
+//	{
+//	  "scenes": [
+//	    {
+//	      "name": "movie",
+//	      "members": [
+//	        { "address": "11.22.33", "level": 64 },
+//	        { "address": "44.55.66", "level": 0, "ramp": 31 }
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// When a scene is applied the allocated group is written back to the file
+type sceneConfig struct {
+	Scenes []*insteon.Scene `json:"scenes"`
+}
+
+var (
+	sceneFilename string
+	scenes        sceneConfig
+)
+
+func init() {
+	cmd := Commands.Register("scene", "<command> <config file>", "Manage scenes defined in a config file", sceneCmd)
+	cmd.Register("list", "", "list the scenes defined in the config file", sceneListCmd)
+	cmd.Register("apply", "[scene name] ...", "create or update scenes on the PLM and responders", sceneApplyCmd)
+	cmd.Register("delete", "<scene name> ...", "remove scenes from the PLM and responders", sceneDeleteCmd)
+	cmd.Register("on", "<scene name>", "turn a scene on", sceneOnCmd)
+	cmd.Register("off", "<scene name>", "turn a scene off", sceneOffCmd)
+}
+
+func sceneCmd(args []string, next cli.NextFunc) error {
+	if len(args) < 1 {
+		return fmt.Errorf("scene config file must be specified")
+	}
+
+	sceneFilename = args[0]
+	buf, err := ioutil.ReadFile(sceneFilename)
+	if err == nil {
+		err = json.Unmarshal(buf, &scenes)
+		if err == nil {
+			err = next()
+		} else {
+			err = fmt.Errorf("failed to parse %s: %v", sceneFilename, err)
+		}
+	}
+	return err
+}
+
+func saveScenes() error {
+	buf, err := json.MarshalIndent(&scenes, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(sceneFilename, buf, 0644)
+	}
+	return err
+}
+
+// findScenes returns the scenes matching the names given on the
+// command line.  If no names are given then all the scenes are
+// returned
+func findScenes(names []string) ([]*insteon.Scene, error) {
+	if len(names) == 0 {
+		return scenes.Scenes, nil
+	}
+
+	found := []*insteon.Scene{}
+	for _, name := range names {
+		var scene *insteon.Scene
+		for _, s := range scenes.Scenes {
+			if s.Name == name {
+				scene = s
+				break
+			}
+		}
+
+		if scene == nil {
+			return nil, fmt.Errorf("scene %q is not defined in %s", name, sceneFilename)
+		}
+		found = append(found, scene)
+	}
+	return found, nil
+}
+
+func sceneConnect(addr insteon.Address) (insteon.LinkableDevice, error) {
+	device, err := network.Dial(addr)
+	if err == nil || err == insteon.ErrNotLinked {
+		if linkable, ok := device.(insteon.LinkableDevice); ok {
+			return linkable, nil
+		}
+		err = fmt.Errorf("%v is not a linkable device", device)
+	}
+	return nil, err
+}
+
+func sceneListCmd([]string, cli.NextFunc) error {
+	for _, scene := range scenes.Scenes {
+		group := "unassigned"
+		if scene.Group > 0 {
+			group = scene.Group.String()
+		}
+		fmt.Printf("%s (group %s)\n", scene.Name, group)
+		for _, member := range scene.Members {
+			data := member.Data()
+			fmt.Printf("    %s level %3d ramp 0x%02x button %d\n", member.Address, data[0], data[1], data[2])
+		}
+	}
+	return nil
+}
+
+func sceneApplyCmd(args []string, next cli.NextFunc) error {
+	selected, err := findScenes(args[1:])
+	for _, scene := range selected {
+		fmt.Printf("Applying scene %q...", scene.Name)
+		if scene.Group == 0 {
+			err = insteon.CreateScene(scene, modem, sceneConnect)
+		} else {
+			err = insteon.EditScene(scene, modem, sceneConnect)
+		}
+
+		if err == nil {
+			fmt.Printf("done (group %s)\n", scene.Group)
+		} else {
+			fmt.Printf("failed: %v\n", err)
+			break
+		}
+	}
+
+	if len(selected) > 0 {
+		if saveErr := saveScenes(); err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+func sceneDeleteCmd(args []string, next cli.NextFunc) error {
+	if len(args) < 2 {
+		return fmt.Errorf("at least one scene name must be specified")
+	}
+
+	selected, err := findScenes(args[1:])
+	for _, scene := range selected {
+		fmt.Printf("Deleting scene %q...", scene.Name)
+		err = insteon.DeleteScene(scene, modem, sceneConnect)
+		if err == nil {
+			scene.Group = 0
+			fmt.Printf("done\n")
+		} else {
+			fmt.Printf("failed: %v\n", err)
+			break
+		}
+	}
+
+	if len(selected) > 0 {
+		if saveErr := saveScenes(); err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+func sceneTrigger(args []string, cmd insteon.Command) error {
+	if len(args) < 2 {
+		return fmt.Errorf("scene name must be specified")
+	}
+
+	selected, err := findScenes(args[1:2])
+	if err == nil {
+		err = insteon.TriggerScene(selected[0], modem, cmd)
+	}
+	return err
+}
+
+func sceneOnCmd(args []string, next cli.NextFunc) error {
+	return sceneTrigger(args, insteon.CmdLightOn)
+}
+
+func sceneOffCmd(args []string, next cli.NextFunc) error {
+	return sceneTrigger(args, insteon.CmdLightOff)
+}
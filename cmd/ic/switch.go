@@ -44,7 +44,7 @@ func swCmd(args []string, next cli.NextFunc) (err error) {
 		return fmt.Errorf("invalid device address: %v", err)
 	}
 
-	device, err = devConnect(modem.Network, addr)
+	device, err = devConnect(addr)
 	if err == nil {
 		var ok bool
 		if sw, ok = device.(insteon.Switch); ok {
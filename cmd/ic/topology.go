@@ -0,0 +1,119 @@
+// Copyright 2018 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/abates/cli"
+	"github.com/abates/insteon"
+)
+
+func init() {
+	Commands.Register("topology", "[dot|json]", "Export the controller/responder links of every known device", topologyCmd)
+}
+
+// readDatabases reads the link databases of the modem, every device in
+// the product database and every device linked to the modem.  Devices
+// that can't be reached are skipped.  The devices that were read are
+// returned along with their databases and the addresses of the devices
+// whose databases couldn't be read
+func readDatabases() ([]insteon.DeviceLinks, map[insteon.Address]insteon.LinkableDevice, []insteon.Address, error) {
+	links, err := modem.Links()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	databases := []insteon.DeviceLinks{{Info: insteon.DeviceInfo{Address: modem.Address(), Name: "PLM"}, Links: links}}
+	linkables := map[insteon.Address]insteon.LinkableDevice{modem.Address(): modem}
+
+	devices, err := network.Devices()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	known := make(map[insteon.Address]bool)
+	for _, info := range devices {
+		known[info.Address] = true
+	}
+
+	for _, link := range links {
+		if link.Flags.InUse() && !known[link.Address] {
+			known[link.Address] = true
+			devices = append(devices, insteon.DeviceInfo{Address: link.Address})
+		}
+	}
+
+	unread := []insteon.Address{}
+	for _, info := range devices {
+		device, err := network.Dial(info.Address)
+		read := false
+		if err == nil {
+			if linkable, ok := device.(insteon.LinkableDevice); ok {
+				insteon.SetPriority(device, insteon.PriorityBackground)
+				links, err = linkable.Links()
+				if err == nil {
+					databases = append(databases, insteon.DeviceLinks{Info: info, Links: links})
+					linkables[info.Address] = linkable
+					read = true
+				}
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read links from %s: %v\n", info.Address, err)
+		}
+
+		if !read {
+			unread = append(unread, info.Address)
+		}
+	}
+	return databases, linkables, unread, nil
+}
+
+func topologyCmd(args []string, next cli.NextFunc) error {
+	format := "dot"
+	if len(args) > 0 {
+		format = args[0]
+	}
+
+	if format != "dot" && format != "json" {
+		return fmt.Errorf("unknown format %q, expected dot or json", format)
+	}
+
+	databases, _, _, err := readDatabases()
+	if err != nil {
+		return err
+	}
+
+	topology := insteon.NewTopology(databases...)
+	if format == "json" {
+		var buf []byte
+		buf, err = json.MarshalIndent(topology, "", "  ")
+		if err == nil {
+			_, err = fmt.Printf("%s\n", buf)
+		}
+	} else {
+		err = topology.WriteDOT(os.Stdout)
+	}
+
+	if err == nil {
+		if oneSided := topology.OneSided(); len(oneSided) > 0 {
+			fmt.Fprintf(os.Stderr, "%d one-sided links found\n", len(oneSided))
+		}
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// insteond owns the connection to a PLM and exposes the Insteon network
+// over HTTP so that multiple programs can share a single modem.  See the
+// rest package for a description of the HTTP resources
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/metrics"
+	"github.com/abates/insteon/plm"
+	"github.com/abates/insteon/rest"
+	"github.com/tarm/serial"
+)
+
+var (
+	serialPortFlag string
+	listenFlag     string
+	scenesFlag     string
+	logLevelFlag   string
+	timeoutFlag    time.Duration
+	metricsFlag    bool
+)
+
+func init() {
+	flag.StringVar(&serialPortFlag, "port", "/dev/ttyUSB0", "serial port connected to a PLM")
+	flag.StringVar(&listenFlag, "listen", ":8080", "address to listen for HTTP requests")
+	flag.StringVar(&scenesFlag, "scenes", "", "file used to store scene definitions")
+	flag.StringVar(&logLevelFlag, "log", "none", "Log Level {none|info|debug|trace}")
+	flag.DurationVar(&timeoutFlag, "timeout", 5*time.Second, "read/write timeout duration")
+	flag.BoolVar(&metricsFlag, "metrics", false, "serve Prometheus metrics at /metrics")
+}
+
+type sceneConfig struct {
+	Scenes []*insteon.Scene `json:"scenes"`
+}
+
+func loadScenes(filename string) ([]*insteon.Scene, error) {
+	config := sceneConfig{}
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err == nil {
+		err = json.Unmarshal(buf, &config)
+	}
+	return config.Scenes, err
+}
+
+func saveScenes(filename string) func([]*insteon.Scene) error {
+	return func(scenes []*insteon.Scene) error {
+		buf, err := json.MarshalIndent(&sceneConfig{Scenes: scenes}, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(filename, buf, 0644)
+		}
+		return err
+	}
+}
+
+func setLogLevel(level string) error {
+	switch level {
+	case "none":
+	case "info":
+		insteon.Log.Level(insteon.LevelInfo)
+	case "debug":
+		insteon.Log.Level(insteon.LevelDebug)
+	case "trace":
+		insteon.Log.Level(insteon.LevelTrace)
+	default:
+		return fmt.Errorf("valid log levels {none|info|debug|trace}")
+	}
+	return nil
+}
+
+func run() error {
+	err := setLogLevel(logLevelFlag)
+	if err != nil {
+		return err
+	}
+
+	open := func() (io.ReadWriteCloser, error) {
+		return serial.OpenPort(&serial.Config{Name: serialPortFlag, Baud: 19200})
+	}
+
+	// the daemon keeps running when the PLM is unplugged and reconnects
+	// once the serial port can be opened again
+	s, err := plm.NewReconnector(open, plm.ReconnectConfig{})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	eventCh := make(chan plm.ConnectionEvent, 1)
+	s.Subscribe(eventCh)
+	go func() {
+		for event := range eventCh {
+			if event.Err == nil {
+				insteon.Log.Infof("PLM %v", event.State)
+			} else {
+				insteon.Log.Infof("PLM %v: %v", event.State, event.Err)
+			}
+		}
+	}()
+
+	modem := plm.New(plm.NewPort(s, timeoutFlag), timeoutFlag)
+	defer modem.Close()
+
+	server := rest.New(modem, modem.Network, modem.Network.DB)
+	if scenesFlag != "" {
+		scenes, err := loadScenes(scenesFlag)
+		if err != nil {
+			return fmt.Errorf("failed to load scenes from %s: %v", scenesFlag, err)
+		}
+		server.SetScenes(scenes)
+		server.SaveScenes = saveScenes(scenesFlag)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", server)
+	if metricsFlag {
+		m := metrics.New()
+		m.Register()
+		mux.Handle("/metrics", m)
+	}
+
+	insteon.Log.Infof("Listening on %s", listenFlag)
+	return http.ListenAndServe(listenFlag, mux)
+}
+
+func main() {
+	flag.Parse()
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -23,6 +23,8 @@ type connection struct {
 	match   []Command
 	version EngineVersion
 	timeout time.Duration
+	policy  RetryPolicy
+	quality *qualityTracker
 
 	sendCh         chan *MessageRequest
 	upstreamSendCh chan<- *MessageRequest
@@ -32,12 +34,14 @@ type connection struct {
 	queue []*MessageRequest
 }
 
-func newConnection(upstreamSendCh chan<- *MessageRequest, upstreamRecvCh <-chan *Message, addr Address, version EngineVersion, timeout time.Duration, match ...Command) *connection {
+func newConnection(upstreamSendCh chan<- *MessageRequest, upstreamRecvCh <-chan *Message, addr Address, version EngineVersion, timeout time.Duration, policy RetryPolicy, quality *qualityTracker, match ...Command) *connection {
 	conn := &connection{
 		addr:    addr,
 		match:   match,
 		version: version,
 		timeout: timeout,
+		policy:  policy,
+		quality: quality,
 
 		sendCh:         make(chan *MessageRequest, 1),
 		upstreamSendCh: upstreamSendCh,
@@ -72,7 +76,12 @@ func (conn *connection) process() {
 		case <-time.After(conn.timeout):
 			// prevent head of line blocking for a lost/nonexistant Ack
 			if len(conn.queue) > 0 && conn.queue[0].timeout.Before(time.Now()) {
+				if conn.retry(ErrReadTimeout) {
+					continue
+				}
+				conn.quality.failed(conn.addr)
 				conn.queue[0].Err = ErrReadTimeout
+				observe().MessageFailed(conn.addr, ErrReadTimeout)
 				conn.queue[0].DoneCh <- conn.queue[0]
 				conn.queue = conn.queue[1:]
 				conn.send()
@@ -117,15 +126,23 @@ func (conn *connection) receiveAck(msg *Message) {
 	if len(conn.queue) > 0 {
 		request := conn.queue[0]
 		if msg.Command[1]&0xff == request.Message.Command[1]&0xff {
-			conn.queue[0].Ack = msg
+			var err error
 			if msg.Flags.Type() == MsgTypeDirectNak {
 				if VerI1 <= conn.version && conn.version <= VerI2 {
-					request.Err = errLookup(msg.Command)
+					err = errLookup(msg.Command)
 				} else if conn.version == VerI2Cs {
-					request.Err = i2csErrLookup(msg.Command)
+					err = i2csErrLookup(msg.Command)
 				}
 			}
 
+			conn.quality.acked(conn.addr, msg, time.Since(request.sent))
+			if err != nil && conn.retry(err) {
+				return
+			}
+			request.Ack = msg
+			request.Err = err
+			observe().MessageAcked(conn.addr, time.Since(request.sent), Cause(request.Err))
+
 			conn.queue[0].DoneCh <- conn.queue[0]
 
 			conn.queue = conn.queue[1:]
@@ -154,10 +171,35 @@ func (conn *connection) receive(msg *Message) {
 	}
 }
 
+// retry resends the request at the head of the queue if err is
+// retriable and the request has not used up its retries.  If retry
+// returns false the request should fail with err
+func (conn *connection) retry(err error) bool {
+	request := conn.queue[0]
+	if !Retriable(err) || request.Attempts == 0 || request.Attempts > conn.policy.Retries {
+		return false
+	}
+
+	Log.Debugf("Attempt %d sending %v to %v failed: %v", request.Attempts, request.Message.Command, conn.addr, err)
+	observe().MessageRetried(conn.addr, request.Attempts, Cause(err))
+	conn.quality.retried(conn.addr)
+	request.Message.Flags = conn.policy.hops(request.Message.Flags)
+	conn.send()
+	return true
+}
+
 func (conn *connection) send() {
 	if len(conn.queue) > 0 {
 		request := conn.queue[0]
-		request.timeout = time.Now().Add(conn.timeout)
+		if request.Attempts == 0 && request.Message != nil {
+			// start with the number of hops that recent ACKs needed
+			if hops, found := conn.quality.maxHops(conn.addr); found {
+				request.Message.Flags = request.Message.Flags.setHops(hops)
+			}
+		}
+		request.Attempts++
+		request.sent = time.Now()
+		request.timeout = request.sent.Add(conn.timeout)
 		request.Message.Dst = conn.addr
 
 		oldCh := request.DoneCh
@@ -168,6 +210,8 @@ func (conn *connection) send() {
 		request.DoneCh = oldCh
 
 		if request.Err != nil {
+			observe().MessageFailed(conn.addr, Cause(request.Err))
+			conn.quality.failed(conn.addr)
 			conn.queue = conn.queue[1:]
 			request.DoneCh <- request
 		}
@@ -22,7 +22,7 @@ import (
 func newTestConnection(dst Address) (*connection, chan *MessageRequest, chan *Message) {
 	sendCh := make(chan *MessageRequest, 10)
 	recvCh := make(chan *Message, 10)
-	return newConnection(sendCh, recvCh, dst, 1, time.Millisecond), sendCh, recvCh
+	return newConnection(sendCh, recvCh, dst, 1, time.Millisecond, RetryPolicy{}, nil), sendCh, recvCh
 }
 
 // TODO need to rewrite this test because it sucks and is full
@@ -168,3 +168,47 @@ func TestConnectionSend(t *testing.T) {
 		t.Errorf("Expected %v got %v", ErrReadTimeout, request.Err)
 	}
 }
+
+type testObserver struct {
+	acked   []error
+	failed  []error
+	retried []error
+}
+
+func (to *testObserver) MessageAcked(address Address, latency time.Duration, err error) {
+	to.acked = append(to.acked, err)
+}
+
+func (to *testObserver) MessageFailed(address Address, err error) {
+	to.failed = append(to.failed, err)
+}
+
+func (to *testObserver) MessageRetried(address Address, attempt int, err error) {
+	to.retried = append(to.retried, err)
+}
+
+func TestConnectionObserver(t *testing.T) {
+	observer := &testObserver{}
+	SetObserver(observer)
+	defer SetObserver(nil)
+
+	conn := &connection{addr: testDstAddr, version: VerI1}
+	doneCh := make(chan *MessageRequest, 2)
+	conn.queue = []*MessageRequest{
+		{Message: &Message{Command: Command{0x00, 0x11, 0xff}}, DoneCh: doneCh},
+		{Message: &Message{Command: Command{0x00, 0x11, 0x00}}, DoneCh: doneCh},
+	}
+	upstreamSendCh := make(chan *MessageRequest, 1)
+	conn.upstreamSendCh = upstreamSendCh
+	go func() {
+		request := <-upstreamSendCh
+		request.DoneCh <- request
+	}()
+
+	conn.receive(&Message{Src: testDstAddr, Flags: StandardDirectAck, Command: Command{0x00, 0x11, 0xff}})
+	conn.receive(&Message{Src: testDstAddr, Flags: StandardDirectNak, Command: Command{0x00, 0x11, 0x01}})
+
+	if len(observer.acked) != 2 || observer.acked[0] != nil || observer.acked[1] != ErrUnexpectedResponse {
+		t.Errorf("expected [<nil> %v] got %v", ErrUnexpectedResponse, observer.acked)
+	}
+}
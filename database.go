@@ -15,16 +15,20 @@
 package insteon
 
 import (
+	"bytes"
+	"encoding/json"
+	"sort"
 	"sync"
 )
 
 // DeviceInfo is a record of information about known
 // devices on the network
 type DeviceInfo struct {
-	Address         Address
-	DevCat          DevCat
-	FirmwareVersion FirmwareVersion
-	EngineVersion   EngineVersion
+	Address         Address         `json:"address"`
+	DevCat          DevCat          `json:"devCat"`
+	FirmwareVersion FirmwareVersion `json:"firmwareVersion"`
+	EngineVersion   EngineVersion   `json:"engineVersion"`
+	Name            string          `json:"name,omitempty"`
 }
 
 // Complete indicates whether or not a record appears to be complete.  A complete
@@ -42,7 +46,15 @@ type ProductDatabase interface {
 	UpdateDevCat(address Address, devCat DevCat)
 	UpdateEngineVersion(address Address, engineVersion EngineVersion)
 	UpdateFirmwareVersion(address Address, firmwareVersion FirmwareVersion)
+
+	// UpdateName assigns a human readable name to the device
+	UpdateName(address Address, name string)
+
 	Find(address Address) (deviceInfo DeviceInfo, found bool)
+
+	// Devices returns the information for every device in the
+	// database, ordered by address
+	Devices() []DeviceInfo
 }
 
 type productDatabase struct {
@@ -51,7 +63,9 @@ type productDatabase struct {
 }
 
 // NewProductDB will initialize a product database for
-// use in the network object
+// use in the network object. The returned database can be
+// persisted by marshalling it to JSON and restored by
+// unmarshalling the JSON into a new database
 func NewProductDB() ProductDatabase {
 	return &productDatabase{
 		devices: make(map[Address]*DeviceInfo),
@@ -68,6 +82,20 @@ func (pdb *productDatabase) Find(address Address) (deviceInfo DeviceInfo, found
 	return deviceInfo, found
 }
 
+func (pdb *productDatabase) Devices() []DeviceInfo {
+	pdb.mutex.Lock()
+	devices := make([]DeviceInfo, 0, len(pdb.devices))
+	for _, di := range pdb.devices {
+		devices = append(devices, *di)
+	}
+	pdb.mutex.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		return bytes.Compare(devices[i].Address[:], devices[j].Address[:]) < 0
+	})
+	return devices
+}
+
 func (pdb *productDatabase) update(address Address, callback func(*DeviceInfo)) {
 	pdb.mutex.Lock()
 	deviceInfo, found := pdb.devices[address]
@@ -92,3 +120,27 @@ func (pdb *productDatabase) UpdateEngineVersion(address Address, engineVersion E
 func (pdb *productDatabase) UpdateDevCat(address Address, devCat DevCat) {
 	pdb.update(address, func(deviceInfo *DeviceInfo) { deviceInfo.DevCat = devCat })
 }
+
+func (pdb *productDatabase) UpdateName(address Address, name string) {
+	pdb.update(address, func(deviceInfo *DeviceInfo) { deviceInfo.Name = name })
+}
+
+// MarshalJSON encodes the database as a list of DeviceInfo objects
+func (pdb *productDatabase) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pdb.Devices())
+}
+
+// UnmarshalJSON adds the list of DeviceInfo objects to the database.
+// Existing records for the same addresses are replaced
+func (pdb *productDatabase) UnmarshalJSON(data []byte) error {
+	devices := []DeviceInfo{}
+	err := json.Unmarshal(data, &devices)
+	if err == nil {
+		pdb.mutex.Lock()
+		for i := range devices {
+			pdb.devices[devices[i].Address] = &devices[i]
+		}
+		pdb.mutex.Unlock()
+	}
+	return err
+}
@@ -15,6 +15,7 @@
 package insteon
 
 import (
+	"encoding/json"
 	"sync"
 	"testing"
 )
@@ -65,6 +66,10 @@ func (tpd *testProductDB) UpdateFirmwareVersion(address Address, firmwareVersion
 	tpd.updates.Store("FirmwareVersion", true)
 }
 
+func (tpd *testProductDB) UpdateName(address Address, name string) {
+	tpd.updates.Store("Name", true)
+}
+
 func (tpd *testProductDB) Find(address Address) (deviceInfo DeviceInfo, found bool) {
 	if tpd.deviceInfo == nil {
 		return DeviceInfo{}, false
@@ -72,6 +77,13 @@ func (tpd *testProductDB) Find(address Address) (deviceInfo DeviceInfo, found bo
 	return *tpd.deviceInfo, true
 }
 
+func (tpd *testProductDB) Devices() []DeviceInfo {
+	if tpd.deviceInfo == nil {
+		return nil
+	}
+	return []DeviceInfo{*tpd.deviceInfo}
+}
+
 func TestProductDatabaseUpdateFind(t *testing.T) {
 	address := Address{0, 1, 2}
 	tests := []struct {
@@ -81,6 +93,7 @@ func TestProductDatabaseUpdateFind(t *testing.T) {
 		{func(pdb *productDatabase) { pdb.UpdateFirmwareVersion(address, FirmwareVersion(42)) }, func(di DeviceInfo) bool { return di.FirmwareVersion == FirmwareVersion(42) }},
 		{func(pdb *productDatabase) { pdb.UpdateEngineVersion(address, EngineVersion(42)) }, func(di DeviceInfo) bool { return di.EngineVersion == EngineVersion(42) }},
 		{func(pdb *productDatabase) { pdb.UpdateDevCat(address, DevCat{42, 42}) }, func(di DeviceInfo) bool { return di.DevCat == DevCat{42, 42} }},
+		{func(pdb *productDatabase) { pdb.UpdateName(address, "Kitchen") }, func(di DeviceInfo) bool { return di.Name == "Kitchen" }},
 	}
 
 	for i, test := range tests {
@@ -99,3 +112,50 @@ func TestProductDatabaseUpdateFind(t *testing.T) {
 		}
 	}
 }
+
+func TestProductDatabaseDevices(t *testing.T) {
+	pdb := NewProductDB()
+	pdb.UpdateEngineVersion(Address{3, 2, 1}, VerI2)
+	pdb.UpdateEngineVersion(Address{1, 2, 3}, VerI1)
+
+	devices := pdb.Devices()
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices got %d", len(devices))
+	}
+
+	if devices[0].Address != (Address{1, 2, 3}) || devices[1].Address != (Address{3, 2, 1}) {
+		t.Errorf("expected devices ordered by address got %v and %v", devices[0].Address, devices[1].Address)
+	}
+}
+
+func TestProductDatabaseMarshalJSON(t *testing.T) {
+	pdb := NewProductDB()
+	pdb.UpdateDevCat(Address{1, 2, 3}, DevCat{0x01, 0x20})
+	pdb.UpdateFirmwareVersion(Address{1, 2, 3}, FirmwareVersion(0x45))
+	pdb.UpdateEngineVersion(Address{1, 2, 3}, VerI2Cs)
+	pdb.UpdateName(Address{1, 2, 3}, "Kitchen")
+	pdb.UpdateDevCat(Address{4, 5, 6}, DevCat{0x02, 0x2a})
+
+	buf, err := json.Marshal(pdb)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := NewProductDB()
+	err = json.Unmarshal(buf, restored)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := pdb.Devices()
+	got := restored.Devices()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d devices got %d", len(expected), len(got))
+	}
+
+	for i, info := range expected {
+		if got[i] != info {
+			t.Errorf("expected %+v got %+v", info, got[i])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import "time"
+
+// DefaultDedupWindow is the default time that repeats of a broadcast or
+// cleanup message are suppressed for
+const DefaultDedupWindow = 500 * time.Millisecond
+
+// dedupKey identifies a logical message.  The hop bits of the flags are
+// ignored since they change every time the message is repeated
+type dedupKey struct {
+	src     Address
+	dst     Address
+	command Command
+	flags   Flags
+}
+
+// dedupFilter remembers when broadcast and cleanup messages were first
+// seen so that the repeats can be dropped.  Direct messages and their
+// ACKs are never filtered, they are matched by the device connections
+// and the same command is often legitimately sent several times in a row
+type dedupFilter struct {
+	seen map[dedupKey]time.Time
+}
+
+func newDedupFilter() *dedupFilter {
+	return &dedupFilter{seen: make(map[dedupKey]time.Time)}
+}
+
+func dedupable(msg *Message) bool {
+	switch msg.Flags.Type() {
+	case MsgTypeDirect, MsgTypeDirectAck, MsgTypeDirectNak:
+		return false
+	}
+	return true
+}
+
+// duplicate returns true if the same message was already seen less than
+// window ago
+func (df *dedupFilter) duplicate(msg *Message, now time.Time, window time.Duration) bool {
+	if df == nil || window <= 0 || !dedupable(msg) {
+		return false
+	}
+
+	for key, seen := range df.seen {
+		if now.Sub(seen) >= window {
+			delete(df.seen, key)
+		}
+	}
+
+	key := dedupKey{src: msg.Src, dst: msg.Dst, command: msg.Command, flags: msg.Flags & 0xf0}
+	if _, found := df.seen[key]; found {
+		return true
+	}
+	df.seen[key] = now
+	return false
+}
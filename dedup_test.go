@@ -0,0 +1,61 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupFilter(t *testing.T) {
+	src := Address{1, 2, 3}
+	group := Address{0, 0, 1}
+	plm := Address{4, 5, 6}
+	broadcast := func(flags Flags, cmd1 byte) *Message {
+		return &Message{Src: src, Dst: group, Flags: flags, Command: Command{0x00, cmd1, 0x00}}
+	}
+	cleanup := &Message{Src: src, Dst: plm, Flags: Flags(0x4f), Command: Command{0x00, 0x11, 0x01}}
+	ack := &Message{Src: src, Dst: plm, Flags: StandardDirectAck, Command: Command{0x00, 0x11, 0xff}}
+
+	tests := []struct {
+		desc     string
+		input    *Message
+		offset   time.Duration
+		expected bool
+	}{
+		{"broadcast", broadcast(0xcb, 0x11), 0, false},
+		{"first repeat", broadcast(0xc7, 0x11), 10 * time.Millisecond, true},
+		{"second repeat", broadcast(0xc3, 0x11), 20 * time.Millisecond, true},
+		{"different command", broadcast(0xcb, 0x13), 30 * time.Millisecond, false},
+		{"cleanup", cleanup, 40 * time.Millisecond, false},
+		{"cleanup repeat", cleanup, 50 * time.Millisecond, true},
+		{"direct ack", ack, 60 * time.Millisecond, false},
+		{"direct ack again", ack, 70 * time.Millisecond, false},
+		{"after window", broadcast(0xcb, 0x11), 100 * time.Millisecond, false},
+		{"repeat after window", broadcast(0xc7, 0x11), 110 * time.Millisecond, true},
+	}
+
+	df := newDedupFilter()
+	start := time.Now()
+	for _, test := range tests {
+		if got := df.duplicate(test.input, start.Add(test.offset), 100*time.Millisecond); got != test.expected {
+			t.Errorf("%s: expected %v got %v", test.desc, test.expected, got)
+		}
+	}
+
+	if df.duplicate(broadcast(0xc7, 0x11), start, 0) {
+		t.Errorf("expected a zero window to disable deduplication")
+	}
+}
@@ -77,6 +77,10 @@ type CommandRequest struct {
 	// RecvCh to receive subsuquent messages
 	RecvCh chan<- *CommandResponse
 
+	// Priority of the request.  If not set, the priority of the device
+	// is used
+	Priority Priority
+
 	// DoneCh that will be written to by the connection once the request is complete
 	DoneCh chan<- *CommandRequest
 
@@ -86,6 +90,9 @@ type CommandRequest struct {
 	// Err includes any error that occurred while trying to send the request
 	Err error
 
+	// Attempts is the number of times the message was sent to the device
+	Attempts int
+
 	timeout time.Time
 }
 
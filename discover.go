@@ -0,0 +1,146 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import "sort"
+
+// DiscoveredDevice is an entry in the inventory returned by
+// Network.Discover
+type DiscoveredDevice struct {
+	// Info is the product database entry for the device.  It only
+	// contains the address if the device is unreachable
+	Info DeviceInfo
+
+	// Reachable indicates whether the device responded to the engine
+	// version request
+	Reachable bool
+
+	// ReferencedBy lists the devices (including the modem) that have
+	// a link to this device in their All-Link database
+	ReferencedBy []Address
+
+	// Links is the number of in-use records found in the device's
+	// All-Link database
+	Links int
+
+	// Err is the reason the device could not be reached, or the error
+	// that occurred reading its All-Link database
+	Err error
+}
+
+// Discover builds an inventory of the Insteon network.  Discovery starts
+// from the modem's All-Link database, each referenced address is queried
+// for its engine version and device information (which is recorded in the
+// product database) and then the device's own All-Link database is read
+// in order to find further addresses.  This finds devices that the modem
+// is not directly linked to.  Devices that do not respond are included in
+// the inventory, along with the devices that reference them.  The
+// inventory is ordered by address
+func (network *Network) Discover(modem LinkableDevice) ([]*DiscoveredDevice, error) {
+	links, err := modem.Links()
+	if err != nil {
+		return nil, err
+	}
+
+	root := modem.Address()
+	discovered := make(map[Address]*DiscoveredDevice)
+	queue := []Address{}
+	reference := func(from Address, links []*LinkRecord) {
+		for _, link := range links {
+			if !link.Flags.InUse() || link.Address == root || link.Address == (Address{}) {
+				continue
+			}
+
+			dd, found := discovered[link.Address]
+			if !found {
+				dd = &DiscoveredDevice{Info: DeviceInfo{Address: link.Address}}
+				discovered[link.Address] = dd
+				queue = append(queue, link.Address)
+			}
+
+			if !containsAddress(dd.ReferencedBy, from) {
+				dd.ReferencedBy = append(dd.ReferencedBy, from)
+			}
+		}
+	}
+
+	reference(root, links)
+	for len(queue) > 0 {
+		dd := discovered[queue[0]]
+		queue = queue[1:]
+
+		Log.Debugf("Discovering %v", dd.Info.Address)
+		links, dd.Err = network.discover(dd)
+		dd.Links = len(links)
+		reference(dd.Info.Address, links)
+	}
+
+	inventory := make([]*DiscoveredDevice, 0, len(discovered))
+	for _, dd := range discovered {
+		inventory = append(inventory, dd)
+	}
+
+	sort.Slice(inventory, func(i, j int) bool {
+		return inventory[i].Info.Address.String() < inventory[j].Info.Address.String()
+	})
+	return inventory, nil
+}
+
+// discover queries a single device and returns the in-use records of its
+// All-Link database
+func (network *Network) discover(dd *DiscoveredDevice) (links []*LinkRecord, err error) {
+	address := dd.Info.Address
+	dd.Info.EngineVersion, err = network.EngineVersion(address)
+	if err == ErrNotLinked {
+		// only i2cs devices refuse to respond when not linked
+		network.DB.UpdateEngineVersion(address, VerI2Cs)
+		dd.Info.EngineVersion = VerI2Cs
+	} else if err != nil {
+		return nil, err
+	} else if _, err := network.IDRequest(address); err != nil {
+		Log.Infof("Failed to retrieve device info for %v: %v", address, err)
+	}
+
+	dd.Reachable = true
+	if info, found := network.DB.Find(address); found {
+		dd.Info = info
+	}
+
+	device, err := network.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if linkable, ok := device.(LinkableDevice); ok {
+		SetPriority(device, PriorityBackground)
+		var all []*LinkRecord
+		all, err = linkable.Links()
+		for _, link := range all {
+			if link.Flags.InUse() {
+				links = append(links, link)
+			}
+		}
+	}
+	return links, err
+}
+
+func containsAddress(addresses []Address, address Address) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
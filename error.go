@@ -49,13 +49,19 @@ type traceError struct {
 // isError will determine if `check` is wrapping an underlying error.
 // If so, the underlying error is compared to `err`.
 func isError(check, err error) bool {
-	switch e := check.(type) {
+	return Cause(check) == err
+}
+
+// Cause returns the underlying error if err is wrapping another error,
+// otherwise err is returned
+func Cause(err error) error {
+	switch e := err.(type) {
 	case *traceError:
-		check = e.Cause
+		return e.Cause
 	case *BufError:
-		check = e.Cause
+		return e.Cause
 	}
-	return check == err
+	return err
 }
 
 // Error indicates the underlying cause of the error as well as the file and line that the error occurred
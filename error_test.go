@@ -74,6 +74,23 @@ func TestIsError(t *testing.T) {
 	}
 }
 
+func TestCause(t *testing.T) {
+	tests := []struct {
+		input    error
+		expected error
+	}{
+		{newTraceError(ErrReadTimeout), ErrReadTimeout},
+		{newBufError(ErrBufferTooShort, 1, 0), ErrBufferTooShort},
+		{ErrNak, ErrNak},
+	}
+
+	for i, test := range tests {
+		if got := Cause(test.input); got != test.expected {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expected, got)
+		}
+	}
+}
+
 func TestTraceError(t *testing.T) {
 	err := newTraceError(ErrBufferTooShort)
 	if _, ok := err.(*traceError); !ok {
@@ -0,0 +1,113 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package insteon
+
+import (
+	"bytes"
+	"testing"
+)
+
+// checkShortBuffer fails the test if a buffer shorter than need was not
+// rejected with a BufError
+func checkShortBuffer(t *testing.T, data []byte, need int, err error) {
+	if len(data) < need {
+		if _, ok := err.(*BufError); !ok || !isError(err, ErrBufferTooShort) {
+			t.Errorf("expected buffer error for %d bytes got %v", len(data), err)
+		}
+	}
+}
+
+func FuzzMessage(f *testing.F) {
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 0x0f, 0x11, 0xff})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 0x1f, 0x2f, 0x00, 0, 1, 0x0f, 0xff, 0, 0xe2, 1, 7, 8, 9, 0, 0, 0, 0})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 0x1f})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := &Message{}
+		err := msg.UnmarshalBinary(data)
+		checkShortBuffer(t, data, StandardMsgLen, err)
+		if err != nil {
+			return
+		}
+
+		buf, _ := msg.MarshalBinary()
+		other := &Message{}
+		if err = other.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("failed to unmarshal %x: %v", buf, err)
+		}
+
+		if msg.Src != other.Src || msg.Dst != other.Dst || msg.Flags != other.Flags || msg.Command != other.Command || !bytes.Equal(msg.Payload, other.Payload) {
+			t.Errorf("expected %v got %v", msg, other)
+		}
+	})
+}
+
+func FuzzLinkRecord(f *testing.F) {
+	f.Add([]byte{0xe2, 0x01, 1, 2, 3, 0x03, 0x1c, 0x01})
+	f.Add([]byte{0x00, 0x00, 0, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		link := &LinkRecord{}
+		err := link.UnmarshalBinary(data)
+		checkShortBuffer(t, data, 8, err)
+		if err != nil {
+			return
+		}
+
+		buf, _ := link.MarshalBinary()
+		if !bytes.Equal(buf, data[0:8]) {
+			t.Errorf("expected %x got %x", data[0:8], buf)
+		}
+	})
+}
+
+func FuzzLinkRequest(f *testing.F) {
+	f.Add([]byte{0x00, 0x00, 0x0f, 0xff, 0x00})
+	f.Add([]byte{0x00, 0x01, 0x0f, 0xff, 0x00, 0xe2, 0x01, 1, 2, 3, 0x03, 0x1c, 0x01})
+	f.Add([]byte{0x00, 0x02, 0x0f, 0xff, 0x08, 0xe2, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		lr := &LinkRequest{}
+		err := lr.UnmarshalBinary(data)
+		checkShortBuffer(t, data, 5, err)
+		if len(data) >= 5 && (lr.Type == 0x01 || lr.Type == 0x02) {
+			checkShortBuffer(t, data, 13, err)
+		}
+	})
+}
+
+func FuzzDimmerConfig(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		config := &DimmerConfig{}
+		checkShortBuffer(t, data, 14, config.UnmarshalBinary(data))
+	})
+}
+
+func FuzzSwitchConfig(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 4, 5, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		config := &SwitchConfig{}
+		checkShortBuffer(t, data, 14, config.UnmarshalBinary(data))
+	})
+}
+
+func FuzzProductData(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 255, 0, 0, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pd := &ProductData{}
+		checkShortBuffer(t, data, 14, pd.UnmarshalBinary(data))
+	})
+}
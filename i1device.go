@@ -20,6 +20,8 @@ import (
 
 // I1Device provides remote communication to version 1 engines
 type I1Device struct {
+	devicePriority
+
 	address         Address
 	devCat          DevCat
 	firmwareVersion FirmwareVersion
@@ -110,13 +112,26 @@ func (i1 *I1Device) send() {
 			flags = ExtendedDirectMessage
 		}
 
+		priority := request.Priority
+		if priority == PriorityDefault {
+			priority = i1.Priority()
+		}
+
+		// link database transfers are bulk operations that should not
+		// hold up other traffic
+		if priority == PriorityDefault && flags == ExtendedDirectMessage && request.Command[1] == CmdReadWriteALDB[1] {
+			priority = PriorityBackground
+		}
+
+		request.timeout = time.Now().Add(i1.timeout)
 		i1.upstreamSendCh <- &MessageRequest{
 			Message: &Message{
 				Flags:   flags,
 				Command: request.Command,
 				Payload: request.Payload,
 			},
-			DoneCh: i1.doneCh,
+			Priority: priority,
+			DoneCh:   i1.doneCh,
 		}
 	}
 }
@@ -132,6 +147,7 @@ func (i1 *I1Device) receiveAck(request *MessageRequest) {
 	if len(i1.queue) > 0 {
 		i1.queue[0].Ack = request.Ack
 		i1.queue[0].Err = request.Err
+		i1.queue[0].Attempts = request.Attempts
 		i1.queue[0].DoneCh <- i1.queue[0]
 		if i1.queue[0].RecvCh != nil {
 			if i1.queue[0].Err == nil {
@@ -146,13 +162,14 @@ func (i1 *I1Device) receiveAck(request *MessageRequest) {
 	}
 }
 
-func (i1 *I1Device) sendCommand(command Command, payload []byte, recvCh chan<- *CommandResponse) (response Command, err error) {
+func (i1 *I1Device) sendCommand(command Command, payload []byte, priority Priority, recvCh chan<- *CommandResponse) (response Command, err error) {
 	doneCh := make(chan *CommandRequest, 1)
 	request := &CommandRequest{
-		Command: command,
-		Payload: payload,
-		DoneCh:  doneCh,
-		RecvCh:  recvCh,
+		Command:  command,
+		Payload:  payload,
+		Priority: priority,
+		DoneCh:   doneCh,
+		RecvCh:   recvCh,
 	}
 
 	i1.sendCh <- request
@@ -170,7 +187,13 @@ func (i1 *I1Device) sendCommand(command Command, payload []byte, recvCh chan<- *
 // length message is used to deliver the commands. The command bytes from the
 // response ack are returned as well as any error
 func (i1 *I1Device) SendCommand(command Command, payload []byte) (response Command, err error) {
-	return i1.sendCommand(command, payload, nil)
+	return i1.sendCommand(command, payload, PriorityDefault, nil)
+}
+
+// SendCommandPriority is the same as SendCommand except that the command
+// is sent with the given priority rather than the device's priority
+func (i1 *I1Device) SendCommandPriority(command Command, payload []byte, priority Priority) (response Command, err error) {
+	return i1.sendCommand(command, payload, priority, nil)
 }
 
 // SendCommandAndListen performs the same function as SendCommand.  However, instead of returning
@@ -181,7 +204,7 @@ func (i1 *I1Device) SendCommand(command Command, payload []byte) (response Comma
 // more messages are expected.
 func (i1 *I1Device) SendCommandAndListen(command Command, payload []byte) (<-chan *CommandResponse, error) {
 	recvCh := make(chan *CommandResponse, 1)
-	_, err := i1.sendCommand(command, payload, recvCh)
+	_, err := i1.sendCommand(command, payload, PriorityDefault, recvCh)
 	return recvCh, err
 }
 
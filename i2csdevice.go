@@ -45,8 +45,14 @@ func (i2cs *I2CsDevice) String() string {
 // length message is used to deliver the commands. The command bytes from the
 // response ack are returned as well as any error
 func (i2cs *I2CsDevice) SendCommand(command Command, payload []byte) (response Command, err error) {
+	return i2cs.SendCommandPriority(command, payload, PriorityDefault)
+}
+
+// SendCommandPriority is the same as SendCommand except that the command
+// is sent with the given priority rather than the device's priority
+func (i2cs *I2CsDevice) SendCommandPriority(command Command, payload []byte, priority Priority) (response Command, err error) {
 	if command[1] == CmdSetOperatingFlags[1] && len(payload) == 0 {
 		payload = make([]byte, 14)
 	}
-	return i2cs.I2Device.SendCommand(command, payload)
+	return i2cs.I2Device.SendCommandPriority(command, payload, priority)
 }
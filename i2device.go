@@ -31,13 +31,57 @@ func NewI2Device(address Address, sendCh chan<- *MessageRequest, recvCh <-chan *
 // or it will replace an existing link-record that has been marked
 // as deleted
 func (i2 *I2Device) AddLink(newLink *LinkRecord) error {
-	return ErrNotImplemented
+	links, err := i2.Links()
+	if err == nil {
+		link := *newLink
+		link.Flags.setInUse()
+		var end bool
+		link.memAddress, end = nextLinkAddress(links)
+		if end {
+			// the new record replaces the end of database marker, so a
+			// new marker is written just after it first.  That way the
+			// database is still terminated if writing the record fails
+			if link.memAddress-8 < LastLinkDBAddress {
+				return ErrLinkDBFull
+			}
+			err = i2.WriteLink(&LinkRecord{memAddress: link.memAddress - 8})
+		}
+
+		if err == nil {
+			err = i2.WriteLink(&link)
+		}
+	}
+	return err
+}
+
+// nextLinkAddress returns the memory address of the first available
+// record in the list of links.  If there are no available records then
+// the address just past the last record (where the end of database
+// marker currently lives) is returned and end is true
+func nextLinkAddress(links []*LinkRecord) (address MemAddress, end bool) {
+	address = BaseLinkDBAddress
+	for _, link := range links {
+		if link.Flags.Available() {
+			return link.memAddress, false
+		}
+		address = link.memAddress - 8
+	}
+	return address, true
 }
 
 // RemoveLinks will either remove the link records from the device
 // All-Link database, or it will simply mark them as deleted
 func (i2 *I2Device) RemoveLinks(oldLinks ...*LinkRecord) error {
-	return ErrNotImplemented
+	links, err := i2.Links()
+	for _, oldLink := range oldLinks {
+		for _, link := range links {
+			if err == nil && link.Flags.InUse() && oldLink.Equal(link) {
+				link.Flags.setAvailable()
+				err = i2.WriteLink(link)
+			}
+		}
+	}
+	return err
 }
 
 // Links will retrieve the link-database from the device and
@@ -106,6 +150,33 @@ func (i2 *I2Device) WriteLink(link *LinkRecord) (err error) {
 	return err
 }
 
+// Compact moves the in-use records of the All-Link database down over
+// any available records, and then writes the end of database marker just
+// after the last in-use record.  Each record is written to its new
+// location before the marker is moved, so an interrupted compaction can
+// leave a duplicate link behind but never loses one
+func (i2 *I2Device) Compact() error {
+	links, err := i2.Links()
+	next := BaseLinkDBAddress
+	inUse := 0
+	for i := 0; i < len(links) && err == nil; i++ {
+		if links[i].Flags.InUse() {
+			if links[i].memAddress != next {
+				link := *links[i]
+				link.memAddress = next
+				err = i2.WriteLink(&link)
+			}
+			next -= 8
+			inUse++
+		}
+	}
+
+	if err == nil && inUse < len(links) {
+		err = i2.WriteLink(&LinkRecord{memAddress: next})
+	}
+	return err
+}
+
 // String returns the string "I2 Device (<address>)" where <address> is the destination
 // address of the device
 func (i2 *I2Device) String() string {
@@ -14,7 +14,10 @@
 
 package insteon
 
-import "testing"
+import (
+	"encoding"
+	"testing"
+)
 
 func TestI2DeviceIsLinkable(t *testing.T) {
 	device := Device(&I2Device{})
@@ -30,8 +33,6 @@ func TestI2DeviceCommands(t *testing.T) {
 		expectedCmd Command
 		expectedErr error
 	}{
-		{func(i2cs *I2Device) error { return i2cs.AddLink(nil) }, Command{}, ErrNotImplemented},
-		{func(i2cs *I2Device) error { return i2cs.RemoveLinks(nil) }, Command{}, ErrNotImplemented},
 		{func(i2cs *I2Device) error { return i2cs.EnterLinkingMode(10) }, CmdEnterLinkingMode.SubCommand(10), nil},
 		{func(i2cs *I2Device) error { return i2cs.EnterUnlinkingMode(10) }, CmdEnterUnlinkingMode.SubCommand(10), nil},
 		{func(i2cs *I2Device) error { return i2cs.ExitLinkingMode() }, CmdExitLinkingMode, nil},
@@ -87,6 +88,83 @@ func TestI2DeviceLinks(t *testing.T) {
 	}
 }
 
+func TestI2DeviceAddRemoveLinks(t *testing.T) {
+	inUse := &LinkRequest{MemAddress: 0x0fff, Type: 0x01, Link: &LinkRecord{Flags: 0xc0, Group: 1, Address: Address{1, 2, 3}}}
+	available := &LinkRequest{MemAddress: 0x0ff7, Type: 0x01, Link: &LinkRecord{Flags: 0x40, Group: 2, Address: Address{4, 5, 6}}}
+	end := &LinkRequest{MemAddress: 0x0fef, Type: 0x01, Link: &LinkRecord{}}
+	last := &LinkRequest{MemAddress: LastLinkDBAddress + 8, Type: 0x01, Link: &LinkRecord{Flags: 0xc0, Group: 1, Address: Address{1, 2, 3}}}
+	full := &LinkRequest{MemAddress: LastLinkDBAddress, Type: 0x01, Link: &LinkRecord{}}
+
+	tests := []struct {
+		callback        func(*I2Device) error
+		links           []*LinkRequest
+		expectedAddress MemAddress
+		expectedFlags   RecordControlFlags
+		expectedEnd     MemAddress
+		expectedErr     error
+	}{
+		{func(i2 *I2Device) error { return i2.AddLink(&LinkRecord{Flags: 0x00, Group: 3}) }, []*LinkRequest{inUse, end}, 0x0ff7, 0x80, 0x0fef, nil},
+		{func(i2 *I2Device) error { return i2.AddLink(&LinkRecord{Flags: 0x40, Group: 3}) }, []*LinkRequest{inUse, available, end}, 0x0ff7, 0xc0, 0, nil},
+		{func(i2 *I2Device) error { return i2.AddLink(&LinkRecord{Flags: 0x40, Group: 3}) }, []*LinkRequest{end}, BaseLinkDBAddress, 0xc0, BaseLinkDBAddress - 8, nil},
+		{func(i2 *I2Device) error { return i2.AddLink(&LinkRecord{Flags: 0x40, Group: 3}) }, []*LinkRequest{last, full}, 0, 0, 0, ErrLinkDBFull},
+		{func(i2 *I2Device) error { return i2.RemoveLinks(inUse.Link) }, []*LinkRequest{inUse, available, end}, 0x0fff, 0x40, 0, nil},
+	}
+
+	for i, test := range tests {
+		sendCh := make(chan *CommandRequest, 1)
+		device := &I2Device{&I1Device{sendCh: sendCh}}
+
+		go func(i int) {
+			request := <-sendCh
+			request.Ack = &Message{}
+			request.DoneCh <- request
+			payloads := []encoding.BinaryMarshaler{}
+			for _, link := range test.links {
+				l := *link
+				lr := *l.Link
+				l.Link = &lr
+				payloads = append(payloads, &l)
+			}
+			testRecv(request.RecvCh, CmdReadWriteALDB, payloads...)
+
+			if test.expectedErr != nil {
+				return
+			}
+
+			// the new end of database marker is written before the
+			// record that replaces the old one
+			if test.expectedEnd != 0 {
+				request = <-sendCh
+				lr := &LinkRequest{}
+				lr.UnmarshalBinary(request.Payload)
+				if lr.MemAddress != test.expectedEnd || lr.Link.Flags != 0x00 {
+					t.Errorf("tests[%d] expected end of database at %v got %v %v", i, test.expectedEnd, lr.MemAddress, lr.Link.Flags)
+				}
+				request.Ack = &Message{}
+				request.DoneCh <- request
+			}
+
+			request = <-sendCh
+			lr := &LinkRequest{}
+			lr.UnmarshalBinary(request.Payload)
+			if lr.MemAddress != test.expectedAddress {
+				t.Errorf("tests[%d] expected address %v got %v", i, test.expectedAddress, lr.MemAddress)
+			}
+
+			if lr.Link.Flags != test.expectedFlags {
+				t.Errorf("tests[%d] expected flags %v got %v", i, test.expectedFlags, lr.Link.Flags)
+			}
+			request.Ack = &Message{}
+			request.DoneCh <- request
+		}(i)
+
+		err := test.callback(device)
+		if err != test.expectedErr {
+			t.Errorf("tests[%d] expected error %v got %v", i, test.expectedErr, err)
+		}
+	}
+}
+
 func TestI2DeviceString(t *testing.T) {
 	device := &I2Device{&I1Device{address: Address{3, 4, 5}}}
 	expected := "I2 Device (03.04.05)"
@@ -34,17 +34,17 @@ func init() {
 // X10 configuration
 type SwitchConfig struct {
 	// HouseCode is the X10 house code of the switch or dimmer
-	HouseCode int
+	HouseCode int `json:"houseCode"`
 
 	// UnitCode is the X10 unit code of the switch or dimmer
-	UnitCode int
+	UnitCode int `json:"unitCode"`
 }
 
 // UnmarshalBinary takes the given byte buffer and unmarshals it into
 // the receiver
 func (sc *SwitchConfig) UnmarshalBinary(buf []byte) error {
 	if len(buf) < 14 {
-		return ErrBufferTooShort
+		return newBufError(ErrBufferTooShort, 14, len(buf))
 	}
 	sc.HouseCode = int(buf[4])
 	sc.UnitCode = int(buf[5])
@@ -109,25 +109,25 @@ type Switch interface {
 // and on levels
 type DimmerConfig struct {
 	// HouseCode is the device X10 house code
-	HouseCode int
+	HouseCode int `json:"houseCode"`
 
 	// UnitCode is the device X10 unit code
-	UnitCode int
+	UnitCode int `json:"unitCode"`
 
 	// Ramp is the default ramp rate
-	Ramp int
+	Ramp int `json:"ramp"`
 
 	// OnLevel is the default on level
-	OnLevel int
+	OnLevel int `json:"onLevel"`
 
 	// SNT is the Signal to Noise Threshold
-	SNT int
+	SNT int `json:"snt"`
 }
 
 // UnmarshalBinary will parse the byte buffer into the receiver
 func (dc *DimmerConfig) UnmarshalBinary(buf []byte) error {
 	if len(buf) < 14 {
-		return ErrBufferTooShort
+		return newBufError(ErrBufferTooShort, 14, len(buf))
 	}
 	dc.HouseCode = int(buf[4])
 	dc.UnitCode = int(buf[5])
@@ -263,6 +263,13 @@ type i2CsSwitchedDevice struct {
 
 func (i2 *i2CsSwitchedDevice) String() string { return i2.Switch.String() }
 
+// NewSwitch returns a Switch that sends its commands to the given Commandable.
+// This is useful when the Commandable is not directly connected to the
+// network (for instance, a remote device)
+func NewSwitch(commandable Commandable, firmwareVersion FirmwareVersion) Switch {
+	return &switchedDevice{Commandable: commandable, firmwareVersion: firmwareVersion}
+}
+
 type switchedDevice struct {
 	Commandable
 	firmwareVersion FirmwareVersion
@@ -373,6 +380,15 @@ type i2CsDimmableDevice struct {
 
 func (i2cs *i2CsDimmableDevice) String() string { return i2cs.Dimmer.String() }
 
+// NewDimmer returns a Dimmer that sends its commands to the given Commandable
+func NewDimmer(commandable Commandable, firmwareVersion FirmwareVersion) Dimmer {
+	return &dimmableDevice{
+		Switch:          NewSwitch(commandable, firmwareVersion),
+		Commandable:     commandable,
+		firmwareVersion: firmwareVersion,
+	}
+}
+
 type dimmableDevice struct {
 	Switch
 	Commandable
@@ -23,7 +23,7 @@ func TestSwitchConfig(t *testing.T) {
 	for i, test := range tests {
 		config := &SwitchConfig{}
 		err := config.UnmarshalBinary(test.input)
-		if err != test.expectedErr {
+		if !isError(err, test.expectedErr) {
 			t.Errorf("tests[%d] expected %v got %v", err, test.expectedErr, err)
 		} else if err == nil {
 			if test.expectedHouseCode != config.HouseCode {
@@ -59,7 +59,7 @@ func TestDimmerConfig(t *testing.T) {
 	for i, test := range tests {
 		config := &DimmerConfig{}
 		err := config.UnmarshalBinary(test.input)
-		if err != test.expectedErr {
+		if !isError(err, test.expectedErr) {
 			t.Errorf("tests[%d] expected %v got %v", err, test.expectedErr, err)
 		} else if err == nil {
 			if test.expectedHouseCode != config.HouseCode {
@@ -395,3 +395,24 @@ func TestDimmableDeviceFactory(t *testing.T) {
 		}
 	}
 }
+
+func TestNewSwitchDimmer(t *testing.T) {
+	sender := &commandable{}
+	sw := NewSwitch(sender, 1)
+	sw.On()
+
+	dimmer := NewDimmer(sender, 67)
+	dimmer.Off()
+	dimmer.OnAtRamp(0x03, 0x07)
+
+	expected := []Command{CmdLightOn, CmdLightOff, CmdLightOnAtRampV67.SubCommand(0x37)}
+	if len(sender.sentCmds) != len(expected) {
+		t.Fatalf("expected %d commands got %d", len(expected), len(sender.sentCmds))
+	}
+
+	for i, cmd := range expected {
+		if sender.sentCmds[i] != cmd {
+			t.Errorf("expected %v got %v", cmd, sender.sentCmds[i])
+		}
+	}
+}
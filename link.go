@@ -16,6 +16,7 @@ package insteon
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -121,6 +122,25 @@ func (g *Group) UnmarshalText(text []byte) error {
 	return err
 }
 
+// MarshalJSON will convert the group to its JSON number
+func (g Group) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int(g))
+}
+
+// UnmarshalJSON will populate the group from the input JSON number.  The
+// value must be positive and less than 256
+func (g *Group) UnmarshalJSON(data []byte) (err error) {
+	var value int
+	if err = json.Unmarshal(data, &value); err == nil {
+		if 0 < value && value < 256 {
+			*g = Group(byte(value))
+		} else {
+			err = fmt.Errorf("valid groups are between 1 and 255 (inclusive)")
+		}
+	}
+	return err
+}
+
 // LinkRecord is a single All-Link record in an All-Link database
 type LinkRecord struct {
 	memAddress MemAddress
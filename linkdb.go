@@ -22,11 +22,19 @@ import (
 var (
 	// ErrAlreadyLinked is returned when creating a link and an existing matching link is found
 	ErrAlreadyLinked = errors.New("Responder already linked to controller")
+
+	// ErrLinkDBFull is returned when a record can't be added because there
+	// is no room left in the All-Link database
+	ErrLinkDBFull = errors.New("All-Link database is full")
 )
 
 const (
 	// BaseLinkDBAddress is the base address of devices All-Link database
 	BaseLinkDBAddress = MemAddress(0x0fff)
+
+	// LastLinkDBAddress is the lowest memory address that an All-Link
+	// record (including the end of database marker) can be stored at
+	LastLinkDBAddress = MemAddress(0x0007)
 )
 
 // MemAddress is an integer representing a specific location in a device's memory
@@ -78,7 +86,7 @@ func (lr *LinkRequest) String() string {
 // UnmarshalBinary will take the byte slice and convert it to a LinkRequest object
 func (lr *LinkRequest) UnmarshalBinary(buf []byte) (err error) {
 	if len(buf) < 5 {
-		return newBufError(ErrBufferTooShort, 6, len(buf))
+		return newBufError(ErrBufferTooShort, 5, len(buf))
 	}
 	lr.Type = LinkRequestType(buf[1])
 	lr.MemAddress = MemAddress(buf[2]) << 8
@@ -142,14 +150,165 @@ func FindDuplicateLinks(linkable LinkableDevice) ([]*LinkRecord, error) {
 	return duplicates, err
 }
 
+// FindOrphanedLinks returns the in-use links that can never be matched
+// by another device.  These are links to the device itself and links to
+// the zero address.  Links to devices that are missing from the network
+// are not included, since that can only be determined from the other
+// devices' databases (see Audit)
+func FindOrphanedLinks(linkable LinkableDevice) ([]*LinkRecord, error) {
+	orphans := make([]*LinkRecord, 0)
+	links, err := linkable.Links()
+	if err == nil {
+		for _, link := range links {
+			if link.Flags.InUse() && (link.Address == linkable.Address() || link.Address == Address{}) {
+				orphans = append(orphans, link)
+			}
+		}
+	}
+	return orphans, err
+}
+
+// DuplicateRemover is implemented by link databases that find records by
+// their contents rather than their location, such as the PLM's.  Removing
+// a record from such a database removes every copy of it
+type DuplicateRemover interface {
+	// RemoveDuplicates removes every copy of the link's record except
+	// one, which is left with the data of the first copy
+	RemoveDuplicates(link *LinkRecord) error
+}
+
+// removeDuplicate removes the duplicate copy of the record first.  If the
+// duplicate's location is known then only that copy is marked available.
+// Otherwise the database removes the extra copies itself if it is a
+// DuplicateRemover, and as a last resort every copy is removed and first
+// is added back
+func removeDuplicate(linkable LinkableDevice, first, duplicate *LinkRecord) error {
+	if duplicate.memAddress != 0 {
+		available := *duplicate
+		available.Flags.setAvailable()
+		return linkable.WriteLink(&available)
+	} else if remover, ok := linkable.(DuplicateRemover); ok {
+		return remover.RemoveDuplicates(first)
+	}
+
+	link := *first
+	err := linkable.RemoveLinks(&link)
+	if err == nil {
+		err = linkable.AddLink(&link)
+	}
+	return err
+}
+
+// LinkUpdater is implemented by link databases (such as the PLM's) that
+// find records by their contents rather than their location, and so need
+// the original record in order to update it
+type LinkUpdater interface {
+	// UpdateLink replaces the record oldLink with newLink
+	UpdateLink(oldLink, newLink *LinkRecord) error
+}
+
+// UpdateLink replaces the record oldLink in the device's All-Link database
+// with newLink.  LinkUpdaters update the record themselves, otherwise the
+// new record is written over the old one when its location is known.  As
+// a last resort the old record is removed and the new one added, with the
+// new record added first when it is a different link
+func UpdateLink(linkable LinkableDevice, oldLink, newLink *LinkRecord) error {
+	if updater, ok := linkable.(LinkUpdater); ok {
+		return updater.UpdateLink(oldLink, newLink)
+	} else if oldLink.memAddress != 0 {
+		link := *newLink
+		link.memAddress = oldLink.memAddress
+		return linkable.WriteLink(&link)
+	} else if newLink.Flags.Available() {
+		return linkable.RemoveLinks(oldLink)
+	} else if newLink.Equal(oldLink) {
+		err := linkable.RemoveLinks(oldLink)
+		if err == nil {
+			err = linkable.AddLink(newLink)
+		}
+		return err
+	}
+
+	err := linkable.AddLink(newLink)
+	if err == nil {
+		err = linkable.RemoveLinks(oldLink)
+	}
+	return err
+}
+
+// LinkCompactor is implemented by devices that can compact their All-Link
+// database
+type LinkCompactor interface {
+	// Compact moves the in-use records down over any available records
+	// and moves the end of the database to just after the last in-use
+	// record
+	Compact() error
+}
+
+// Cleanup removes duplicate and orphaned links (see FindOrphanedLinks)
+// from the All-Link database of the device.  The first copy of each
+// duplicated link is kept, along with its data.  Links to devices that
+// are no longer on the network can't be found from a single database and
+// are left for Audit.  If the device is a LinkCompactor then the database
+// is compacted afterwards.  The links that were removed are returned
+func Cleanup(linkable LinkableDevice) (removed []*LinkRecord, err error) {
+	links, err := linkable.Links()
+	firsts := make([]*LinkRecord, 0)
+	duplicates := make(map[*LinkRecord][]*LinkRecord)
+	for _, link := range links {
+		if !link.Flags.InUse() {
+			continue
+		}
+
+		if first := findEqual(firsts, link); first == nil {
+			firsts = append(firsts, link)
+		} else {
+			duplicate := *link
+			duplicates[first] = append(duplicates[first], &duplicate)
+		}
+	}
+
+	for i := 0; i < len(firsts) && err == nil; i++ {
+		first := *firsts[i]
+		for j, duplicate := range duplicates[firsts[i]] {
+			removed = append(removed, duplicate)
+			// when the location of the records is unknown every extra
+			// copy is removed at once
+			if duplicate.memAddress != 0 || j == 0 {
+				if err = removeDuplicate(linkable, &first, duplicate); err != nil {
+					break
+				}
+			}
+		}
+	}
+
+	if err == nil {
+		var orphans []*LinkRecord
+		orphans, err = FindOrphanedLinks(linkable)
+		for _, orphan := range orphans {
+			link := *orphan
+			removed = append(removed, &link)
+		}
+
+		if err == nil && len(orphans) > 0 {
+			err = linkable.RemoveLinks(orphans...)
+		}
+	}
+
+	if compactor, ok := linkable.(LinkCompactor); ok && err == nil {
+		err = compactor.Compact()
+	}
+	return removed, err
+}
+
 // FindLinkRecord will perform a linear search of the database and return
-// a LinkRecord that matches the group, address and controller/responder
-// indicator
+// the in-use LinkRecord that matches the group, address and
+// controller/responder indicator
 func FindLinkRecord(linkable LinkableDevice, controller bool, address Address, group Group) (*LinkRecord, error) {
 	links, err := linkable.Links()
 	if err == nil {
 		for _, link := range links {
-			if link.Flags.Controller() == controller && link.Address == address && link.Group == group {
+			if link.Flags.InUse() && link.Flags.Controller() == controller && link.Address == address && link.Group == group {
 				return link, nil
 			}
 		}
@@ -142,4 +142,65 @@ func TestRemoveLink(t *testing.T) {
 }
 
 func TestCleanup(t *testing.T) {
+	address := Address{1, 2, 3}
+	link1 := &LinkRecord{Flags: 0xe2, Group: 1, Address: Address{4, 5, 6}}
+	link2 := &LinkRecord{Flags: 0xa2, Group: 2, Address: Address{7, 8, 9}}
+	device := &testLinkable{address: address, links: []*LinkRecord{
+		{Flags: 0xe2, Group: 1, Address: Address{4, 5, 6}, Data: [3]byte{1, 2, 3}},
+		{Flags: 0xe2, Group: 1, Address: Address{4, 5, 6}, Data: [3]byte{4, 5, 6}},
+		{Flags: 0xa2, Group: 2, Address: Address{7, 8, 9}},
+		{Flags: 0xe2, Group: 1, Address: Address{4, 5, 6}, Data: [3]byte{7, 8, 9}},
+		{Flags: 0xa2, Group: 1, Address: address},
+		{Flags: 0xa2, Group: 1, Address: Address{}},
+		// available records are not duplicates
+		{Flags: 0x22, Group: 2, Address: Address{7, 8, 9}},
+	}}
+
+	removed, err := Cleanup(device)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(removed) != 4 {
+		t.Errorf("expected 4 links to be removed got %v", removed)
+	}
+
+	links := device.inUse()
+	if len(links) != 2 || findEqual(links, link1) == nil || findEqual(links, link2) == nil {
+		t.Errorf("expected %v and %v got %v", link1, link2, links)
+	} else if link := findEqual(links, link1); link.Data != [3]byte{1, 2, 3} {
+		t.Errorf("expected the first copy's data to be kept got %v", link)
+	}
+}
+
+func TestUpdateLink(t *testing.T) {
+	address := Address{4, 5, 6}
+	tests := []struct {
+		desc     string
+		newLink  *LinkRecord
+		expected []*LinkRecord
+	}{
+		{"data", &LinkRecord{Flags: 0xe2, Group: 1, Address: address, Data: [3]byte{7}}, []*LinkRecord{{Flags: 0xe2, Group: 1, Address: address, Data: [3]byte{7}}}},
+		{"group", &LinkRecord{Flags: 0xe2, Group: 2, Address: address}, []*LinkRecord{{Flags: 0xe2, Group: 2, Address: address}}},
+		{"delete", &LinkRecord{Flags: 0x62, Group: 1, Address: address}, nil},
+	}
+
+	for _, test := range tests {
+		oldLink := &LinkRecord{Flags: 0xe2, Group: 1, Address: address, Data: [3]byte{1}}
+		device := &testLinkable{links: []*LinkRecord{oldLink}}
+		if err := UpdateLink(device, oldLink, test.newLink); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.desc, err)
+		}
+
+		links := device.inUse()
+		if len(links) != len(test.expected) {
+			t.Errorf("%s: expected %v got %v", test.desc, test.expected, links)
+		} else {
+			for i, link := range links {
+				if *link != *test.expected[i] {
+					t.Errorf("%s: expected %v got %v", test.desc, test.expected[i], link)
+				}
+			}
+		}
+	}
 }
@@ -102,6 +102,12 @@ func (f Flags) TTL() int { return int((f & 0x0f) >> 2) }
 // MaxTTL is the maximum number of times a message can be repeated
 func (f Flags) MaxTTL() int { return int(f & 0x03) }
 
+// setHops returns the flags with both the max hops and the hops left set
+// to hops
+func (f Flags) setHops(hops int) Flags {
+	return f&0xf0 | Flags(hops<<2|hops)
+}
+
 func (f Flags) String() string {
 	msg := "S"
 	if f.Extended() {
@@ -0,0 +1,213 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is a metric family that can be written in the Prometheus
+// text exposition format
+type collector interface {
+	write(w io.Writer) error
+}
+
+// series is a set of label values identifying one time series in a family
+type series []string
+
+func (s series) key() string { return strings.Join(s, "\xff") }
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats the label names and values, including any extra
+// name/value pairs, as {name="value",...}
+func labels(names []string, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, labelEscaper.Replace(values[i])))
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value, partitioned by labels
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mutex  sync.Mutex
+	keys   map[string]series
+	values map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *Counter {
+	return &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		keys:   make(map[string]series),
+		values: make(map[string]float64),
+	}
+}
+
+// Add increases the counter for the label values by delta
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	s := series(labelValues)
+	key := s.key()
+	c.mutex.Lock()
+	c.keys[key] = s
+	c.values[key] += delta
+	c.mutex.Unlock()
+}
+
+// Inc increases the counter for the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value of the counter for the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values[series(labelValues).key()]
+}
+
+func (c *Counter) write(w io.Writer) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		// unlabelled counters are always reported, even when zero
+		_, err = fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+
+	for _, key := range sortedKeys(c.keys) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s%s %s\n", c.name, labels(c.labels, c.keys[key]), formatFloat(c.values[key]))
+		}
+	}
+	return err
+}
+
+// Histogram counts observations in configurable buckets, partitioned by labels
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mutex  sync.Mutex
+	keys   map[string]series
+	counts map[string][]uint64
+	sums   map[string]float64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		keys:    make(map[string]series),
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+	}
+}
+
+// Observe adds the value to the histogram for the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	s := series(labelValues)
+	key := s.key()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	counts, found := h.counts[key]
+	if !found {
+		// the last count is the total (+Inf bucket)
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+		h.keys[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			counts[i]++
+		}
+	}
+	counts[len(h.buckets)]++
+	h.sums[key] += value
+}
+
+// Count returns the number of observations for the label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if counts, found := h.counts[series(labelValues).key()]; found {
+		return counts[len(h.buckets)]
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) (err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.keys) {
+		values := h.keys[key]
+		counts := h.counts[key]
+		for i, bound := range h.buckets {
+			if err == nil {
+				_, err = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels(h.labels, values, "le", formatFloat(bound)), counts[i])
+			}
+		}
+
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels(h.labels, values, "le", "+Inf"), counts[len(h.buckets)])
+		}
+
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, labels(h.labels, values), formatFloat(h.sums[key]), h.name, labels(h.labels, values), counts[len(h.buckets)])
+		}
+	}
+	return err
+}
+
+func sortedKeys(m map[string]series) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
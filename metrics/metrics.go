@@ -0,0 +1,199 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics collects statistics about the health of the modem and
+// the Insteon network and exposes them in the Prometheus text format.  The
+// package has no dependencies outside of the standard library:
+//
+//	m := metrics.New()
+//	m.Register()
+//	http.Handle("/metrics", m)
+//
+// The following metrics are collected:
+//
+//	insteon_plm_naks_total                 NAKs received from the PLM
+//	insteon_plm_retries_total              packets resent after a NAK
+//	insteon_plm_retry_exceeded_total       packets abandoned after too many NAKs
+//	insteon_plm_ack_timeouts_total         packets the PLM did not respond to
+//	insteon_port_written_bytes_total       bytes written to the serial port
+//	insteon_port_read_bytes_total          bytes read from the serial port
+//	insteon_port_errors_total{op}          serial port read and write errors
+//	insteon_port_framing_errors_total      bytes discarded while synchronizing
+//	insteon_device_acks_total{address}     messages acknowledged by each device
+//	insteon_device_errors_total{address,error}  NAKs, timeouts and delivery errors by type
+//	insteon_device_ack_latency_seconds{address} time for devices to respond
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+// DefaultLatencyBuckets are the upper bounds (in seconds) of the device
+// ACK latency histogram buckets
+var DefaultLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10}
+
+// errorNames are the label values used for known errors.  All other
+// errors are counted as "other"
+var errorNames = map[error]string{
+	insteon.ErrReadTimeout:        "timeout",
+	insteon.ErrNotLinked:          "not_linked",
+	insteon.ErrNoLoadDetected:     "no_load_detected",
+	insteon.ErrUnknownCommand:     "unknown_command",
+	insteon.ErrIllegalValue:       "illegal_value",
+	insteon.ErrPreNak:             "pre_nak",
+	insteon.ErrIncorrectChecksum:  "incorrect_checksum",
+	insteon.ErrUnexpectedResponse: "unexpected_response",
+	plm.ErrNak:                    "plm_nak",
+	plm.ErrReadTimeout:            "plm_timeout",
+	plm.ErrAckTimeout:             "plm_ack_timeout",
+	plm.ErrRetryCountExceeded:     "retry_exceeded",
+}
+
+// ErrorName returns the label value used for the error
+func ErrorName(err error) string {
+	if name, found := errorNames[err]; found {
+		return name
+	}
+	return "other"
+}
+
+// Metrics implements both insteon.Observer and plm.Observer and
+// provides an http.Handler that serves the collected metrics
+type Metrics struct {
+	PLMNaks           *Counter
+	PLMRetries        *Counter
+	PLMRetryExceeded  *Counter
+	PLMAckTimeouts    *Counter
+	PortWrittenBytes  *Counter
+	PortReadBytes     *Counter
+	PortErrors        *Counter
+	PortFramingErrors *Counter
+	DeviceAcks        *Counter
+	DeviceErrors      *Counter
+	DeviceRetries     *Counter
+	DeviceLatency     *Histogram
+
+	collectors []collector
+}
+
+// New creates an empty set of metrics.  Register must be called
+// for the metrics to be collected
+func New() *Metrics {
+	m := &Metrics{
+		PLMNaks:           newCounter("insteon_plm_naks_total", "NAKs received from the PLM"),
+		PLMRetries:        newCounter("insteon_plm_retries_total", "Packets resent to the PLM after a NAK"),
+		PLMRetryExceeded:  newCounter("insteon_plm_retry_exceeded_total", "Packets abandoned after exceeding the retry count"),
+		PLMAckTimeouts:    newCounter("insteon_plm_ack_timeouts_total", "Packets the PLM did not respond to"),
+		PortWrittenBytes:  newCounter("insteon_port_written_bytes_total", "Bytes written to the serial port"),
+		PortReadBytes:     newCounter("insteon_port_read_bytes_total", "Bytes read from the serial port"),
+		PortErrors:        newCounter("insteon_port_errors_total", "Serial port errors", "op"),
+		PortFramingErrors: newCounter("insteon_port_framing_errors_total", "Bytes discarded while synchronizing with the PLM"),
+		DeviceAcks:        newCounter("insteon_device_acks_total", "Direct messages acknowledged by the device", "address"),
+		DeviceErrors:      newCounter("insteon_device_errors_total", "Direct messages that were NAKed or not delivered", "address", "error"),
+		DeviceRetries:     newCounter("insteon_device_retries_total", "Direct messages resent after a timeout or temporary NAK", "address", "error"),
+		DeviceLatency:     newHistogram("insteon_device_ack_latency_seconds", "Time from sending a direct message until the device responds", DefaultLatencyBuckets, "address"),
+	}
+
+	m.collectors = []collector{
+		m.PLMNaks, m.PLMRetries, m.PLMRetryExceeded, m.PLMAckTimeouts,
+		m.PortWrittenBytes, m.PortReadBytes, m.PortErrors, m.PortFramingErrors,
+		m.DeviceAcks, m.DeviceErrors, m.DeviceRetries, m.DeviceLatency,
+	}
+	return m
+}
+
+// Register installs the metrics as the insteon and plm observers
+func (m *Metrics) Register() {
+	insteon.SetObserver(m)
+	plm.SetObserver(m)
+}
+
+// MessageAcked implements insteon.Observer
+func (m *Metrics) MessageAcked(address insteon.Address, latency time.Duration, err error) {
+	m.DeviceLatency.Observe(latency.Seconds(), address.String())
+	if err == nil {
+		m.DeviceAcks.Inc(address.String())
+	} else {
+		m.DeviceErrors.Inc(address.String(), ErrorName(err))
+	}
+}
+
+// MessageFailed implements insteon.Observer
+func (m *Metrics) MessageFailed(address insteon.Address, err error) {
+	m.DeviceErrors.Inc(address.String(), ErrorName(err))
+}
+
+// MessageRetried implements insteon.Observer
+func (m *Metrics) MessageRetried(address insteon.Address, attempt int, err error) {
+	m.DeviceRetries.Inc(address.String(), ErrorName(err))
+}
+
+// PortWrite implements plm.Observer
+func (m *Metrics) PortWrite(n int, err error) {
+	m.PortWrittenBytes.Add(float64(n))
+	if err != nil {
+		m.PortErrors.Inc("write")
+	}
+}
+
+// PortRead implements plm.Observer
+func (m *Metrics) PortRead(n int, err error) {
+	m.PortReadBytes.Add(float64(n))
+	if err != nil {
+		m.PortErrors.Inc("read")
+	}
+}
+
+// FramingError implements plm.Observer
+func (m *Metrics) FramingError(discarded int) { m.PortFramingErrors.Add(float64(discarded)) }
+
+// Nak implements plm.Observer
+func (m *Metrics) Nak() { m.PLMNaks.Inc() }
+
+// Retry implements plm.Observer
+func (m *Metrics) Retry() { m.PLMRetries.Inc() }
+
+// RetryExceeded implements plm.Observer
+func (m *Metrics) RetryExceeded() { m.PLMRetryExceeded.Inc() }
+
+// AckTimeout implements plm.Observer
+func (m *Metrics) AckTimeout() { m.PLMAckTimeouts.Inc() }
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (n int64, err error) {
+	buf := &bytes.Buffer{}
+	for _, c := range m.collectors {
+		if err == nil {
+			err = c.write(buf)
+		}
+	}
+
+	if err == nil {
+		n, err = buf.WriteTo(w)
+	}
+	return n, err
+}
+
+// ServeHTTP responds with the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := m.WriteTo(w)
+	insteon.Log.Errorf(err, "Failed to write metrics: %v", err)
+}
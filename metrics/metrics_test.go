@@ -0,0 +1,145 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+func TestErrorName(t *testing.T) {
+	tests := []struct {
+		input    error
+		expected string
+	}{
+		{insteon.ErrNotLinked, "not_linked"},
+		{insteon.ErrNoLoadDetected, "no_load_detected"},
+		{insteon.ErrReadTimeout, "timeout"},
+		{plm.ErrRetryCountExceeded, "retry_exceeded"},
+		{errors.New("foo"), "other"},
+	}
+
+	for i, test := range tests {
+		if got := ErrorName(test.input); got != test.expected {
+			t.Errorf("tests[%d] expected %q got %q", i, test.expected, got)
+		}
+	}
+}
+
+func TestMetricsObserver(t *testing.T) {
+	m := New()
+	address := insteon.Address{1, 2, 3}
+	m.MessageAcked(address, 300*time.Millisecond, nil)
+	m.MessageAcked(address, 3*time.Second, insteon.ErrNotLinked)
+	m.MessageFailed(address, insteon.ErrReadTimeout)
+	m.PortWrite(8, nil)
+	m.PortWrite(0, errors.New("write failed"))
+	m.PortRead(11, nil)
+	m.FramingError(3)
+	m.Nak()
+	m.Retry()
+	m.Retry()
+	m.RetryExceeded()
+	m.AckTimeout()
+
+	tests := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"acks", m.DeviceAcks.Value("01.02.03"), 1},
+		{"not linked", m.DeviceErrors.Value("01.02.03", "not_linked"), 1},
+		{"timeouts", m.DeviceErrors.Value("01.02.03", "timeout"), 1},
+		{"latency count", float64(m.DeviceLatency.Count("01.02.03")), 2},
+		{"written", m.PortWrittenBytes.Value(), 8},
+		{"write errors", m.PortErrors.Value("write"), 1},
+		{"read", m.PortReadBytes.Value(), 11},
+		{"framing", m.PortFramingErrors.Value(), 3},
+		{"naks", m.PLMNaks.Value(), 1},
+		{"retries", m.PLMRetries.Value(), 2},
+		{"retry exceeded", m.PLMRetryExceeded.Value(), 1},
+		{"ack timeouts", m.PLMAckTimeouts.Value(), 1},
+	}
+
+	for _, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("%s: expected %v got %v", test.name, test.expected, test.got)
+		}
+	}
+}
+
+func TestMetricsWriteTo(t *testing.T) {
+	m := New()
+	m.MessageAcked(insteon.Address{1, 2, 3}, 300*time.Millisecond, nil)
+	m.MessageFailed(insteon.Address{4, 5, 6}, insteon.ErrReadTimeout)
+
+	buf := &bytes.Buffer{}
+	_, err := m.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"# TYPE insteon_plm_naks_total counter\ninsteon_plm_naks_total 0\n",
+		"insteon_device_acks_total{address=\"01.02.03\"} 1\n",
+		"insteon_device_errors_total{address=\"04.05.06\",error=\"timeout\"} 1\n",
+		"# TYPE insteon_device_ack_latency_seconds histogram\n",
+		"insteon_device_ack_latency_seconds_bucket{address=\"01.02.03\",le=\"0.25\"} 0\n",
+		"insteon_device_ack_latency_seconds_bucket{address=\"01.02.03\",le=\"0.5\"} 1\n",
+		"insteon_device_ack_latency_seconds_bucket{address=\"01.02.03\",le=\"+Inf\"} 1\n",
+		"insteon_device_ack_latency_seconds_sum{address=\"01.02.03\"} 0.3\n",
+		"insteon_device_ack_latency_seconds_count{address=\"01.02.03\"} 1\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(buf.String(), e) {
+			t.Errorf("expected output to contain %q", e)
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	got := labels([]string{"a"}, []string{"x\"y\\z\n"})
+	expected := `{a="x\"y\\z\n"}`
+	if got != expected {
+		t.Errorf("expected %s got %s", expected, got)
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := New()
+	m.Nak()
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != 200 {
+		t.Errorf("expected 200 got %d", recorder.Code)
+	}
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected text/plain got %q", recorder.Header().Get("Content-Type"))
+	}
+
+	if !strings.Contains(recorder.Body.String(), "insteon_plm_naks_total 1\n") {
+		t.Errorf("expected NAK count in %q", recorder.Body.String())
+	}
+}
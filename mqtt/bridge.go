@@ -0,0 +1,488 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mqtt bridges an Insteon network to an MQTT broker.  Device state
+// and network events are published and commands are accepted using the
+// following topics (the "insteon" prefix is configurable):
+//
+//	insteon/bridge/availability      "online" or "offline" (retained, also the last will)
+//	insteon/<address>/state          {"state": "ON", "level": 255} (retained)
+//	insteon/<address>/event          every message received from the device
+//	insteon/<address>/error          errors from commands sent to the device
+//	insteon/<address>/set            "ON", "OFF", a level (0-255) or {"state": "ON", "level": 128}
+//	insteon/<address>/links/get      request the All-Link database
+//	insteon/<address>/links          the All-Link database, published after links/get
+//	insteon/<address>/links/add      add a link record ("UR 1 01.02.03 00 1c 01")
+//	insteon/<address>/links/remove   remove a link record
+//	insteon/scene/<name>/set         "ON" or "OFF"
+//
+// The address "plm" can be used for link operations on the modem.
+// PublishDiscovery publishes Home Assistant MQTT discovery configuration
+// so that devices appear in Home Assistant without manual setup.  The
+// bridge does not depend on any particular MQTT library, anything that
+// satisfies the Client interface can be used
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/abates/insteon"
+)
+
+var (
+	// ErrUnknownPayload is returned when a command payload cannot be parsed
+	ErrUnknownPayload = errors.New("Unknown command payload")
+
+	// ErrUnknownScene is returned when a scene command is received for a
+	// scene that is not known to the bridge
+	ErrUnknownScene = errors.New("Unknown scene")
+
+	// ErrNotLinkable is returned when link operations are requested for a
+	// device that does not have an All-Link database
+	ErrNotLinkable = errors.New("Device does not have an All-Link database")
+)
+
+// Availability payloads
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// DefaultPrefix is the topic prefix used when none is configured
+const DefaultPrefix = "insteon"
+
+// Handler is called for every message received on a subscribed topic
+type Handler func(topic string, payload []byte)
+
+// Client is the MQTT client used by the bridge.  The client must already
+// be connected to the broker, with its last will set to publish Offline
+// to the AvailabilityTopic
+type Client interface {
+	// Publish sends the payload to the topic
+	Publish(topic string, retained bool, payload []byte) error
+
+	// Subscribe registers the handler for the topic filter.  Topic
+	// filters may include the + and # wildcards
+	Subscribe(filter string, handler Handler) error
+
+	// Unsubscribe removes the subscriptions for the topic filters
+	Unsubscribe(filters ...string) error
+}
+
+// Modem is the local Insteon interface (usually a *plm.PLM) used for
+// scenes and link operations on the modem
+type Modem interface {
+	insteon.LinkableDevice
+	insteon.AllLinkCommander
+}
+
+// Network is used to connect to devices
+type Network interface {
+	Connect(address insteon.Address) (insteon.Device, error)
+}
+
+// Subscriber delivers every message received from the Insteon network.
+// *insteon.Network is a Subscriber
+type Subscriber interface {
+	Subscribe(ch chan<- *insteon.Message)
+	Unsubscribe(ch chan<- *insteon.Message)
+}
+
+// Config contains the bridge settings
+type Config struct {
+	// Prefix is the first element of every topic, defaults to DefaultPrefix
+	Prefix string
+
+	// Modem is used to trigger scenes and for link operations on the PLM
+	Modem Modem
+
+	// Network is used to connect to devices that are sent commands
+	Network Network
+
+	// Subscriber, if set, provides the network messages that are
+	// published as events
+	Subscriber Subscriber
+
+	// Tracker, if set, provides the device state that is published. If
+	// no tracker is given then state is only published after commands
+	Tracker *insteon.StateTracker
+
+	// Scenes that can be triggered
+	Scenes []*insteon.Scene
+}
+
+// AvailabilityTopic returns the topic used to publish the bridge's
+// availability.  Clients should set their last will to publish Offline
+// (retained) to this topic
+func AvailabilityTopic(prefix string) string {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return prefix + "/bridge/availability"
+}
+
+// State is the payload published to a device's state topic
+type State struct {
+	State string `json:"state"`
+	Level int    `json:"level"`
+}
+
+func newState(level int) *State {
+	state := &State{State: "OFF", Level: level}
+	if level > 0 {
+		state.State = "ON"
+	}
+	return state
+}
+
+// Event is the payload published for every message received from the network
+type Event struct {
+	Src     insteon.Address `json:"src"`
+	Dst     insteon.Address `json:"dst"`
+	Type    string          `json:"type"`
+	Command string          `json:"command"`
+	Cmd1    int             `json:"cmd1"`
+	Cmd2    int             `json:"cmd2"`
+	Group   int             `json:"group,omitempty"`
+}
+
+// NewEvent decodes the message into an Event
+func NewEvent(msg *insteon.Message) *Event {
+	event := &Event{
+		Src:     msg.Src,
+		Dst:     msg.Dst,
+		Type:    msg.Flags.Type().String(),
+		Command: msg.Command.String(),
+		Cmd1:    int(msg.Command[1]),
+		Cmd2:    int(msg.Command[2]),
+	}
+
+	switch msg.Flags.Type() {
+	case insteon.MsgTypeAllLinkBroadcast:
+		event.Group = int(msg.Dst[2])
+	case insteon.MsgTypeAllLinkCleanup, insteon.MsgTypeAllLinkCleanupAck:
+		event.Group = int(msg.Command[2])
+	}
+	return event
+}
+
+// Bridge publishes Insteon state and events to MQTT and executes
+// commands received from MQTT
+type Bridge struct {
+	client Client
+	config Config
+
+	mutex   sync.Mutex
+	devices map[insteon.Address]insteon.Device
+
+	recvCh  chan *insteon.Message
+	stateCh chan insteon.StateChange
+	wg      sync.WaitGroup
+}
+
+// New creates a bridge using the connected MQTT client
+func New(client Client, config Config) *Bridge {
+	if config.Prefix == "" {
+		config.Prefix = DefaultPrefix
+	}
+
+	return &Bridge{
+		client:  client,
+		config:  config,
+		devices: make(map[insteon.Address]insteon.Device),
+	}
+}
+
+func (b *Bridge) topic(elements ...string) string {
+	return strings.Join(append([]string{b.config.Prefix}, elements...), "/")
+}
+
+func (b *Bridge) publishJSON(topic string, retained bool, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err == nil {
+		err = b.client.Publish(topic, retained, buf)
+	}
+	return err
+}
+
+// Start subscribes to the command topics, begins publishing state and
+// events and then announces the bridge as online
+func (b *Bridge) Start() (err error) {
+	subscriptions := []struct {
+		filter  string
+		handler Handler
+	}{
+		{b.topic("scene", "+", "set"), b.sceneHandler},
+		{b.topic("+", "set"), b.setHandler},
+		{b.topic("+", "links", "+"), b.linksHandler},
+	}
+
+	for _, subscription := range subscriptions {
+		err = b.client.Subscribe(subscription.filter, subscription.handler)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.config.Tracker != nil {
+		b.stateCh = make(chan insteon.StateChange, 32)
+		b.config.Tracker.Subscribe(b.stateCh)
+		for _, state := range b.config.Tracker.States() {
+			b.publishState(state.Address, state.Level)
+		}
+
+		b.wg.Add(1)
+		go b.processStates()
+	}
+
+	if b.config.Subscriber != nil {
+		b.recvCh = make(chan *insteon.Message, 1)
+		b.config.Subscriber.Subscribe(b.recvCh)
+		b.wg.Add(1)
+		go b.processEvents()
+	}
+
+	return b.client.Publish(AvailabilityTopic(b.config.Prefix), true, []byte(Online))
+}
+
+// Close announces the bridge as offline and stops publishing
+func (b *Bridge) Close() error {
+	err := b.client.Publish(AvailabilityTopic(b.config.Prefix), true, []byte(Offline))
+	b.client.Unsubscribe(b.topic("scene", "+", "set"), b.topic("+", "set"), b.topic("+", "links", "+"))
+
+	if b.config.Subscriber != nil {
+		b.config.Subscriber.Unsubscribe(b.recvCh)
+	}
+
+	if b.config.Tracker != nil {
+		b.config.Tracker.Unsubscribe(b.stateCh)
+	}
+	b.wg.Wait()
+	return err
+}
+
+func (b *Bridge) processStates() {
+	defer b.wg.Done()
+	for change := range b.stateCh {
+		b.publishState(change.Current.Address, change.Current.Level)
+	}
+}
+
+func (b *Bridge) processEvents() {
+	defer b.wg.Done()
+	for msg := range b.recvCh {
+		err := b.publishJSON(b.topic(msg.Src.String(), "event"), false, NewEvent(msg))
+		insteon.Log.Errorf(err, "Failed to publish event for %v: %v", msg.Src, err)
+	}
+}
+
+func (b *Bridge) publishState(address insteon.Address, level int) {
+	err := b.publishJSON(b.topic(address.String(), "state"), true, newState(level))
+	insteon.Log.Errorf(err, "Failed to publish state for %v: %v", address, err)
+}
+
+func (b *Bridge) publishError(element string, err error) {
+	insteon.Log.Infof("MQTT command for %s failed: %v", element, err)
+	b.client.Publish(b.topic(element, "error"), false, []byte(err.Error()))
+}
+
+func (b *Bridge) connect(address insteon.Address) (insteon.Device, error) {
+	b.mutex.Lock()
+	device, found := b.devices[address]
+	b.mutex.Unlock()
+
+	if found {
+		return device, nil
+	}
+
+	device, err := b.config.Network.Connect(address)
+	if err == nil {
+		b.mutex.Lock()
+		b.devices[address] = device
+		b.mutex.Unlock()
+	}
+	return device, err
+}
+
+// topicElement returns the element of the topic at the given position
+// after the prefix
+func (b *Bridge) topicElement(topic string, i int) string {
+	elements := strings.Split(strings.TrimPrefix(topic, b.config.Prefix+"/"), "/")
+	if i < len(elements) {
+		return elements[i]
+	}
+	return ""
+}
+
+// ParseLevel parses a set command payload.  The payload can be ON, OFF, a
+// level between 0 and 255 or a JSON State object
+func ParseLevel(payload []byte) (level int, err error) {
+	str := strings.TrimSpace(string(payload))
+	switch strings.ToUpper(str) {
+	case "ON":
+		return 255, nil
+	case "OFF":
+		return 0, nil
+	}
+
+	if strings.HasPrefix(str, "{") {
+		state := &State{Level: -1}
+		err = json.Unmarshal([]byte(str), state)
+		if err == nil {
+			level = state.Level
+			if strings.ToUpper(state.State) == "OFF" {
+				level = 0
+			} else if level == -1 {
+				level = 255
+			}
+		}
+	} else {
+		level, err = strconv.Atoi(str)
+	}
+
+	if err != nil || level < 0 || level > 255 {
+		return 0, ErrUnknownPayload
+	}
+	return level, nil
+}
+
+func setLevel(device insteon.Device, level int) error {
+	sw, ok := device.(insteon.Switch)
+	if !ok {
+		return insteon.ErrNotSwitch
+	}
+
+	if level == 0 {
+		return sw.Off()
+	} else if dimmer, ok := device.(insteon.Dimmer); ok {
+		return dimmer.OnLevel(level)
+	}
+	return sw.On()
+}
+
+func (b *Bridge) setHandler(topic string, payload []byte) {
+	element := b.topicElement(topic, 0)
+	var address insteon.Address
+	err := address.UnmarshalText([]byte(element))
+
+	level := 0
+	if err == nil {
+		level, err = ParseLevel(payload)
+	}
+
+	var device insteon.Device
+	if err == nil {
+		device, err = b.connect(address)
+	}
+
+	if err == nil {
+		err = setLevel(device, level)
+	}
+
+	if err == nil {
+		if _, ok := device.(insteon.Dimmer); !ok && level > 0 {
+			level = 255
+		}
+
+		if b.config.Tracker == nil {
+			b.publishState(address, level)
+		} else {
+			b.config.Tracker.Update(address, level)
+		}
+	} else {
+		b.publishError(element, err)
+	}
+}
+
+func (b *Bridge) sceneHandler(topic string, payload []byte) {
+	name := b.topicElement(topic, 1)
+	var scene *insteon.Scene
+	for _, s := range b.config.Scenes {
+		if s.Name == name {
+			scene = s
+			break
+		}
+	}
+
+	err := ErrUnknownScene
+	if scene != nil {
+		var level int
+		level, err = ParseLevel(payload)
+		if err == nil {
+			cmd := insteon.CmdLightOn
+			if level == 0 {
+				cmd = insteon.CmdLightOff
+			}
+			err = insteon.TriggerScene(scene, b.config.Modem, cmd)
+		}
+	}
+
+	if err != nil {
+		b.publishError("scene/"+name, err)
+	}
+}
+
+func (b *Bridge) linkable(element string) (insteon.LinkableDevice, error) {
+	if element == "plm" {
+		return b.config.Modem, nil
+	}
+
+	var address insteon.Address
+	err := address.UnmarshalText([]byte(element))
+	if err == nil {
+		var device insteon.Device
+		device, err = b.connect(address)
+		if err == nil {
+			if linkable, ok := device.(insteon.LinkableDevice); ok {
+				return linkable, nil
+			}
+			err = ErrNotLinkable
+		}
+	}
+	return nil, err
+}
+
+func (b *Bridge) linksHandler(topic string, payload []byte) {
+	element := b.topicElement(topic, 0)
+	linkable, err := b.linkable(element)
+	if err == nil {
+		switch b.topicElement(topic, 2) {
+		case "get":
+			var links []*insteon.LinkRecord
+			links, err = linkable.Links()
+			if err == nil {
+				err = b.publishJSON(b.topic(element, "links"), false, links)
+			}
+		case "add", "remove":
+			link := &insteon.LinkRecord{}
+			err = link.UnmarshalText(payload)
+			if err == nil && b.topicElement(topic, 2) == "add" {
+				err = linkable.AddLink(link)
+			} else if err == nil {
+				err = linkable.RemoveLinks(link)
+			}
+		default:
+			err = fmt.Errorf("unknown link operation %q", b.topicElement(topic, 2))
+		}
+	}
+
+	if err != nil {
+		b.publishError(element, err)
+	}
+}
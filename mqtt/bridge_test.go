@@ -0,0 +1,754 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// match reports whether the topic matches the MQTT topic filter
+func match(filter, topic string) bool {
+	filters := strings.Split(filter, "/")
+	topics := strings.Split(topic, "/")
+	for i, f := range filters {
+		if f == "#" {
+			return true
+		} else if i >= len(topics) || (f != "+" && f != topics[i]) {
+			return false
+		}
+	}
+	return len(filters) == len(topics)
+}
+
+type publication struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+// testBroker is an in-memory broker that delivers publications to
+// matching subscriptions synchronously
+type testBroker struct {
+	sync.Mutex
+	published     []publication
+	retained      map[string]string
+	subscriptions map[string]Handler
+}
+
+func newTestBroker() *testBroker {
+	return &testBroker{
+		retained:      make(map[string]string),
+		subscriptions: make(map[string]Handler),
+	}
+}
+
+func (tb *testBroker) Publish(topic string, retained bool, payload []byte) error {
+	tb.Lock()
+	tb.published = append(tb.published, publication{topic, retained, string(payload)})
+	if retained {
+		tb.retained[topic] = string(payload)
+	}
+
+	handlers := []Handler{}
+	for filter, handler := range tb.subscriptions {
+		if match(filter, topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	tb.Unlock()
+
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (tb *testBroker) Subscribe(filter string, handler Handler) error {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.subscriptions[filter] = handler
+	return nil
+}
+
+func (tb *testBroker) Unsubscribe(filters ...string) error {
+	tb.Lock()
+	defer tb.Unlock()
+	for _, filter := range filters {
+		delete(tb.subscriptions, filter)
+	}
+	return nil
+}
+
+func (tb *testBroker) find(topic string) (publication, bool) {
+	tb.Lock()
+	defer tb.Unlock()
+	for i := len(tb.published) - 1; i >= 0; i-- {
+		if tb.published[i].topic == topic {
+			return tb.published[i], true
+		}
+	}
+	return publication{}, false
+}
+
+// wait waits for a publication to the topic
+func (tb *testBroker) wait(topic string) (publication, bool) {
+	for i := 0; i < 100; i++ {
+		if pub, found := tb.find(topic); found {
+			return pub, true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return publication{}, false
+}
+
+type testLinkable struct {
+	links []*insteon.LinkRecord
+}
+
+func (tl *testLinkable) Address() insteon.Address                 { return insteon.Address{} }
+func (tl *testLinkable) EnterLinkingMode(insteon.Group) error     { return nil }
+func (tl *testLinkable) EnterUnlinkingMode(insteon.Group) error   { return nil }
+func (tl *testLinkable) ExitLinkingMode() error                   { return nil }
+func (tl *testLinkable) Links() ([]*insteon.LinkRecord, error)    { return tl.links, nil }
+func (tl *testLinkable) WriteLink(link *insteon.LinkRecord) error { return tl.AddLink(link) }
+func (tl *testLinkable) AddLink(link *insteon.LinkRecord) error {
+	tl.links = append(tl.links, link)
+	return nil
+}
+func (tl *testLinkable) String() string { return "test linkable" }
+func (tl *testLinkable) SendCommand(cmd insteon.Command, payload []byte) (insteon.Command, error) {
+	return cmd, nil
+}
+func (tl *testLinkable) SendCommandAndListen(insteon.Command, []byte) (<-chan *insteon.CommandResponse, error) {
+	return nil, insteon.ErrNotImplemented
+}
+
+func (tl *testLinkable) RemoveLinks(remove ...*insteon.LinkRecord) error {
+	links := []*insteon.LinkRecord{}
+	for _, link := range tl.links {
+		keep := true
+		for _, r := range remove {
+			if link.Equal(r) {
+				keep = false
+			}
+		}
+		if keep {
+			links = append(links, link)
+		}
+	}
+	tl.links = links
+	return nil
+}
+
+type testModem struct {
+	testLinkable
+	group insteon.Group
+	cmd   insteon.Command
+}
+
+func (tm *testModem) SendAllLinkCommand(group insteon.Group, cmd insteon.Command) error {
+	tm.group = group
+	tm.cmd = cmd
+	return nil
+}
+
+type testSwitch struct {
+	insteon.Switch
+	*testLinkable
+	address insteon.Address
+	cmds    []insteon.Command
+}
+
+func newTestSwitch(address insteon.Address) *testSwitch {
+	ts := &testSwitch{address: address, testLinkable: &testLinkable{}}
+	ts.Switch = insteon.NewSwitch(ts, insteon.FirmwareVersion(0x40))
+	return ts
+}
+
+func (ts *testSwitch) Address() insteon.Address { return ts.address }
+func (ts *testSwitch) String() string           { return ts.address.String() }
+func (ts *testSwitch) SendCommand(cmd insteon.Command, payload []byte) (insteon.Command, error) {
+	ts.cmds = append(ts.cmds, cmd)
+	return cmd, nil
+}
+
+type testDimmer struct {
+	insteon.Dimmer
+	*testSwitch
+}
+
+func newTestDimmer(address insteon.Address) *testDimmer {
+	ts := newTestSwitch(address)
+	return &testDimmer{Dimmer: insteon.NewDimmer(ts, insteon.FirmwareVersion(0x40)), testSwitch: ts}
+}
+
+func (td *testDimmer) Address() insteon.Address { return td.address }
+func (td *testDimmer) String() string           { return td.address.String() }
+func (td *testDimmer) SendCommand(cmd insteon.Command, payload []byte) (insteon.Command, error) {
+	return td.testSwitch.SendCommand(cmd, payload)
+}
+
+type testNetwork map[insteon.Address]insteon.Device
+
+func (tn testNetwork) Connect(address insteon.Address) (insteon.Device, error) {
+	if device, found := tn[address]; found {
+		return device, nil
+	}
+	return nil, insteon.ErrReadTimeout
+}
+
+func newTestBridge(config Config) (*Bridge, *testBroker, *testModem, testNetwork) {
+	broker := newTestBroker()
+	modem := &testModem{}
+	network := testNetwork{
+		insteon.Address{1, 2, 3}: newTestSwitch(insteon.Address{1, 2, 3}),
+		insteon.Address{4, 5, 6}: newTestDimmer(insteon.Address{4, 5, 6}),
+	}
+	config.Modem = modem
+	config.Network = network
+	return New(broker, config), broker, modem, network
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedLevel int
+		expectedErr   error
+	}{
+		{"ON", 255, nil},
+		{"off", 0, nil},
+		{" 128\n", 128, nil},
+		{"256", 0, ErrUnknownPayload},
+		{"-1", 0, ErrUnknownPayload},
+		{"dim", 0, ErrUnknownPayload},
+		{`{"state": "ON"}`, 255, nil},
+		{`{"state": "ON", "level": 64}`, 64, nil},
+		{`{"state": "OFF", "level": 64}`, 0, nil},
+		{`{"level": 32}`, 32, nil},
+		{`{"level": "32"}`, 0, ErrUnknownPayload},
+	}
+
+	for i, test := range tests {
+		level, err := ParseLevel([]byte(test.input))
+		if err != test.expectedErr {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expectedErr, err)
+		} else if level != test.expectedLevel {
+			t.Errorf("tests[%d] expected %d got %d", i, test.expectedLevel, level)
+		}
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	tests := []struct {
+		flags         insteon.Flags
+		dst           insteon.Address
+		command       insteon.Command
+		expectedType  string
+		expectedGroup int
+	}{
+		{insteon.StandardDirectMessage, insteon.Address{4, 5, 6}, insteon.CmdLightOn.SubCommand(0xff), "D", 0},
+		{insteon.StandardAllLinkBroadcast, insteon.Address{0, 0, 3}, insteon.CmdLightOff, "A", 3},
+		{insteon.Flags(0x4a), insteon.Address{4, 5, 6}, insteon.CmdLightOn.SubCommand(2), "C", 2},
+	}
+
+	for i, test := range tests {
+		msg := &insteon.Message{Src: insteon.Address{1, 2, 3}, Dst: test.dst, Flags: test.flags, Command: test.command}
+		event := NewEvent(msg)
+		if event.Type != test.expectedType {
+			t.Errorf("tests[%d] expected %q got %q", i, test.expectedType, event.Type)
+		}
+
+		if event.Group != test.expectedGroup {
+			t.Errorf("tests[%d] expected group %d got %d", i, test.expectedGroup, event.Group)
+		}
+
+		if event.Src != msg.Src || event.Cmd1 != int(test.command[1]) || event.Cmd2 != int(test.command[2]) {
+			t.Errorf("tests[%d] event %+v does not match message %v", i, event, msg)
+		}
+	}
+}
+
+func TestBridgeAvailability(t *testing.T) {
+	bridge, broker, _, _ := newTestBridge(Config{Prefix: "home"})
+	err := bridge.Start()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if broker.retained["home/bridge/availability"] != Online {
+		t.Errorf("expected %q got %q", Online, broker.retained["home/bridge/availability"])
+	}
+
+	if len(broker.subscriptions) != 3 {
+		t.Errorf("expected 3 subscriptions got %d", len(broker.subscriptions))
+	}
+
+	bridge.Close()
+	if broker.retained["home/bridge/availability"] != Offline {
+		t.Errorf("expected %q got %q", Offline, broker.retained["home/bridge/availability"])
+	}
+
+	if len(broker.subscriptions) != 0 {
+		t.Errorf("expected subscriptions to be removed, got %d", len(broker.subscriptions))
+	}
+}
+
+func TestBridgeSet(t *testing.T) {
+	tests := []struct {
+		topic         string
+		payload       string
+		expectedTopic string
+		expectedValue string
+		expectedCmd   insteon.Command
+	}{
+		{"insteon/01.02.03/set", "ON", "insteon/01.02.03/state", `{"state":"ON","level":255}`, insteon.CmdLightOn.SubCommand(0xff)},
+		{"insteon/01.02.03/set", "OFF", "insteon/01.02.03/state", `{"state":"OFF","level":0}`, insteon.CmdLightOff},
+		{"insteon/01.02.03/set", "128", "insteon/01.02.03/state", `{"state":"ON","level":255}`, insteon.CmdLightOn.SubCommand(0xff)},
+		{"insteon/04.05.06/set", `{"state":"ON","level":128}`, "insteon/04.05.06/state", `{"state":"ON","level":128}`, insteon.CmdLightOn.SubCommand(128)},
+		{"insteon/04.05.06/set", "dim", "insteon/04.05.06/error", ErrUnknownPayload.Error(), insteon.Command{}},
+		{"insteon/07.08.09/set", "ON", "insteon/07.08.09/error", insteon.ErrReadTimeout.Error(), insteon.Command{}},
+		{"insteon/foo/set", "ON", "insteon/foo/error", insteon.ErrAddrFormat.Error(), insteon.Command{}},
+	}
+
+	for i, test := range tests {
+		bridge, broker, _, network := newTestBridge(Config{})
+		bridge.Start()
+		broker.Publish(test.topic, false, []byte(test.payload))
+
+		pub, found := broker.find(test.expectedTopic)
+		if !found {
+			t.Errorf("tests[%d] expected publication to %q", i, test.expectedTopic)
+		} else if pub.payload != test.expectedValue {
+			t.Errorf("tests[%d] expected %q got %q", i, test.expectedValue, pub.payload)
+		}
+
+		if test.expectedCmd != (insteon.Command{}) {
+			cmds := network[insteon.Address{1, 2, 3}].(*testSwitch).cmds
+			if strings.HasPrefix(test.topic, "insteon/04.05.06") {
+				cmds = network[insteon.Address{4, 5, 6}].(*testDimmer).cmds
+			}
+
+			if len(cmds) != 1 || cmds[0] != test.expectedCmd {
+				t.Errorf("tests[%d] expected %v got %v", i, test.expectedCmd, cmds)
+			}
+		}
+		bridge.Close()
+	}
+}
+
+func TestBridgeScenes(t *testing.T) {
+	scene := &insteon.Scene{Name: "evening", Group: 5}
+	bridge, broker, modem, _ := newTestBridge(Config{Scenes: []*insteon.Scene{scene}})
+	bridge.Start()
+	defer bridge.Close()
+
+	broker.Publish("insteon/scene/evening/set", false, []byte("OFF"))
+	if modem.group != 5 || modem.cmd != insteon.CmdLightOff {
+		t.Errorf("expected group 5 %v got %v %v", insteon.CmdLightOff, modem.group, modem.cmd)
+	}
+
+	broker.Publish("insteon/scene/morning/set", false, []byte("ON"))
+	if pub, _ := broker.find("insteon/scene/morning/error"); pub.payload != ErrUnknownScene.Error() {
+		t.Errorf("expected %q got %q", ErrUnknownScene, pub.payload)
+	}
+}
+
+func TestBridgeLinks(t *testing.T) {
+	bridge, broker, modem, network := newTestBridge(Config{})
+	bridge.Start()
+	defer bridge.Close()
+
+	link := "UR        1 aa.bb.cc   00 1c 01"
+	broker.Publish("insteon/01.02.03/links/add", false, []byte(link))
+	broker.Publish("insteon/01.02.03/links/get", false, nil)
+
+	pub, _ := broker.find("insteon/01.02.03/links")
+	links := []*insteon.LinkRecord{}
+	err := json.Unmarshal([]byte(pub.payload), &links)
+	if err != nil || len(links) != 1 {
+		t.Errorf("expected [%s] got %q (%v)", link, pub.payload, err)
+	} else if text, _ := links[0].MarshalText(); string(text) != link {
+		t.Errorf("expected [%s] got %q (%v)", link, pub.payload, err)
+	}
+
+	broker.Publish("insteon/01.02.03/links/remove", false, []byte(link))
+	if len(network[insteon.Address{1, 2, 3}].(*testSwitch).links) != 0 {
+		t.Errorf("expected link to be removed")
+	}
+
+	broker.Publish("insteon/plm/links/add", false, []byte(link))
+	if len(modem.links) != 1 {
+		t.Errorf("expected link to be added to the modem")
+	}
+
+	broker.Publish("insteon/01.02.03/links/add", false, []byte("bogus"))
+	if _, found := broker.find("insteon/01.02.03/error"); !found {
+		t.Errorf("expected an error to be published")
+	}
+}
+
+func TestBridgeNetwork(t *testing.T) {
+	sendCh := make(chan *insteon.PacketRequest, 1)
+	recvCh := make(chan []byte, 1)
+	network := insteon.New(sendCh, recvCh, time.Second)
+	defer network.Close()
+
+	tracker := insteon.NewStateTracker(network)
+	defer tracker.Close()
+	tracker.Update(insteon.Address{4, 5, 6}, 64)
+
+	bridge, broker, _, _ := newTestBridge(Config{Subscriber: network, Tracker: tracker})
+	bridge.Start()
+	defer bridge.Close()
+
+	if pub, _ := broker.wait("insteon/04.05.06/state"); pub.payload != `{"state":"ON","level":64}` || !pub.retained {
+		t.Errorf("expected initial retained state got %+v", pub)
+	}
+
+	msg := &insteon.Message{Src: insteon.Address{1, 2, 3}, Dst: insteon.Address{0, 0, 1}, Flags: insteon.StandardAllLinkBroadcast, Command: insteon.CmdLightOn}
+	buf, _ := msg.MarshalBinary()
+	recvCh <- buf
+
+	pub, found := broker.wait("insteon/01.02.03/event")
+	if !found {
+		t.Fatalf("expected an event to be published")
+	}
+
+	event := &Event{}
+	json.Unmarshal([]byte(pub.payload), event)
+	if event.Src != msg.Src || event.Group != 1 || event.Type != "A" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if pub, _ := broker.wait("insteon/01.02.03/state"); pub.payload != `{"state":"ON","level":255}` {
+		t.Errorf("expected state to be published from the tracker got %q", pub.payload)
+	}
+}
+
+// mqttBroker is a minimal MQTT 3.1.1 broker.  It supports QoS 0 and 1
+// publications, wildcard subscriptions, retained messages and last wills,
+// which is enough to run the bridge against a real client.  Every message
+// is delivered at QoS 0
+type mqttBroker struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	retained map[string][]byte
+	sessions map[string]*mqttSession
+}
+
+type mqttSession struct {
+	conn    net.Conn
+	mutex   sync.Mutex
+	filters map[string]bool
+	will    *packets.PublishPacket
+}
+
+func (session *mqttSession) write(packet packets.ControlPacket) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return packet.Write(session.conn)
+}
+
+// newMQTTBroker starts a broker on the loopback interface.  The test is
+// skipped if the broker can't listen
+func newMQTTBroker(t *testing.T) *mqttBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no MQTT broker available: %v", err)
+	}
+
+	broker := &mqttBroker{
+		listener: listener,
+		retained: make(map[string][]byte),
+		sessions: make(map[string]*mqttSession),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	return broker
+}
+
+func (broker *mqttBroker) URL() string { return "tcp://" + broker.listener.Addr().String() }
+
+func (broker *mqttBroker) Close() {
+	broker.listener.Close()
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for _, session := range broker.sessions {
+		session.conn.Close()
+	}
+}
+
+// Drop closes the client's connection without a DISCONNECT, as if the
+// client had crashed, so that its last will is published
+func (broker *mqttBroker) Drop(clientID string) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if session, found := broker.sessions[clientID]; found {
+		session.conn.Close()
+	}
+}
+
+func (broker *mqttBroker) publish(topic string, payload []byte, retain bool) {
+	broker.mutex.Lock()
+	if retain {
+		if len(payload) == 0 {
+			delete(broker.retained, topic)
+		} else {
+			broker.retained[topic] = payload
+		}
+	}
+
+	sessions := []*mqttSession{}
+	for _, session := range broker.sessions {
+		for filter := range session.filters {
+			if match(filter, topic) {
+				sessions = append(sessions, session)
+				break
+			}
+		}
+	}
+	broker.mutex.Unlock()
+
+	for _, session := range sessions {
+		packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		packet.TopicName = topic
+		packet.Payload = payload
+		session.write(packet)
+	}
+}
+
+func (broker *mqttBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	packet, err := packets.ReadPacket(conn)
+	connect, ok := packet.(*packets.ConnectPacket)
+	if err != nil || !ok {
+		return
+	}
+
+	session := &mqttSession{conn: conn, filters: make(map[string]bool)}
+	if connect.WillFlag {
+		session.will = packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		session.will.TopicName = connect.WillTopic
+		session.will.Payload = connect.WillMessage
+		session.will.Retain = connect.WillRetain
+	}
+
+	broker.mutex.Lock()
+	broker.sessions[connect.ClientIdentifier] = session
+	broker.mutex.Unlock()
+
+	defer func() {
+		broker.mutex.Lock()
+		delete(broker.sessions, connect.ClientIdentifier)
+		broker.mutex.Unlock()
+		if session.will != nil {
+			broker.publish(session.will.TopicName, session.will.Payload, session.will.Retain)
+		}
+	}()
+
+	if session.write(packets.NewControlPacket(packets.Connack)) != nil {
+		return
+	}
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch packet := packet.(type) {
+		case *packets.PublishPacket:
+			if packet.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = packet.MessageID
+				session.write(ack)
+			}
+			broker.publish(packet.TopicName, packet.Payload, packet.Retain)
+		case *packets.SubscribePacket:
+			broker.mutex.Lock()
+			retained := []*packets.PublishPacket{}
+			for _, filter := range packet.Topics {
+				session.filters[filter] = true
+				for topic, payload := range broker.retained {
+					if match(filter, topic) {
+						pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+						pub.TopicName = topic
+						pub.Payload = payload
+						pub.Retain = true
+						retained = append(retained, pub)
+					}
+				}
+			}
+			broker.mutex.Unlock()
+
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = packet.MessageID
+			ack.ReturnCodes = make([]byte, len(packet.Topics))
+			session.write(ack)
+			for _, pub := range retained {
+				session.write(pub)
+			}
+		case *packets.UnsubscribePacket:
+			broker.mutex.Lock()
+			for _, filter := range packet.Topics {
+				delete(session.filters, filter)
+			}
+			broker.mutex.Unlock()
+
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = packet.MessageID
+			session.write(ack)
+		case *packets.PingreqPacket:
+			session.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			session.will = nil
+			return
+		}
+	}
+}
+
+// pahoClient adapts a paho client to the Client interface
+type pahoClient struct {
+	paho.Client
+}
+
+func (pc pahoClient) Publish(topic string, retained bool, payload []byte) error {
+	token := pc.Client.Publish(topic, 1, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (pc pahoClient) Subscribe(filter string, handler Handler) error {
+	token := pc.Client.Subscribe(filter, 1, func(_ paho.Client, msg paho.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (pc pahoClient) Unsubscribe(filters ...string) error {
+	token := pc.Client.Unsubscribe(filters...)
+	token.Wait()
+	return token.Error()
+}
+
+func connectPaho(t *testing.T, broker *mqttBroker, clientID string, will bool) paho.Client {
+	options := paho.NewClientOptions()
+	options.AddBroker(broker.URL())
+	options.SetClientID(clientID)
+	options.SetAutoReconnect(false)
+	if will {
+		options.SetWill(AvailabilityTopic(DefaultPrefix), Offline, 1, true)
+	}
+
+	client := paho.NewClient(options)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("%s failed to connect: %v", clientID, token.Error())
+	}
+	return client
+}
+
+// subscribePaho connects a client that sends every message received for
+// the filter to the returned channel
+func subscribePaho(t *testing.T, broker *mqttBroker, clientID, filter string) (paho.Client, <-chan publication) {
+	client := connectPaho(t, broker, clientID, false)
+	pubCh := make(chan publication, 16)
+	token := client.Subscribe(filter, 1, func(_ paho.Client, msg paho.Message) {
+		pubCh <- publication{msg.Topic(), msg.Retained(), string(msg.Payload())}
+	})
+
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("%s failed to subscribe: %v", clientID, token.Error())
+	}
+	return client, pubCh
+}
+
+// waitPublication returns the next publication to the topic
+func waitPublication(t *testing.T, pubCh <-chan publication, topic string) publication {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case pub := <-pubCh:
+			if pub.topic == topic {
+				return pub
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a publication to %s", topic)
+			return publication{}
+		}
+	}
+}
+
+func TestBridgeBroker(t *testing.T) {
+	broker := newMQTTBroker(t)
+	defer broker.Close()
+
+	client := connectPaho(t, broker, "bridge", true)
+	defer client.Disconnect(0)
+
+	network := testNetwork{insteon.Address{1, 2, 3}: newTestSwitch(insteon.Address{1, 2, 3})}
+	bridge := New(pahoClient{client}, Config{Modem: &testModem{}, Network: network})
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	watcher, pubCh := subscribePaho(t, broker, "watcher", "insteon/#")
+	defer watcher.Disconnect(0)
+	if pub := waitPublication(t, pubCh, "insteon/bridge/availability"); pub.payload != Online || !pub.retained {
+		t.Errorf("expected retained %q got %+v", Online, pub)
+	}
+
+	watcher.Publish("insteon/01.02.03/set", 1, false, "ON").Wait()
+	if pub := waitPublication(t, pubCh, "insteon/01.02.03/state"); pub.payload != `{"state":"ON","level":255}` || pub.retained {
+		t.Errorf("expected live state got %+v", pub)
+	}
+
+	// a client that subscribes later receives the retained state
+	late, lateCh := subscribePaho(t, broker, "late", "insteon/+/state")
+	if pub := waitPublication(t, lateCh, "insteon/01.02.03/state"); pub.payload != `{"state":"ON","level":255}` || !pub.retained {
+		t.Errorf("expected retained state got %+v", pub)
+	}
+	late.Disconnect(0)
+
+	// losing the bridge's connection publishes the last will
+	broker.Drop("bridge")
+	if pub := waitPublication(t, pubCh, "insteon/bridge/availability"); pub.payload != Offline {
+		t.Errorf("expected last will %q got %+v", Offline, pub)
+	}
+
+	late, lateCh = subscribePaho(t, broker, "late", "insteon/bridge/availability")
+	defer late.Disconnect(0)
+	if pub := waitPublication(t, lateCh, "insteon/bridge/availability"); pub.payload != Offline || !pub.retained {
+		t.Errorf("expected retained last will got %+v", pub)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/abates/insteon"
+)
+
+// DefaultDiscoveryPrefix is the topic prefix that Home Assistant
+// listens to for discovery messages
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// Home Assistant components
+const (
+	ComponentLight        = "light"
+	ComponentSwitch       = "switch"
+	ComponentBinarySensor = "binary_sensor"
+	ComponentCover        = "cover"
+	ComponentClimate      = "climate"
+	ComponentFan          = "fan"
+)
+
+// Device categories that are mapped to Home Assistant components
+const (
+	categoryDimmable    = insteon.Category(0x01)
+	categorySwitched    = insteon.Category(0x02)
+	categoryClimate     = insteon.Category(0x05)
+	categorySensor      = insteon.Category(0x07)
+	categoryCovering    = insteon.Category(0x0e)
+	categorySecurity    = insteon.Category(0x10)
+	subCategoryFanLinc  = insteon.SubCategory(0x2e)
+	subCategoryIOLinc   = insteon.SubCategory(0x00)
+	subCategoryMotion   = insteon.SubCategory(0x01)
+	subCategoryOpening  = insteon.SubCategory(0x02)
+	subCategoryLeak     = insteon.SubCategory(0x08)
+	subCategorySmokeBrg = insteon.SubCategory(0x0a)
+)
+
+// Component returns the Home Assistant component for the device
+// category. If the category is not supported then an empty string
+// is returned
+func Component(devCat insteon.DevCat) string {
+	switch devCat.Category() {
+	case categoryDimmable:
+		if devCat.SubCategory() == subCategoryFanLinc {
+			return ComponentFan
+		}
+		return ComponentLight
+	case categorySwitched:
+		return ComponentSwitch
+	case categoryClimate:
+		return ComponentClimate
+	case categorySensor:
+		if devCat.SubCategory() == subCategoryIOLinc {
+			return ComponentSwitch
+		}
+		return ComponentBinarySensor
+	case categoryCovering:
+		return ComponentCover
+	case categorySecurity:
+		return ComponentBinarySensor
+	}
+	return ""
+}
+
+// deviceClass returns the Home Assistant device class for binary sensors
+func deviceClass(devCat insteon.DevCat) string {
+	if devCat.Category() == categorySecurity {
+		switch devCat.SubCategory() {
+		case subCategoryMotion:
+			return "motion"
+		case subCategoryOpening:
+			return "opening"
+		case subCategoryLeak:
+			return "moisture"
+		case subCategorySmokeBrg:
+			return "smoke"
+		}
+	}
+	return ""
+}
+
+// DiscoveryDevice identifies the physical device in Home Assistant's
+// device registry
+type DiscoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// DiscoveryConfig is the payload of a Home Assistant MQTT discovery
+// message.  Only the fields that apply to the component are set
+type DiscoveryConfig struct {
+	Name                string          `json:"name"`
+	UniqueID            string          `json:"unique_id"`
+	DeviceClass         string          `json:"device_class,omitempty"`
+	AvailabilityTopic   string          `json:"availability_topic"`
+	PayloadAvailable    string          `json:"payload_available"`
+	PayloadNotAvailable string          `json:"payload_not_available"`
+	StateTopic          string          `json:"state_topic"`
+	CommandTopic        string          `json:"command_topic,omitempty"`
+	ValueTemplate       string          `json:"value_template,omitempty"`
+	StateValueTemplate  string          `json:"state_value_template,omitempty"`
+	PayloadOn           string          `json:"payload_on,omitempty"`
+	PayloadOff          string          `json:"payload_off,omitempty"`
+	BrightnessState     string          `json:"brightness_state_topic,omitempty"`
+	BrightnessCommand   string          `json:"brightness_command_topic,omitempty"`
+	BrightnessTemplate  string          `json:"brightness_value_template,omitempty"`
+	BrightnessScale     int             `json:"brightness_scale,omitempty"`
+	OnCommandType       string          `json:"on_command_type,omitempty"`
+	PositionTopic       string          `json:"position_topic,omitempty"`
+	PositionTemplate    string          `json:"position_template,omitempty"`
+	SetPositionTopic    string          `json:"set_position_topic,omitempty"`
+	PositionOpen        int             `json:"position_open,omitempty"`
+	PayloadOpen         string          `json:"payload_open,omitempty"`
+	PayloadClose        string          `json:"payload_close,omitempty"`
+	StateOpen           string          `json:"state_open,omitempty"`
+	StateClosed         string          `json:"state_closed,omitempty"`
+	ModeStateTopic      string          `json:"mode_state_topic,omitempty"`
+	ModeStateTemplate   string          `json:"mode_state_template,omitempty"`
+	Modes               []string        `json:"modes,omitempty"`
+	Device              DiscoveryDevice `json:"device"`
+}
+
+func uniqueID(address insteon.Address) string {
+	return "insteon_" + strings.Replace(address.String(), ".", "", -1)
+}
+
+// NewDiscoveryConfig creates the Home Assistant configuration for the
+// device using the bridge's state and command topics
+func (b *Bridge) NewDiscoveryConfig(component string, info insteon.DeviceInfo) *DiscoveryConfig {
+	name := info.Name
+	if name == "" {
+		name = fmt.Sprintf("Insteon %s", info.Address)
+	}
+
+	stateTopic := b.topic(info.Address.String(), "state")
+	setTopic := b.topic(info.Address.String(), "set")
+	config := &DiscoveryConfig{
+		Name:                name,
+		UniqueID:            uniqueID(info.Address),
+		AvailabilityTopic:   AvailabilityTopic(b.config.Prefix),
+		PayloadAvailable:    Online,
+		PayloadNotAvailable: Offline,
+		StateTopic:          stateTopic,
+		Device: DiscoveryDevice{
+			Identifiers:  []string{uniqueID(info.Address)},
+			Name:         name,
+			Manufacturer: "Insteon",
+			Model:        info.DevCat.String(),
+		},
+	}
+
+	if info.FirmwareVersion != 0 {
+		config.Device.SWVersion = info.FirmwareVersion.String()
+	}
+
+	switch component {
+	case ComponentLight:
+		config.CommandTopic = setTopic
+		config.StateValueTemplate = "{{ value_json.state }}"
+		config.BrightnessState = stateTopic
+		config.BrightnessCommand = setTopic
+		config.BrightnessTemplate = "{{ value_json.level }}"
+		config.BrightnessScale = 255
+		config.OnCommandType = "brightness"
+	case ComponentSwitch, ComponentFan:
+		config.CommandTopic = setTopic
+		config.PayloadOn = "ON"
+		config.PayloadOff = "OFF"
+		if component == ComponentFan {
+			config.StateValueTemplate = "{{ value_json.state }}"
+		} else {
+			config.ValueTemplate = "{{ value_json.state }}"
+		}
+	case ComponentBinarySensor:
+		config.DeviceClass = deviceClass(info.DevCat)
+		config.ValueTemplate = "{{ value_json.state }}"
+		config.PayloadOn = "ON"
+		config.PayloadOff = "OFF"
+	case ComponentCover:
+		config.CommandTopic = setTopic
+		config.ValueTemplate = "{{ value_json.state }}"
+		config.PayloadOpen = "ON"
+		config.PayloadClose = "OFF"
+		config.StateOpen = "ON"
+		config.StateClosed = "OFF"
+		config.PositionTopic = stateTopic
+		config.PositionTemplate = "{{ value_json.level }}"
+		config.SetPositionTopic = setTopic
+		config.PositionOpen = 255
+	case ComponentClimate:
+		// thermostat state is not published by the bridge, so only
+		// the current mode (on/off) is reported
+		config.StateTopic = ""
+		config.ModeStateTopic = stateTopic
+		config.ModeStateTemplate = "{{ 'off' if value_json.state == 'OFF' else 'auto' }}"
+		config.Modes = []string{"off", "auto"}
+	}
+	return config
+}
+
+// DiscoveryTopic returns the topic the discovery config for the
+// device is published to
+func DiscoveryTopic(prefix, component string, address insteon.Address) string {
+	if prefix == "" {
+		prefix = DefaultDiscoveryPrefix
+	}
+	return strings.Join([]string{prefix, component, uniqueID(address), "config"}, "/")
+}
+
+// component determines the Home Assistant component for the device.  If
+// the network can connect to the device then the device type (Dimmer or
+// Switch) takes precedence over the category in the product database
+func (b *Bridge) component(info insteon.DeviceInfo, device insteon.Device) string {
+	component := Component(info.DevCat)
+	if component == ComponentFan {
+		return component
+	}
+
+	switch device.(type) {
+	case insteon.Dimmer:
+		component = ComponentLight
+	case insteon.Switch:
+		component = ComponentSwitch
+	}
+	return component
+}
+
+// PublishDiscovery publishes (retained) Home Assistant discovery
+// configuration for each of the devices.  Devices without a name in
+// the product database are named using their text string, if they
+// support one.  Devices that cannot be mapped to a Home Assistant
+// component are skipped
+func (b *Bridge) PublishDiscovery(prefix string, devices []insteon.DeviceInfo) error {
+	for _, info := range devices {
+		var device insteon.Device
+		if b.config.Network != nil {
+			device, _ = b.connect(info.Address)
+		}
+
+		component := b.component(info, device)
+		if component == "" {
+			insteon.Log.Debugf("No Home Assistant component for %v (%v)", info.Address, info.DevCat)
+			continue
+		}
+
+		if nameable, ok := device.(insteon.NameableDevice); ok && info.Name == "" {
+			name, err := nameable.TextString()
+			if err == nil {
+				info.Name = name
+			}
+		}
+
+		err := b.publishJSON(DiscoveryTopic(prefix, component, info.Address), true, b.NewDiscoveryConfig(component, info))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
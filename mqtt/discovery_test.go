@@ -0,0 +1,113 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/abates/insteon"
+)
+
+type testNameable struct {
+	*testSwitch
+}
+
+func (tn *testNameable) TextString() (string, error) { return "Porch", nil }
+func (tn *testNameable) SetTextString(string) error  { return nil }
+
+func TestComponent(t *testing.T) {
+	tests := []struct {
+		devCat   insteon.DevCat
+		expected string
+	}{
+		{insteon.DevCat{0x01, 0x20}, ComponentLight},
+		{insteon.DevCat{0x01, 0x2e}, ComponentFan},
+		{insteon.DevCat{0x02, 0x2a}, ComponentSwitch},
+		{insteon.DevCat{0x05, 0x0b}, ComponentClimate},
+		{insteon.DevCat{0x07, 0x00}, ComponentSwitch},
+		{insteon.DevCat{0x07, 0x1a}, ComponentBinarySensor},
+		{insteon.DevCat{0x0e, 0x01}, ComponentCover},
+		{insteon.DevCat{0x10, 0x01}, ComponentBinarySensor},
+		{insteon.DevCat{0x03, 0x15}, ""},
+	}
+
+	for i, test := range tests {
+		if got := Component(test.devCat); got != test.expected {
+			t.Errorf("tests[%d] expected %q got %q", i, test.expected, got)
+		}
+	}
+}
+
+func TestPublishDiscovery(t *testing.T) {
+	bridge, broker, _, network := newTestBridge(Config{})
+	network[insteon.Address{0x0d, 0x0e, 0x0f}] = &testNameable{newTestSwitch(insteon.Address{0x0d, 0x0e, 0x0f})}
+
+	devices := []insteon.DeviceInfo{
+		{Address: insteon.Address{1, 2, 3}},
+		{Address: insteon.Address{4, 5, 6}, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x45, Name: "Kitchen"},
+		{Address: insteon.Address{7, 8, 9}, DevCat: insteon.DevCat{0x10, 0x01}},
+		{Address: insteon.Address{0x0a, 0x0b, 0x0c}, DevCat: insteon.DevCat{0x03, 0x15}},
+		{Address: insteon.Address{0x0d, 0x0e, 0x0f}, DevCat: insteon.DevCat{0x02, 0x2a}},
+	}
+
+	err := bridge.PublishDiscovery("", devices)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		topic string
+		check func(*DiscoveryConfig) bool
+	}{
+		{"homeassistant/switch/insteon_010203/config", func(config *DiscoveryConfig) bool {
+			return config.Name == "Insteon 01.02.03" && config.CommandTopic == "insteon/01.02.03/set"
+		}},
+		{"homeassistant/light/insteon_040506/config", func(config *DiscoveryConfig) bool {
+			return config.Name == "Kitchen" && config.BrightnessCommand == "insteon/04.05.06/set" && config.Device.Model == "01.20"
+		}},
+		{"homeassistant/binary_sensor/insteon_070809/config", func(config *DiscoveryConfig) bool {
+			return config.DeviceClass == "motion" && config.CommandTopic == "" && config.StateTopic == "insteon/07.08.09/state"
+		}},
+		{"homeassistant/switch/insteon_0d0e0f/config", func(config *DiscoveryConfig) bool {
+			return config.Name == "Porch"
+		}},
+	}
+
+	for i, test := range tests {
+		broker.Lock()
+		payload, found := broker.retained[test.topic]
+		broker.Unlock()
+
+		if !found {
+			t.Errorf("tests[%d] expected discovery config on %q", i, test.topic)
+			continue
+		}
+
+		config := &DiscoveryConfig{}
+		err := json.Unmarshal([]byte(payload), config)
+		if err != nil {
+			t.Errorf("tests[%d] unexpected error: %v", i, err)
+		} else if !test.check(config) {
+			t.Errorf("tests[%d] unexpected config %s", i, payload)
+		} else if config.AvailabilityTopic != "insteon/bridge/availability" {
+			t.Errorf("tests[%d] expected availability topic got %q", i, config.AvailabilityTopic)
+		}
+	}
+
+	if len(broker.retained) != len(tests) {
+		t.Errorf("expected %d discovery configs got %d", len(tests), len(broker.retained))
+	}
+}
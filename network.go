@@ -23,9 +23,10 @@ import (
 // has attempted to send the packet, the Err field will be assigned and
 // DoneCh will be written to and closed
 type PacketRequest struct {
-	Payload []byte
-	Err     error
-	DoneCh  chan<- *PacketRequest
+	Payload  []byte
+	Priority Priority
+	Err      error
+	DoneCh   chan<- *PacketRequest
 }
 
 // MessageRequest is used to request a message be sent to a specific device.
@@ -33,23 +34,39 @@ type PacketRequest struct {
 // encountered an error, the Ack and Err fields will be filled and DoneCh
 // will be written to and closed
 type MessageRequest struct {
-	Message *Message
-	timeout time.Time
-	Ack     *Message
-	Err     error
-	DoneCh  chan<- *MessageRequest
+	Message  *Message
+	Priority Priority
+	Attempts int // the number of times the message was sent
+	sent     time.Time
+	timeout  time.Time
+	Ack      *Message
+	Err      error
+	DoneCh   chan<- *MessageRequest
 }
 
 // Network is the main means to communicate with
 // devices on the Insteon network
 type Network struct {
-	timeout     time.Duration
-	DB          ProductDatabase
-	connections []chan<- *Message
+	timeout time.Duration
+	DB      ProductDatabase
+
+	// RetryPolicy is used for messages sent to devices connected after
+	// it is set
+	RetryPolicy RetryPolicy
+
+	// DedupWindow is how long repeats of a broadcast or cleanup message
+	// are hidden from subscribers.  It must be set before any messages
+	// are received, a window of zero disables deduplication
+	DedupWindow time.Duration
+
+	quality     *qualityTracker
+	dedup       *dedupFilter
+	status      *statusRequests
+	connections []subscription
 
 	sendCh       chan<- *PacketRequest
 	recvCh       <-chan []byte
-	connectCh    chan chan<- *Message
+	connectCh    chan subscription
 	disconnectCh chan chan<- *Message
 	closeCh      chan chan error
 }
@@ -59,12 +76,17 @@ type Network struct {
 // messages/responses
 func New(sendCh chan<- *PacketRequest, recvCh <-chan []byte, timeout time.Duration) *Network {
 	network := &Network{
-		timeout: timeout,
-		DB:      NewProductDB(),
+		timeout:     timeout,
+		DB:          NewProductDB(),
+		RetryPolicy: DefaultRetryPolicy,
+		DedupWindow: DefaultDedupWindow,
+		quality:     newQualityTracker(),
+		dedup:       newDedupFilter(),
+		status:      newStatusRequests(),
 
 		sendCh:       sendCh,
 		recvCh:       recvCh,
-		connectCh:    make(chan chan<- *Message),
+		connectCh:    make(chan subscription),
 		disconnectCh: make(chan chan<- *Message),
 		closeCh:      make(chan chan error),
 	}
@@ -109,8 +131,15 @@ func (network *Network) receive(buf []byte) {
 			network.DB.UpdateEngineVersion(msg.Src, EngineVersion(msg.Command[2]))
 		}
 
+		duplicate := network.dedup.duplicate(msg, time.Now(), network.DedupWindow)
+		if duplicate {
+			Log.Tracef("Duplicate message %v", msg)
+		}
+
 		for _, connection := range network.connections {
-			connection <- msg
+			if connection.raw || !duplicate {
+				connection.ch <- msg
+			}
 		}
 	}
 	Log.Errorf(err, "Failed unmarshalling message received from network: %v", err)
@@ -119,15 +148,43 @@ func (network *Network) receive(buf []byte) {
 
 func (network *Network) disconnect(connection chan<- *Message) {
 	for i, conn := range network.connections {
-		if conn == connection {
-			close(conn)
+		if conn.ch == connection {
+			close(conn.ch)
 			network.connections = append(network.connections[0:i], network.connections[i+1:]...)
 			break
 		}
 	}
 }
 
-func (network *Network) sendMessage(msg *Message) error {
+// subscription is a channel that messages received from the network are
+// delivered to.  Raw subscriptions also receive the repeats of messages
+type subscription struct {
+	ch  chan<- *Message
+	raw bool
+}
+
+// Subscribe will register the channel to receive every message that is
+// received from the Insteon network.  Repeats of a broadcast or cleanup
+// message within the DedupWindow are only delivered once.  Subscribers
+// must continually read from the channel, otherwise delivery to other
+// connections will block
+func (network *Network) Subscribe(ch chan<- *Message) {
+	network.connectCh <- subscription{ch: ch}
+}
+
+// SubscribeRaw is the same as Subscribe except that every repeat of a
+// message is delivered, which is useful when monitoring the network
+func (network *Network) SubscribeRaw(ch chan<- *Message) {
+	network.connectCh <- subscription{ch: ch, raw: true}
+}
+
+// Unsubscribe will remove the channel from the list of subscribers. Once
+// removed, the channel is closed
+func (network *Network) Unsubscribe(ch chan<- *Message) {
+	network.disconnectCh <- ch
+}
+
+func (network *Network) sendMessage(msg *Message, priority Priority) error {
 	buf, err := msg.MarshalBinary()
 
 	if err == nil {
@@ -138,8 +195,9 @@ func (network *Network) sendMessage(msg *Message) error {
 			}
 		}
 
+		network.status.send(msg, time.Now())
 		doneCh := make(chan *PacketRequest, 1)
-		request := &PacketRequest{buf, nil, doneCh}
+		request := &PacketRequest{Payload: buf, Priority: priority, DoneCh: doneCh}
 		network.sendCh <- request
 		<-doneCh
 		err = request.Err
@@ -203,16 +261,34 @@ func (network *Network) connect(dst Address, version EngineVersion, match ...Com
 	recvCh := make(chan *Message, 1)
 	go func() {
 		for request := range sendCh {
-			request.Err = network.sendMessage(request.Message)
+			request.Err = network.sendMessage(request.Message, request.Priority)
 			request.DoneCh <- request
 		}
 		network.disconnectCh <- recvCh
 	}()
-	connection := newConnection(sendCh, recvCh, dst, version, network.timeout, match...)
-	network.connectCh <- recvCh
+	connection := newConnection(sendCh, recvCh, dst, version, network.timeout, network.RetryPolicy, network.quality, match...)
+	network.connectCh <- subscription{ch: recvCh, raw: true}
 	return connection
 }
 
+// LinkQuality returns the link quality collected for messages sent to the
+// device.  False is returned if no messages have been sent to the device
+func (network *Network) LinkQuality(address Address) (LinkQuality, bool) {
+	return network.quality.get(address)
+}
+
+// LinkQualities returns the link quality of every device that messages
+// have been sent to, ordered by address
+func (network *Network) LinkQualities() []LinkQuality {
+	return network.quality.all()
+}
+
+// deviceTimeout is long enough for a device to wait for every attempt
+// that the retry policy allows, plus one timeout period to spare
+func (network *Network) deviceTimeout() time.Duration {
+	return network.timeout * time.Duration(network.RetryPolicy.Retries+2)
+}
+
 // Dial will return a basic device object that can appropriately communicate
 // with the physical device out on the insteon network. Dial will determine
 // the engine version (1, 2, or 2CS) that the device is running and return
@@ -234,11 +310,11 @@ func (network *Network) Dial(dst Address) (device Device, err error) {
 		connection := network.connect(dst, info.EngineVersion)
 		switch info.EngineVersion {
 		case VerI1:
-			device = NewI1Device(dst, connection.sendCh, connection.recvCh, network.timeout)
+			device = NewI1Device(dst, connection.sendCh, connection.recvCh, network.deviceTimeout())
 		case VerI2:
-			device = NewI2Device(dst, connection.sendCh, connection.recvCh, network.timeout)
+			device = NewI2Device(dst, connection.sendCh, connection.recvCh, network.deviceTimeout())
 		case VerI2Cs:
-			device = NewI2CsDevice(dst, connection.sendCh, connection.recvCh, network.timeout)
+			device = NewI2CsDevice(dst, connection.sendCh, connection.recvCh, network.deviceTimeout())
 		default:
 			err = ErrVersion
 		}
@@ -263,7 +339,7 @@ func (network *Network) Connect(dst Address) (device Device, err error) {
 	if err == nil {
 		if constructor, found := Devices.Find(info.DevCat.Category()); found {
 			connection := network.connect(dst, info.EngineVersion)
-			device, err = constructor(info, dst, connection.sendCh, connection.recvCh, network.timeout)
+			device, err = constructor(info, dst, connection.sendCh, connection.recvCh, network.deviceTimeout())
 		} else {
 			device, err = network.Dial(dst)
 		}
@@ -273,7 +349,7 @@ func (network *Network) Connect(dst Address) (device Device, err error) {
 
 func (network *Network) close() error {
 	for _, connection := range network.connections {
-		close(connection)
+		close(connection.ch)
 	}
 	network.connections = nil
 	return nil
@@ -64,7 +64,7 @@ func TestNetworkReceive(t *testing.T) {
 		network := &Network{
 			recvCh:      recvCh,
 			DB:          testDb,
-			connections: []chan<- *Message{connection},
+			connections: []subscription{{ch: connection}},
 		}
 
 		buf, _ := test.input.MarshalBinary()
@@ -84,6 +84,34 @@ func TestNetworkReceive(t *testing.T) {
 	}
 }
 
+func TestNetworkDedup(t *testing.T) {
+	network, _, recvCh := newTestNetwork(4)
+	defer network.Close()
+
+	subscriber := make(chan *Message, 4)
+	raw := make(chan *Message, 4)
+	network.Subscribe(subscriber)
+	network.SubscribeRaw(raw)
+
+	// the same all-link broadcast heard with 3, 2 and 1 hops left
+	for _, flags := range []Flags{0xcf, 0xcb, 0xc7} {
+		buf, _ := (&Message{Src: testSrcAddr, Dst: Address{0, 0, 1}, Flags: flags, Command: CmdLightOn}).MarshalBinary()
+		recvCh <- buf
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-raw:
+		case <-time.After(time.Second):
+			t.Fatalf("expected raw subscriber to receive 3 messages got %d", i)
+		}
+	}
+
+	if len(subscriber) != 1 {
+		t.Errorf("expected subscriber to receive 1 message got %d", len(subscriber))
+	}
+}
+
 func TestNetworkSendMessage(t *testing.T) {
 	tests := []struct {
 		input      *Message
@@ -114,7 +142,7 @@ func TestNetworkSendMessage(t *testing.T) {
 			request.DoneCh <- request
 		}(i)
 
-		err := network.sendMessage(test.input)
+		err := network.sendMessage(test.input, PriorityDefault)
 		if err != test.err {
 			t.Errorf("tests[%d] expected %v got %v", i, test.err, err)
 		}
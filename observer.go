@@ -0,0 +1,69 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified of the outcome of every direct message sent to a
+// device.  Observers are used to collect metrics about the health of the
+// network.  Observer methods are called synchronously from the goroutines
+// that deliver messages and must not block
+type Observer interface {
+	// MessageAcked is called when the device responds to a message.  The
+	// latency is measured from when the message was handed to the modem.
+	// If the device responded with a NAK then err is the decoded reason
+	// (ErrNotLinked, ErrNoLoadDetected, etc), otherwise it is nil
+	MessageAcked(address Address, latency time.Duration, err error)
+
+	// MessageFailed is called when a message was not acknowledged by the
+	// device, either because the modem could not send it or because no
+	// ACK was received before the timeout (ErrReadTimeout)
+	MessageFailed(address Address, err error)
+
+	// MessageRetried is called when a message is resent according to
+	// the network's RetryPolicy.  The attempt is the number of the
+	// attempt that failed with err
+	MessageRetried(address Address, attempt int, err error)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) MessageAcked(Address, time.Duration, error) {}
+func (nopObserver) MessageFailed(Address, error)               {}
+func (nopObserver) MessageRetried(Address, int, error)         {}
+
+type observerHolder struct{ Observer }
+
+var observer atomic.Value
+
+func init() {
+	observer.Store(observerHolder{nopObserver{}})
+}
+
+// SetObserver installs the observer that is notified of message delivery
+// for all networks.  Passing nil removes the current observer
+func SetObserver(o Observer) {
+	if o == nil {
+		o = nopObserver{}
+	}
+	observer.Store(observerHolder{o})
+}
+
+func observe() Observer {
+	return observer.Load().(observerHolder).Observer
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// ErrNotMessage is returned when an Insteon message is requested from a
+// captured frame that does not contain one
+var ErrNotMessage = errors.New("Frame does not contain an Insteon message")
+
+// Direction indicates whether a captured frame was sent to or received
+// from the PLM
+type Direction string
+
+// Capture directions
+const (
+	DirectionTx Direction = "tx" // written to the PLM
+	DirectionRx Direction = "rx" // read from the PLM
+)
+
+// Frame is a complete IM frame, beginning with the 0x02 start byte.  Frames
+// are encoded as hex strings in capture files
+type Frame []byte
+
+// MarshalText encodes the frame as a hex string
+func (f Frame) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(f)), nil
+}
+
+// UnmarshalText decodes a hex string
+func (f *Frame) UnmarshalText(text []byte) (err error) {
+	*f, err = hex.DecodeString(string(text))
+	return err
+}
+
+// CaptureRecord is a single frame in a capture file.  Capture files are
+// JSON-lines: each line is one record of the form
+//
+//	{"time":"2019-06-01T10:00:00.123456789Z","dir":"tx","frame":"02620102030f1100"}
+//
+// Frames are recorded exactly as they were written to or read from the
+// serial port so that both Packet and insteon.Message values can be
+// reconstructed
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	Frame     Frame     `json:"frame"`
+}
+
+// Packet decodes the frame into a Packet.  Frames written to the PLM do
+// not include the trailing ACK byte that the PLM echoes back
+func (cr *CaptureRecord) Packet() (*Packet, error) {
+	if len(cr.Frame) < 2 || cr.Frame[0] != 0x02 {
+		return nil, ErrNoSync
+	}
+
+	packet := &Packet{}
+	if cr.Direction == DirectionRx {
+		err := packet.UnmarshalBinary(cr.Frame)
+		return packet, err
+	}
+
+	packet.Command = Command(cr.Frame[1])
+	packet.Payload = append([]byte{}, cr.Frame[2:]...)
+	return packet, nil
+}
+
+// Message decodes the Insteon message contained in the frame.  Messages
+// sent by the PLM (Send INSTEON Msg) do not include the source address,
+// so it is left as 00.00.00
+func (cr *CaptureRecord) Message() (*insteon.Message, error) {
+	packet, err := cr.Packet()
+	if err != nil {
+		return nil, err
+	}
+
+	payload := packet.Payload
+	switch packet.Command {
+	case CmdStdMsgReceived, CmdExtMsgReceived:
+	case CmdSendInsteonMsg:
+		if cr.Direction == DirectionTx {
+			payload = append(make([]byte, 3), payload...)
+		}
+	default:
+		return nil, ErrNotMessage
+	}
+
+	msg := &insteon.Message{}
+	err = msg.UnmarshalBinary(payload)
+	return msg, err
+}
+
+// CaptureWriter writes captured frames to a capture file.  It is safe
+// for concurrent use
+type CaptureWriter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewCaptureWriter returns a writer that encodes records to w
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{encoder: json.NewEncoder(w)}
+}
+
+// Write records the frame with the current time
+func (cw *CaptureWriter) Write(direction Direction, frame []byte) error {
+	return cw.WriteRecord(&CaptureRecord{Time: time.Now(), Direction: direction, Frame: frame})
+}
+
+// WriteRecord writes the record to the capture file
+func (cw *CaptureWriter) WriteRecord(record *CaptureRecord) error {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	return cw.encoder.Encode(record)
+}
+
+// CaptureReader reads records from a capture file
+type CaptureReader struct {
+	scanner *bufio.Scanner
+}
+
+// NewCaptureReader returns a reader for the capture file
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{scanner: bufio.NewScanner(r)}
+}
+
+// Read returns the next record in the file.  io.EOF is returned once
+// all the records have been read.  Blank lines are skipped
+func (cr *CaptureReader) Read() (*CaptureRecord, error) {
+	for cr.scanner.Scan() {
+		line := cr.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		record := &CaptureRecord{}
+		err := json.Unmarshal(line, record)
+		return record, err
+	}
+
+	err := cr.scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	return nil, err
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+func TestCaptureRecord(t *testing.T) {
+	tests := []struct {
+		direction       Direction
+		frame           []byte
+		expectedPacket  *Packet
+		expectedMessage *insteon.Message
+		expectedErr     error
+	}{
+		{
+			DirectionTx, []byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff},
+			&Packet{Command: CmdSendInsteonMsg, Payload: []byte{4, 5, 6, 0x0f, 0x11, 0xff}},
+			&insteon.Message{Dst: insteon.Address{4, 5, 6}, Flags: insteon.Flags(0x0f), Command: insteon.Command{0x00, 0x11, 0xff}},
+			nil,
+		},
+		{
+			DirectionRx, []byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff, 0x06},
+			&Packet{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, 0, 4, 5, 6, 0x0f, 0x11, 0xff}, Ack: 0x06},
+			&insteon.Message{Dst: insteon.Address{4, 5, 6}, Flags: insteon.Flags(0x0f), Command: insteon.Command{0x00, 0x11, 0xff}},
+			nil,
+		},
+		{
+			DirectionRx, []byte{0x02, 0x50, 4, 5, 6, 1, 2, 3, 0x2b, 0x11, 0xff},
+			&Packet{Command: CmdStdMsgReceived, Payload: []byte{4, 5, 6, 1, 2, 3, 0x2b, 0x11, 0xff}},
+			&insteon.Message{Src: insteon.Address{4, 5, 6}, Dst: insteon.Address{1, 2, 3}, Flags: insteon.Flags(0x2b), Command: insteon.Command{0x02, 0x11, 0xff}},
+			nil,
+		},
+		{
+			DirectionRx, []byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06},
+			&Packet{Command: CmdGetInfo, Payload: []byte{1, 2, 3, 0x03, 0x15, 0x9b}, Ack: 0x06},
+			nil,
+			ErrNotMessage,
+		},
+	}
+
+	for i, test := range tests {
+		record := &CaptureRecord{Direction: test.direction, Frame: test.frame}
+		packet, err := record.Packet()
+		if err != nil {
+			t.Errorf("tests[%d] unexpected error: %v", i, err)
+			continue
+		}
+
+		if packet.Command != test.expectedPacket.Command || !bytes.Equal(packet.Payload, test.expectedPacket.Payload) || packet.Ack != test.expectedPacket.Ack {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expectedPacket, packet)
+		}
+
+		msg, err := record.Message()
+		if err != test.expectedErr {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expectedErr, err)
+		} else if err == nil {
+			if msg.Src != test.expectedMessage.Src || msg.Dst != test.expectedMessage.Dst || msg.Flags != test.expectedMessage.Flags || msg.Command != test.expectedMessage.Command {
+				t.Errorf("tests[%d] expected %v got %v", i, test.expectedMessage, msg)
+			}
+		}
+	}
+}
+
+func TestCaptureWriteRead(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer := NewCaptureWriter(buf)
+	now := time.Date(2019, 6, 1, 10, 0, 0, 123456789, time.UTC)
+	writer.WriteRecord(&CaptureRecord{Time: now, Direction: DirectionTx, Frame: []byte{0x02, 0x60}})
+	writer.WriteRecord(&CaptureRecord{Time: now, Direction: DirectionRx, Frame: []byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06}})
+
+	expected := `{"time":"2019-06-01T10:00:00.123456789Z","dir":"tx","frame":"0260"}` + "\n"
+	if !strings.HasPrefix(buf.String(), expected) {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+
+	reader := NewCaptureReader(strings.NewReader(buf.String() + "\n"))
+	for i, direction := range []Direction{DirectionTx, DirectionRx} {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatalf("records[%d] unexpected error: %v", i, err)
+		}
+
+		if !record.Time.Equal(now) || record.Direction != direction {
+			t.Errorf("records[%d] expected %v %v got %v %v", i, now, direction, record.Time, record.Direction)
+		}
+	}
+
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected %v got %v", io.EOF, err)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that is safe for concurrent use
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.Lock()
+	defer lb.Unlock()
+	return lb.Buffer.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.Lock()
+	defer lb.Unlock()
+	return lb.Buffer.String()
+}
+
+type pipeReadWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func TestPortCapture(t *testing.T) {
+	reader, writer := io.Pipe()
+	port := NewPort(&pipeReadWriter{reader, &bytes.Buffer{}}, time.Second)
+	capture := &lockedBuffer{}
+	port.SetCapture(NewCaptureWriter(capture))
+
+	go writer.Write([]byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06})
+	<-port.recvCh
+	port.sendCh <- []byte{0x02, 0x60}
+
+	for i := 0; i < 100 && strings.Count(capture.String(), "\n") < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	captured := capture.String()
+	if !strings.Contains(captured, `"dir":"rx","frame":"026001020303159b06"`) {
+		t.Errorf("expected rx frame in %q", captured)
+	}
+
+	if !strings.Contains(captured, `"dir":"tx","frame":"0260"`) {
+		t.Errorf("expected tx frame in %q", captured)
+	}
+}
@@ -14,7 +14,11 @@
 
 package plm
 
-import "fmt"
+import (
+	"encoding/json"
+
+	"github.com/abates/insteon"
+)
 
 type Config byte
 
@@ -74,8 +78,50 @@ func (config *Config) MarshalBinary() ([]byte, error) {
 
 func (config *Config) UnmarshalBinary(buf []byte) error {
 	if len(buf) < 1 {
-		return fmt.Errorf("config is 1 byte, got %d", len(buf))
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 1, Got: len(buf)}
 	}
 	*config = Config(buf[0])
 	return nil
 }
+
+type configJSON struct {
+	AutomaticLinking bool `json:"automaticLinking"`
+	MonitorMode      bool `json:"monitorMode"`
+	AutomaticLED     bool `json:"automaticLED"`
+	DeadmanMode      bool `json:"deadmanMode"`
+}
+
+// MarshalJSON will convert the config flags to a JSON object
+func (config *Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&configJSON{
+		AutomaticLinking: config.AutomaticLinking(),
+		MonitorMode:      config.MonitorMode(),
+		AutomaticLED:     config.AutomaticLED(),
+		DeadmanMode:      config.DeadmanMode(),
+	})
+}
+
+// UnmarshalJSON will set the config flags from a JSON object
+func (config *Config) UnmarshalJSON(data []byte) error {
+	cj := configJSON{}
+	err := json.Unmarshal(data, &cj)
+	if err == nil {
+		*config = Config(0x00)
+		flags := []struct {
+			value bool
+			set   func()
+		}{
+			{cj.AutomaticLinking, config.setAutomaticLinking},
+			{cj.MonitorMode, config.setMonitorMode},
+			{cj.AutomaticLED, config.setAutomaticLED},
+			{cj.DeadmanMode, config.setDeadmanMode},
+		}
+
+		for _, flag := range flags {
+			if flag.value {
+				flag.set()
+			}
+		}
+	}
+	return err
+}
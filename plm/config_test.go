@@ -14,7 +14,10 @@
 
 package plm
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+)
 
 func TestSettingConfigFlags(t *testing.T) {
 	config := Config(0x00)
@@ -103,3 +106,33 @@ func TestConfigMarshalUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigMarshalUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input    byte
+		expected string
+	}{
+		{0x00, `{"automaticLinking":false,"monitorMode":false,"automaticLED":false,"deadmanMode":false}`},
+		{0x80, `{"automaticLinking":true,"monitorMode":false,"automaticLED":false,"deadmanMode":false}`},
+		{0x50, `{"automaticLinking":false,"monitorMode":true,"automaticLED":false,"deadmanMode":true}`},
+		{0xf0, `{"automaticLinking":true,"monitorMode":true,"automaticLED":true,"deadmanMode":true}`},
+	}
+
+	for i, test := range tests {
+		config := Config(test.input)
+		buf, err := json.Marshal(&config)
+		if err != nil {
+			t.Errorf("tests[%d] unexpected error: %v", i, err)
+		} else if string(buf) != test.expected {
+			t.Errorf("tests[%d] expected %s got %s", i, test.expected, string(buf))
+		}
+
+		config = Config(0x0f)
+		err = json.Unmarshal([]byte(test.expected), &config)
+		if err != nil {
+			t.Errorf("tests[%d] unexpected error: %v", i, err)
+		} else if byte(config) != test.input {
+			t.Errorf("tests[%d] expected 0x%02x got 0x%02x", i, test.input, byte(config))
+		}
+	}
+}
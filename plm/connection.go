@@ -71,10 +71,15 @@ func (conn *connection) send(request *insteon.PacketRequest) {
 		payload = payload[3:]
 	}
 
-	conn.upstreamSendCh <- &CommandRequest{Command: conn.sendCmd, Payload: payload, DoneCh: doneCh}
-	upstreamRequest := <-doneCh
-	request.Err = upstreamRequest.Err
-	request.DoneCh <- request
+	conn.upstreamSendCh <- &CommandRequest{Command: conn.sendCmd, Payload: payload, Priority: request.Priority, DoneCh: doneCh}
+
+	// wait for the result in the background so that requests from
+	// other senders are not held up behind this one
+	go func() {
+		upstreamRequest := <-doneCh
+		request.Err = upstreamRequest.Err
+		request.DoneCh <- request
+	}()
 }
 
 func (conn *connection) receive(packet *Packet) {
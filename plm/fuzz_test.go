@@ -0,0 +1,99 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package plm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abates/insteon"
+)
+
+// checkShortBuffer fails the test if a buffer shorter than need was not
+// rejected with a BufError
+func checkShortBuffer(t *testing.T, data []byte, need int, err error) {
+	if len(data) < need && cause(err) != insteon.ErrBufferTooShort {
+		t.Errorf("expected buffer error for %d bytes got %v", len(data), err)
+	}
+}
+
+func FuzzPacket(f *testing.F) {
+	f.Add([]byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06})
+	f.Add([]byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff, 0x06})
+	f.Add([]byte{0x02, 0x50, 4, 5, 6, 1, 2, 3, 0x2b, 0x11, 0xff})
+	f.Add([]byte{0x02, 0x62})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet := &Packet{}
+		err := packet.UnmarshalBinary(data)
+		if len(data) > 0 && data[0] != 0x02 {
+			if err != ErrNoSync {
+				t.Errorf("expected %v got %v", ErrNoSync, err)
+			}
+			return
+		}
+
+		checkShortBuffer(t, data, 2, err)
+		if len(data) >= 2 && 0x60 <= data[1] && data[1] <= 0x7f {
+			checkShortBuffer(t, data, 3, err)
+		}
+
+		if err == nil {
+			// exercise the formatting of arbitrary packets
+			_ = packet.String()
+		}
+	})
+}
+
+func FuzzInfo(f *testing.F) {
+	f.Add([]byte{1, 2, 3, 0x03, 0x15, 0x9b})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info := &Info{}
+		err := info.UnmarshalBinary(data)
+		checkShortBuffer(t, data, 6, err)
+		if err == nil {
+			buf, _ := info.MarshalBinary()
+			if !bytes.Equal(buf, data[0:6]) {
+				t.Errorf("expected %x got %x", data[0:6], buf)
+			}
+		}
+	})
+}
+
+func FuzzConfig(f *testing.F) {
+	f.Add([]byte{0xf0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var config Config
+		checkShortBuffer(t, data, 1, config.UnmarshalBinary(data))
+	})
+}
+
+func FuzzManageRecordRequest(f *testing.F) {
+	f.Add([]byte{0x40, 0xe2, 0x01, 1, 2, 3, 0x03, 0x1c, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		mrr := &manageRecordRequest{}
+		checkShortBuffer(t, data, 9, mrr.UnmarshalBinary(data))
+	})
+}
+
+func FuzzAllLinkReq(f *testing.F) {
+	f.Add([]byte{0x03, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		alr := &AllLinkReq{}
+		checkShortBuffer(t, data, 2, alr.UnmarshalBinary(data))
+	})
+}
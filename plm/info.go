@@ -25,9 +25,9 @@ type Version byte
 func (v Version) String() string { return fmt.Sprintf("%d", byte(v)) }
 
 type Info struct {
-	Address  insteon.Address
-	DevCat   insteon.DevCat
-	Firmware Version
+	Address  insteon.Address `json:"address"`
+	DevCat   insteon.DevCat  `json:"devCat"`
+	Firmware Version         `json:"firmware"`
 }
 
 func (info *Info) String() string {
@@ -44,6 +44,10 @@ func (info *Info) MarshalBinary() ([]byte, error) {
 }
 
 func (info *Info) UnmarshalBinary(data []byte) error {
+	if len(data) < 6 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 6, Got: len(data)}
+	}
+
 	copy(info.Address[:], data[0:3])
 	copy(info.DevCat[:], data[3:5])
 	info.Firmware = Version(data[5])
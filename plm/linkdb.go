@@ -49,6 +49,9 @@ func (mrr *manageRecordRequest) MarshalBinary() ([]byte, error) {
 }
 
 func (mrr *manageRecordRequest) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 9 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 9, Got: len(buf)}
+	}
 	mrr.command = recordRequestCommand(buf[0])
 	mrr.link = &insteon.LinkRecord{}
 	return mrr.link.UnmarshalBinary(buf[1:])
@@ -67,7 +70,7 @@ func (alr *AllLinkReq) MarshalBinary() ([]byte, error) {
 
 func (alr *AllLinkReq) UnmarshalBinary(buf []byte) error {
 	if len(buf) < 2 {
-		return fmt.Errorf("Needed 2 bytes to unmarshal all link request.  Got %d", len(buf))
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 2, Got: len(buf)}
 	}
 	alr.Mode = LinkingMode(buf[0])
 	alr.Group = insteon.Group(buf[1])
@@ -84,7 +87,7 @@ func (db *PLM) Links() ([]*insteon.LinkRecord, error) {
 
 	links := make([]*insteon.LinkRecord, 0)
 	insteon.Log.Debugf("Retrieving PLM link database")
-	_, err := db.Retry(&Packet{Command: CmdGetFirstAllLink}, 0)
+	_, err := db.RetryPriority(&Packet{Command: CmdGetFirstAllLink}, 0, insteon.PriorityBackground)
 	if err == ErrNak {
 		err = nil
 	} else if err == nil {
@@ -95,7 +98,7 @@ func (db *PLM) Links() ([]*insteon.LinkRecord, error) {
 				insteon.Log.Debugf("Received PLM record response %v", link)
 				links = append(links, link)
 				doneCh := make(chan *insteon.PacketRequest, 1)
-				sendCh <- &insteon.PacketRequest{DoneCh: doneCh}
+				sendCh <- &insteon.PacketRequest{Priority: insteon.PriorityBackground, DoneCh: doneCh}
 				request := <-doneCh
 
 				if request.Err != nil {
@@ -110,82 +113,348 @@ func (db *PLM) Links() ([]*insteon.LinkRecord, error) {
 	return links, err
 }
 
-func (plm *PLM) RemoveLinks(oldLinks ...*insteon.LinkRecord) (err error) {
-	deletedLinks := make([]*insteon.LinkRecord, 0)
-	for _, oldLink := range oldLinks {
-		numDelete := 0
-		var links []*insteon.LinkRecord
-		links, err = plm.Links()
-		if err == nil {
-			for _, link := range links {
-				if link.Group == oldLink.Group && link.Address == oldLink.Address {
-					numDelete++
-					if !oldLink.Equal(link) {
-						deletedLinks = append(deletedLinks, link)
-					}
-				}
+// linkKey is the group and address that the PLM uses to find records
+type linkKey struct {
+	group   insteon.Group
+	address insteon.Address
+}
+
+func keyOf(link *insteon.LinkRecord) linkKey {
+	return linkKey{link.Group, link.Address}
+}
+
+// sameRecord returns true if the records have the same group, address,
+// type (controller or responder) and data
+func sameRecord(l1, l2 *insteon.LinkRecord) bool {
+	return keyOf(l1) == keyOf(l2) && l1.Flags.Controller() == l2.Flags.Controller() && l1.Data == l2.Data
+}
+
+// recordsFor returns the records in links for the key, in database order
+func recordsFor(links []*insteon.LinkRecord, key linkKey) []*insteon.LinkRecord {
+	records := []*insteon.LinkRecord{}
+	for _, link := range links {
+		if keyOf(link) == key {
+			records = append(records, link)
+		}
+	}
+	return records
+}
+
+// planRecords works out how to turn the current records for a group and
+// address into the wanted records.  The PLM can only delete the first
+// record for a group and address, and can only add a record of a type
+// (controller or responder) that it doesn't already have for them.  So
+// the first deletes records are deleted, leaving records that the wanted
+// records start with, and then the additions are added.  Duplicate records
+// of the same type can't always be rebuilt, in which case exact is false
+// and only the first wanted record of each type is added after every
+// current record has been deleted
+func planRecords(current, wanted []*insteon.LinkRecord) (deletes int, additions []*insteon.LinkRecord, exact bool) {
+	for deletes = 0; deletes <= len(current); deletes++ {
+		remaining := current[deletes:]
+		if len(remaining) > len(wanted) {
+			continue
+		}
+
+		matches := true
+		for i, link := range remaining {
+			if !sameRecord(link, wanted[i]) {
+				matches = false
+				break
 			}
+		}
 
-			for i := 0; i < numDelete; i++ {
-				rr := &manageRecordRequest{command: LinkCmdDeleteFirst, link: oldLink}
-				payload, _ := rr.MarshalBinary()
-				_, err = plm.Retry(&Packet{Command: CmdManageAllLinkRecord, Payload: payload}, 0)
-				if err != nil {
-					insteon.Log.Infof("Failed to remove link: %v", err)
-					break
-				}
+		if matches && len(firstOfEach(wanted[len(remaining):], remaining)) == len(wanted)-len(remaining) {
+			return deletes, wanted[len(remaining):], true
+		}
+	}
+	return len(current), firstOfEach(wanted, nil), false
+}
+
+// firstOfEach returns the first record of each type (controller or
+// responder) in links that doesn't already have a record in existing
+func firstOfEach(links, existing []*insteon.LinkRecord) []*insteon.LinkRecord {
+	seen := make(map[bool]bool)
+	for _, link := range existing {
+		seen[link.Flags.Controller()] = true
+	}
+
+	first := []*insteon.LinkRecord{}
+	for _, link := range links {
+		if !seen[link.Flags.Controller()] {
+			seen[link.Flags.Controller()] = true
+			first = append(first, link)
+		}
+	}
+	return first
+}
+
+// sameRecords returns true if the two lists have the same records,
+// regardless of their order
+func sameRecords(l1, l2 []*insteon.LinkRecord) bool {
+	if len(l1) != len(l2) {
+		return false
+	}
+
+	unmatched := append([]*insteon.LinkRecord{}, l2...)
+	for _, link := range l1 {
+		found := false
+		for i, other := range unmatched {
+			if sameRecord(link, other) {
+				unmatched = append(unmatched[:i], unmatched[i+1:]...)
+				found = true
+				break
 			}
-		} else {
-			insteon.Log.Infof("Failed to retrieve links: %v", err)
+		}
+
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteRecords replaces the current records for a group and address
+// with the wanted records as planned by planRecords.  The records that
+// are expected to be in the database afterwards are returned
+func (db *PLM) rewriteRecords(current, wanted []*insteon.LinkRecord) ([]*insteon.LinkRecord, error) {
+	deletes, additions, _ := planRecords(current, wanted)
+	for _, link := range current[:deletes] {
+		if err := db.manageRecord(LinkCmdDeleteFirst, link); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, link := range additions {
+		if err := db.manageRecord(modFirstCommand(link), link); err != nil {
+			return nil, err
+		}
+	}
+	return append(append([]*insteon.LinkRecord{}, current[deletes:]...), additions...), nil
+}
+
+// writeRecords replaces the records for each of the keys with the wanted
+// records.  The database is read again afterwards to verify the result
+// and, if anything failed, the original records are written back
+func (db *PLM) writeRecords(links []*insteon.LinkRecord, keys []linkKey, wanted map[linkKey][]*insteon.LinkRecord) (err error) {
+	expected := make(map[linkKey][]*insteon.LinkRecord)
+	for _, key := range keys {
+		if expected[key], err = db.rewriteRecords(recordsFor(links, key), wanted[key]); err != nil {
 			break
 		}
 	}
 
 	if err == nil {
-		// add back links that we didn't want deleted
-		for _, link := range deletedLinks {
-			plm.AddLink(link)
+		var current []*insteon.LinkRecord
+		current, err = db.Links()
+		for i := 0; i < len(keys) && err == nil; i++ {
+			if !sameRecords(recordsFor(current, keys[i]), expected[keys[i]]) {
+				err = ErrLinkVerify
+			}
+		}
+	}
+
+	if err != nil {
+		insteon.Log.Infof("Failed to write links: %v", err)
+		if restoreErr := db.restoreLinks(links, keys); restoreErr != nil {
+			insteon.Log.Infof("Failed to restore links: %v", restoreErr)
 		}
 	}
 	return err
 }
 
-func (db *PLM) AddLink(newLink *insteon.LinkRecord) error {
-	var command recordRequestCommand
-	if newLink.Flags.Controller() {
-		command = LinkCmdModFirstCtrl
-	} else {
-		command = LinkCmdModFirstResp
+// restoreLinks writes back the original records for the keys.  Records
+// that are already in place are left alone
+func (db *PLM) restoreLinks(original []*insteon.LinkRecord, keys []linkKey) error {
+	current, err := db.Links()
+	for i := 0; i < len(keys) && err == nil; i++ {
+		_, err = db.rewriteRecords(recordsFor(current, keys[i]), recordsFor(original, keys[i]))
+	}
+	return err
+}
+
+// RemoveLinks removes every record with the same group, address and type
+// (controller or responder) as one of the oldLinks from the PLM All-Link
+// database.  The PLM can only delete the first record for a group and
+// address, so records of the other type that come first are deleted as
+// well and then written back with their original data.  If a second copy
+// of such a record remains then it is deleted too, since the first copy
+// can't be added back while it is there.  The database is read again
+// afterwards to verify the result and, if anything failed, the original
+// records are written back
+func (db *PLM) RemoveLinks(oldLinks ...*insteon.LinkRecord) error {
+	links, err := db.Links()
+	if err != nil {
+		insteon.Log.Infof("Failed to retrieve links: %v", err)
+		return err
+	}
+
+	keys := []linkKey{}
+	remove := make(map[linkKey]map[bool]bool)
+	for _, oldLink := range oldLinks {
+		key := keyOf(oldLink)
+		if remove[key] == nil {
+			keys = append(keys, key)
+			remove[key] = make(map[bool]bool)
+		}
+		remove[key][oldLink.Flags.Controller()] = true
+	}
+
+	wanted := make(map[linkKey][]*insteon.LinkRecord)
+	for _, key := range keys {
+		wanted[key] = []*insteon.LinkRecord{}
+		for _, link := range recordsFor(links, key) {
+			if !remove[key][link.Flags.Controller()] {
+				wanted[key] = append(wanted[key], link)
+			}
+		}
+	}
+	return db.writeRecords(links, keys, wanted)
+}
+
+// RemoveDuplicates removes every record with the same group, address and
+// type (controller or responder) as the link except the first one, which
+// keeps its data.  The PLM can only delete the first record for a group
+// and address, so the first copy is deleted along with the others and is
+// then written back.  As with RemoveLinks, records of the other type that
+// are deleted along the way are written back, the result is verified and
+// the original records are restored if anything failed
+func (db *PLM) RemoveDuplicates(link *insteon.LinkRecord) error {
+	links, err := db.Links()
+	if err != nil {
+		return err
+	}
+
+	key := keyOf(link)
+	wanted := []*insteon.LinkRecord{}
+	copies := 0
+	for _, l := range recordsFor(links, key) {
+		if l.Flags.Controller() == link.Flags.Controller() {
+			copies++
+			if copies > 1 {
+				continue
+			}
+		}
+		wanted = append(wanted, l)
 	}
-	rr := &manageRecordRequest{command: command, link: newLink}
-	payload, _ := rr.MarshalBinary()
-	resp, err := db.Retry(&Packet{Command: CmdManageAllLinkRecord, Payload: payload}, 0)
 
-	if resp.NAK() {
-		err = fmt.Errorf("Failed to add link back to ALDB")
+	if copies < 2 {
+		return nil
 	}
+	return db.writeRecords(links, []linkKey{key}, map[linkKey][]*insteon.LinkRecord{key: wanted})
+}
+
+// manageRecord sends a manage all-link record command for the link
+func (db *PLM) manageRecord(command recordRequestCommand, link *insteon.LinkRecord) error {
+	rr := &manageRecordRequest{command: command, link: link}
+	payload, _ := rr.MarshalBinary()
+	ack, err := db.Retry(&Packet{Command: CmdManageAllLinkRecord, Payload: payload}, 0)
 
+	if err == nil && ack.NAK() {
+		err = ErrNak
+	}
 	return err
 }
 
-func (db *PLM) WriteLink(*insteon.LinkRecord) error {
-	return insteon.ErrNotImplemented
+// AddLink adds the link to the PLM All-Link database.  If the database
+// already has a record of the same type (controller or responder) for
+// the group and address then that record is updated instead
+func (db *PLM) AddLink(newLink *insteon.LinkRecord) error {
+	return db.WriteLink(newLink)
+}
+
+// WriteLink updates the first record in the PLM All-Link database that
+// has the same group, address and type (controller or responder) as the
+// link, the record is added if there is no such record.  The PLM chooses
+// the flags of the record, so only the data bytes are written.  If the
+// link is marked available then the matching record is deleted instead
+func (db *PLM) WriteLink(link *insteon.LinkRecord) error {
+	if link.Flags.Available() {
+		inUse := *link
+		inUse.Flags = insteon.RecordControlFlags(byte(link.Flags) | 0x80)
+		return db.RemoveLinks(&inUse)
+	}
+
+	return db.manageRecord(modFirstCommand(link), link)
 }
 
-func (db *PLM) Cleanup() (err error) {
-	removeable := make([]*insteon.LinkRecord, 0)
+// modFirstCommand returns the command that updates the first record of
+// the link's type (controller or responder), or adds the link if there
+// is no such record
+func modFirstCommand(link *insteon.LinkRecord) recordRequestCommand {
+	if link.Flags.Controller() {
+		return LinkCmdModFirstCtrl
+	}
+	return LinkCmdModFirstResp
+}
+
+// UpdateLink replaces the record oldLink with newLink.  Since the PLM
+// finds records by group and address, rather than by location, the first
+// record of a type can be updated in place but any other change is made
+// by rebuilding the records for the group and address (see RemoveLinks),
+// and a record with a new group or address is added before the old one
+// is removed.  ErrLinkConflict is returned, without changing anything, if
+// the result would need a second record of the same type for a group and
+// address, such as when a later copy of a duplicated record is edited or
+// the new record would overwrite an existing one
+func (db *PLM) UpdateLink(oldLink, newLink *insteon.LinkRecord) error {
 	links, err := db.Links()
-	if err == nil {
-		for i, l1 := range links {
-			for _, l2 := range links[i+1:] {
-				if l1.Equal(l2) {
-					removeable = append(removeable, l2)
-				}
+	if err != nil {
+		return err
+	}
+
+	oldKey, newKey := keyOf(oldLink), keyOf(newLink)
+	records := recordsFor(links, oldKey)
+	index := -1
+	first := true
+	for i, link := range records {
+		if link.Flags.Controller() == oldLink.Flags.Controller() {
+			if sameRecord(link, oldLink) {
+				index = i
+				break
 			}
+			first = false
+		}
+	}
+
+	if index < 0 {
+		return ErrLinkNotFound
+	}
+
+	if newLink.Flags.InUse() && oldKey == newKey {
+		if first && oldLink.Flags.Controller() == newLink.Flags.Controller() {
+			return db.manageRecord(modFirstCommand(newLink), newLink)
+		} else if len(records) == 1 {
+			return db.manageRecord(LinkCmdModFirst, newLink)
+		}
+	}
+
+	keys := []linkKey{oldKey}
+	wanted := map[linkKey][]*insteon.LinkRecord{}
+	if newLink.Flags.InUse() && oldKey == newKey {
+		wanted[oldKey] = append(append(append([]*insteon.LinkRecord{}, records[:index]...), newLink), records[index+1:]...)
+	} else {
+		wanted[oldKey] = append(append([]*insteon.LinkRecord{}, records[:index]...), records[index+1:]...)
+		if newLink.Flags.InUse() {
+			// the new record is added before the old one is removed
+			keys = []linkKey{newKey, oldKey}
+			wanted[newKey] = append(recordsFor(links, newKey), newLink)
 		}
+	}
 
-		err = db.RemoveLinks(removeable...)
+	if newLink.Flags.InUse() {
+		if _, _, exact := planRecords(recordsFor(links, newKey), wanted[newKey]); !exact {
+			return ErrLinkConflict
+		}
 	}
+	return db.writeRecords(links, keys, wanted)
+}
+
+// Cleanup removes duplicate and orphaned links from the PLM All-Link
+// database.  The PLM manages its own memory, so deleted records are
+// reused without compacting the database
+func (db *PLM) Cleanup() (err error) {
+	_, err = insteon.Cleanup(db)
 	return err
 }
 
@@ -232,5 +501,19 @@ func (db *PLM) EnterUnlinkingMode(group insteon.Group) error {
 	return err
 }
 
+// SendAllLinkCommand will send the command to all of the responders that
+// are linked to the given group
+func (db *PLM) SendAllLinkCommand(group insteon.Group, cmd insteon.Command) error {
+	ack, err := db.Retry(&Packet{
+		Command: CmdSendAllLink,
+		Payload: []byte{byte(group), cmd[1], cmd[2]},
+	}, 3)
+
+	if err == nil && ack.NAK() {
+		err = ErrNak
+	}
+	return err
+}
+
 func (db *PLM) AssignToAllLinkGroup(insteon.Group) error   { return ErrNotImplemented }
 func (db *PLM) DeleteFromAllLinkGroup(insteon.Group) error { return ErrNotImplemented }
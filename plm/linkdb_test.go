@@ -0,0 +1,365 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/sim"
+)
+
+// ctrl returns a controller record as the PLM stores it
+func ctrl(group insteon.Group, address insteon.Address, data ...byte) *insteon.LinkRecord {
+	link := &insteon.LinkRecord{Flags: 0xe2, Group: group, Address: address}
+	copy(link.Data[:], data)
+	return link
+}
+
+// resp returns a responder record as the PLM stores it
+func resp(group insteon.Group, address insteon.Address, data ...byte) *insteon.LinkRecord {
+	link := &insteon.LinkRecord{Flags: 0xa2, Group: group, Address: address}
+	copy(link.Data[:], data)
+	return link
+}
+
+// checkLinks compares the simulated modem's link database with the
+// expected records, in order
+func checkLinks(t *testing.T, desc string, modem *sim.PLM, expected []*insteon.LinkRecord) {
+	t.Helper()
+	links := modem.Links()
+	if len(links) != len(expected) {
+		t.Errorf("%s: expected %v got %v", desc, expected, links)
+		return
+	}
+
+	for i, link := range links {
+		if *link != *expected[i] {
+			t.Errorf("%s: expected links[%d] to be %v got %v", desc, i, expected[i], link)
+		}
+	}
+}
+
+func TestPLMWriteLink(t *testing.T) {
+	addr1, addr2 := insteon.Address{4, 5, 6}, insteon.Address{7, 8, 9}
+
+	tests := []struct {
+		desc     string
+		links    []*insteon.LinkRecord
+		write    func(*PLM) error
+		expected []*insteon.LinkRecord
+		err      error
+	}{
+		{
+			"responder data",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 2)},
+			func(plm *PLM) error { return plm.WriteLink(resp(1, addr1, 3)) },
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 3)},
+			nil,
+		},
+		{
+			"controller data",
+			[]*insteon.LinkRecord{resp(1, addr1, 2), ctrl(1, addr1, 1)},
+			func(plm *PLM) error { return plm.WriteLink(ctrl(1, addr1, 3)) },
+			[]*insteon.LinkRecord{resp(1, addr1, 2), ctrl(1, addr1, 3)},
+			nil,
+		},
+		{
+			"add",
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			func(plm *PLM) error { return plm.WriteLink(resp(1, addr1, 3)) },
+			[]*insteon.LinkRecord{ctrl(1, addr1), resp(1, addr1, 3)},
+			nil,
+		},
+		{
+			"delete",
+			[]*insteon.LinkRecord{ctrl(1, addr1), ctrl(2, addr1)},
+			func(plm *PLM) error { return plm.WriteLink(&insteon.LinkRecord{Flags: 0x62, Group: 1, Address: addr1}) },
+			[]*insteon.LinkRecord{ctrl(2, addr1)},
+			nil,
+		},
+		{
+			"change type in place",
+			[]*insteon.LinkRecord{ctrl(1, addr1), ctrl(2, addr1)},
+			func(plm *PLM) error { return plm.UpdateLink(ctrl(1, addr1), resp(1, addr1, 5)) },
+			[]*insteon.LinkRecord{resp(1, addr1, 5), ctrl(2, addr1)},
+			nil,
+		},
+		{
+			"change group",
+			[]*insteon.LinkRecord{ctrl(1, addr1), ctrl(2, addr2)},
+			func(plm *PLM) error { return plm.UpdateLink(ctrl(1, addr1), ctrl(3, addr1, 7)) },
+			[]*insteon.LinkRecord{ctrl(2, addr2), ctrl(3, addr1, 7)},
+			nil,
+		},
+		{
+			"edit first copy",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), ctrl(1, addr1, 2)},
+			func(plm *PLM) error { return plm.UpdateLink(ctrl(1, addr1, 1), ctrl(1, addr1, 7)) },
+			[]*insteon.LinkRecord{ctrl(1, addr1, 7), ctrl(1, addr1, 2)},
+			nil,
+		},
+		{
+			"edit later copy",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), ctrl(1, addr1, 2)},
+			func(plm *PLM) error { return plm.UpdateLink(ctrl(1, addr1, 2), ctrl(1, addr1, 7)) },
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), ctrl(1, addr1, 2)},
+			ErrLinkConflict,
+		},
+		{
+			"delete later copy",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9), ctrl(1, addr1, 2)},
+			func(plm *PLM) error {
+				return plm.UpdateLink(ctrl(1, addr1, 2), &insteon.LinkRecord{Flags: 0x62, Group: 1, Address: addr1, Data: [3]byte{2}})
+			},
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9)},
+			nil,
+		},
+		{
+			"change type over existing record",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 2)},
+			func(plm *PLM) error { return plm.UpdateLink(ctrl(1, addr1, 1), resp(1, addr1, 3)) },
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 2)},
+			ErrLinkConflict,
+		},
+		{
+			"change group over existing record",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), ctrl(3, addr1, 2)},
+			func(plm *PLM) error { return plm.UpdateLink(ctrl(1, addr1, 1), ctrl(3, addr1, 7)) },
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), ctrl(3, addr1, 2)},
+			ErrLinkConflict,
+		},
+		{
+			"missing",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1)},
+			func(plm *PLM) error { return plm.UpdateLink(ctrl(1, addr1, 2), ctrl(1, addr1, 7)) },
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1)},
+			ErrLinkNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		modem := sim.NewPLM(insteon.Address{1, 2, 3})
+		for _, link := range test.links {
+			modem.AddLink(link)
+		}
+		plm := New(NewPort(modem, time.Second), time.Second)
+
+		if err := test.write(plm); err != test.err {
+			t.Errorf("%s: expected error %v got %v", test.desc, test.err, err)
+		}
+
+		checkLinks(t, test.desc, modem, test.expected)
+		plm.Close()
+	}
+}
+
+func TestPLMRemoveLinks(t *testing.T) {
+	addr1, addr2 := insteon.Address{4, 5, 6}, insteon.Address{7, 8, 9}
+
+	tests := []struct {
+		desc     string
+		links    []*insteon.LinkRecord
+		remove   []*insteon.LinkRecord
+		skip     int
+		expected []*insteon.LinkRecord
+		err      error
+	}{
+		{
+			"only record",
+			[]*insteon.LinkRecord{ctrl(1, addr1), ctrl(2, addr1)},
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			-1,
+			[]*insteon.LinkRecord{ctrl(2, addr1)},
+			nil,
+		},
+		{
+			"controller first",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1, 2, 3), resp(1, addr1, 4, 5, 6)},
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			-1,
+			[]*insteon.LinkRecord{resp(1, addr1, 4, 5, 6)},
+			nil,
+		},
+		{
+			"responder first",
+			[]*insteon.LinkRecord{resp(1, addr1, 4, 5, 6), ctrl(1, addr1, 1, 2, 3), ctrl(2, addr1)},
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			-1,
+			[]*insteon.LinkRecord{ctrl(2, addr1), resp(1, addr1, 4, 5, 6)},
+			nil,
+		},
+		{
+			"duplicates",
+			[]*insteon.LinkRecord{ctrl(1, addr1), resp(1, addr1, 3), ctrl(1, addr1)},
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			-1,
+			[]*insteon.LinkRecord{resp(1, addr1, 3)},
+			nil,
+		},
+		{
+			"other-type duplicates",
+			[]*insteon.LinkRecord{resp(1, addr1, 1), ctrl(1, addr1, 5), resp(1, addr1, 2)},
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			-1,
+			[]*insteon.LinkRecord{resp(1, addr1, 1)},
+			nil,
+		},
+		{
+			"other-type duplicates after",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 5), resp(1, addr1, 1), resp(1, addr1, 2)},
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			-1,
+			[]*insteon.LinkRecord{resp(1, addr1, 1), resp(1, addr1, 2)},
+			nil,
+		},
+		{
+			"several",
+			[]*insteon.LinkRecord{resp(2, addr2, 1), ctrl(1, addr1), ctrl(2, addr2, 2)},
+			[]*insteon.LinkRecord{ctrl(1, addr1), resp(2, addr2)},
+			-1,
+			[]*insteon.LinkRecord{ctrl(2, addr2, 2)},
+			nil,
+		},
+		{
+			"missing",
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			[]*insteon.LinkRecord{resp(1, addr1)},
+			-1,
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			nil,
+		},
+		{
+			"rollback",
+			[]*insteon.LinkRecord{resp(1, addr1, 4, 5, 6), ctrl(1, addr1, 1, 2, 3)},
+			[]*insteon.LinkRecord{ctrl(1, addr1)},
+			1,
+			[]*insteon.LinkRecord{resp(1, addr1, 4, 5, 6), ctrl(1, addr1, 1, 2, 3)},
+			ErrNak,
+		},
+		{
+			"rollback several",
+			[]*insteon.LinkRecord{resp(1, addr1, 4), ctrl(1, addr1, 1), ctrl(2, addr2, 2)},
+			[]*insteon.LinkRecord{ctrl(1, addr1), ctrl(2, addr2)},
+			3,
+			[]*insteon.LinkRecord{ctrl(2, addr2, 2), resp(1, addr1, 4), ctrl(1, addr1, 1)},
+			ErrNak,
+		},
+	}
+
+	for _, test := range tests {
+		modem := sim.NewPLM(insteon.Address{1, 2, 3})
+		for _, link := range test.links {
+			modem.AddLink(link)
+		}
+
+		if test.skip >= 0 {
+			modem.NakRecord(test.skip)
+		}
+		plm := New(NewPort(modem, time.Second), time.Second)
+
+		if err := plm.RemoveLinks(test.remove...); err != test.err {
+			t.Errorf("%s: expected error %v got %v", test.desc, test.err, err)
+		}
+
+		checkLinks(t, test.desc, modem, test.expected)
+		plm.Close()
+	}
+}
+
+func TestPLMRemoveDuplicates(t *testing.T) {
+	addr1 := insteon.Address{4, 5, 6}
+
+	tests := []struct {
+		desc     string
+		links    []*insteon.LinkRecord
+		skip     int
+		expected []*insteon.LinkRecord
+		err      error
+	}{
+		{
+			"no duplicates",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9)},
+			-1,
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9)},
+			nil,
+		},
+		{
+			"controller first",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9), ctrl(1, addr1, 2)},
+			-1,
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9)},
+			nil,
+		},
+		{
+			"responder first",
+			[]*insteon.LinkRecord{resp(1, addr1, 9), ctrl(1, addr1, 1), ctrl(1, addr1, 2)},
+			-1,
+			[]*insteon.LinkRecord{resp(1, addr1, 9), ctrl(1, addr1, 1)},
+			nil,
+		},
+		{
+			"several",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), ctrl(1, addr1, 2), ctrl(1, addr1, 3)},
+			-1,
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1)},
+			nil,
+		},
+		{
+			// a second copy of a record can't be added back, so the
+			// rollback restores everything but the duplicate
+			"rollback",
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9), ctrl(1, addr1, 2)},
+			1,
+			[]*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9)},
+			ErrNak,
+		},
+	}
+
+	for _, test := range tests {
+		modem := sim.NewPLM(insteon.Address{1, 2, 3})
+		for _, link := range test.links {
+			modem.AddLink(link)
+		}
+
+		if test.skip >= 0 {
+			modem.NakRecord(test.skip)
+		}
+		plm := New(NewPort(modem, time.Second), time.Second)
+
+		if err := plm.RemoveDuplicates(ctrl(1, addr1)); err != test.err {
+			t.Errorf("%s: expected error %v got %v", test.desc, test.err, err)
+		}
+
+		checkLinks(t, test.desc, modem, test.expected)
+		plm.Close()
+	}
+}
+
+func TestPLMCleanup(t *testing.T) {
+	addr1 := insteon.Address{4, 5, 6}
+	modem := sim.NewPLM(insteon.Address{1, 2, 3})
+	for _, link := range []*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9), ctrl(1, addr1, 2), resp(1, insteon.Address{})} {
+		modem.AddLink(link)
+	}
+	plm := New(NewPort(modem, time.Second), time.Second)
+	defer plm.Close()
+
+	if err := plm.Cleanup(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkLinks(t, "cleanup", modem, []*insteon.LinkRecord{ctrl(1, addr1, 1), resp(1, addr1, 9)})
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"sync/atomic"
+)
+
+// Observer is notified of serial port activity and packet delivery
+// problems between the host and the PLM.  Observer methods are called
+// synchronously and must not block
+type Observer interface {
+	// PortWrite is called after a packet is written to the port
+	PortWrite(n int, err error)
+
+	// PortRead is called after a packet is read from the port
+	PortRead(n int, err error)
+
+	// FramingError is called when bytes are discarded while looking
+	// for the start of a packet
+	FramingError(discarded int)
+
+	// Nak is called when the PLM responds to a packet with a NAK
+	Nak()
+
+	// Retry is called every time Retry resends a packet
+	Retry()
+
+	// RetryExceeded is called when Retry gives up on a packet
+	RetryExceeded()
+
+	// AckTimeout is called when the PLM does not respond to a packet
+	AckTimeout()
+}
+
+type nopObserver struct{}
+
+func (nopObserver) PortWrite(int, error) {}
+func (nopObserver) PortRead(int, error)  {}
+func (nopObserver) FramingError(int)     {}
+func (nopObserver) Nak()                 {}
+func (nopObserver) Retry()               {}
+func (nopObserver) RetryExceeded()       {}
+func (nopObserver) AckTimeout()          {}
+
+type observerHolder struct{ Observer }
+
+var observer atomic.Value
+
+func init() {
+	observer.Store(observerHolder{nopObserver{}})
+}
+
+// SetObserver installs the observer that is notified of port and
+// packet activity for all PLMs.  Passing nil removes the current observer
+func SetObserver(o Observer) {
+	if o == nil {
+		o = nopObserver{}
+	}
+	observer.Store(observerHolder{o})
+}
+
+func observe() Observer {
+	return observer.Load().(observerHolder).Observer
+}
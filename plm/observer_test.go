@@ -0,0 +1,70 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+type testObserver struct {
+	nopObserver
+	discarded int
+	naks      int
+}
+
+func (to *testObserver) FramingError(discarded int) { to.discarded += discarded }
+func (to *testObserver) Nak()                       { to.naks++ }
+
+func TestPortFramingError(t *testing.T) {
+	observer := &testObserver{}
+	SetObserver(observer)
+	defer SetObserver(nil)
+
+	tests := []struct {
+		input             []byte
+		expectedDiscarded int
+	}{
+		{[]byte{0x02, 0x60, 1, 2, 3, 4, 5, 6, 0x06}, 0},
+		{[]byte{0xff, 0xfe, 0x02, 0x60, 1, 2, 3, 4, 5, 6, 0x06}, 2},
+		{[]byte{0x02, 0xff, 0x02, 0x60, 1, 2, 3, 4, 5, 6, 0x06}, 2},
+	}
+
+	for i, test := range tests {
+		observer.discarded = 0
+		port := &Port{in: bufio.NewReader(bytes.NewReader(test.input)), timeout: time.Second}
+		_, err := port.readPacket()
+		if err != nil {
+			t.Errorf("tests[%d] unexpected error: %v", i, err)
+		} else if observer.discarded != test.expectedDiscarded {
+			t.Errorf("tests[%d] expected %d discarded bytes got %d", i, test.expectedDiscarded, observer.discarded)
+		}
+	}
+}
+
+func TestPLMNakObserver(t *testing.T) {
+	observer := &testObserver{}
+	SetObserver(observer)
+	defer SetObserver(nil)
+
+	doneCh := make(chan *PacketRequest, 1)
+	plm := &PLM{queue: []*PacketRequest{{Packet: &Packet{Command: CmdGetInfo}, DoneCh: doneCh}}}
+	plm.receiveAck(&Packet{Command: CmdGetInfo, Ack: 0x15})
+	if observer.naks != 1 {
+		t.Errorf("expected 1 NAK got %d", observer.naks)
+	}
+}
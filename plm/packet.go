@@ -16,6 +16,8 @@ package plm
 
 import (
 	"fmt"
+
+	"github.com/abates/insteon"
 )
 
 type Packet struct {
@@ -84,11 +86,17 @@ func (p *Packet) MarshalBinary() (buf []byte, err error) {
 }
 
 func (p *Packet) UnmarshalBinary(buf []byte) (err error) {
-	if buf[0] != 0x02 {
+	if len(buf) > 0 && buf[0] != 0x02 {
 		return ErrNoSync
+	} else if len(buf) < 2 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 2, Got: len(buf)}
 	}
 
 	p.Command = Command(buf[1])
+	// IM commands are followed by the ACK/NAK byte
+	if 0x60 <= p.Command && p.Command <= 0x7f && len(buf) < 3 {
+		return &insteon.BufError{Cause: insteon.ErrBufferTooShort, Need: 3, Got: len(buf)}
+	}
 	buf = buf[2:]
 
 	// responses to locally generated insteon messages need
@@ -19,8 +19,18 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/abates/insteon"
 )
 
+// cause returns the underlying error of a BufError
+func cause(err error) error {
+	if bufErr, ok := err.(*insteon.BufError); ok {
+		return bufErr.Cause
+	}
+	return err
+}
+
 func TestPacketAckNak(t *testing.T) {
 	tests := []struct {
 		cmd   Command
@@ -53,13 +63,16 @@ func TestPacketMarshalUnmarshalBinary(t *testing.T) {
 		expectedErr error
 	}{
 		{[]byte{0x00}, &Packet{}, ErrNoSync},
+		{[]byte{}, &Packet{}, insteon.ErrBufferTooShort},
+		{[]byte{0x02}, &Packet{}, insteon.ErrBufferTooShort},
+		{[]byte{0x02, byte(CmdGetInfo)}, &Packet{}, insteon.ErrBufferTooShort},
 		{[]byte{0x02, byte(CmdSendInsteonMsg), 0x01, 0x02, 0x03, 0x04, 0x06}, &Packet{Ack: 0x06, Command: CmdSendInsteonMsg, Payload: []byte{0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04}}, nil},
 	}
 
 	for i, test := range tests {
 		packet := &Packet{}
 		err := packet.UnmarshalBinary(test.input)
-		if err == test.expectedErr {
+		if cause(err) == test.expectedErr {
 			if err == nil {
 				if !reflect.DeepEqual(packet, test.expected) {
 					t.Errorf("tests[%d] expected %v got %v", i, test.expected, packet)
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/abates/insteon"
@@ -30,6 +31,10 @@ var (
 	ErrAckTimeout         = errors.New("Timeout waiting for Ack from the PLM")
 	ErrRetryCountExceeded = errors.New("Retry count exceeded sending command")
 	ErrNak                = errors.New("PLM responded with a NAK.  Resend command")
+	ErrClosed             = errors.New("PLM has been closed")
+	ErrLinkVerify         = errors.New("PLM link database does not match the expected records")
+	ErrLinkNotFound       = errors.New("PLM link record not found")
+	ErrLinkConflict       = errors.New("PLM link change would overwrite or duplicate another record")
 
 	MaxRetries = 3
 )
@@ -43,19 +48,21 @@ func hexDump(format string, buf []byte, sep string) string {
 }
 
 type CommandRequest struct {
-	Command Command
-	Payload []byte
-	Err     error
-	DoneCh  chan<- *CommandRequest
+	Command  Command
+	Payload  []byte
+	Priority insteon.Priority
+	Err      error
+	DoneCh   chan<- *CommandRequest
 }
 
 type PacketRequest struct {
-	Packet  *Packet
-	Retry   int
-	Ack     *Packet
-	Err     error
-	DoneCh  chan<- *PacketRequest
-	timeout time.Time
+	Packet   *Packet
+	Retry    int
+	Priority insteon.Priority
+	Ack      *Packet
+	Err      error
+	DoneCh   chan<- *PacketRequest
+	timeout  time.Time
 }
 
 type PLM struct {
@@ -63,11 +70,18 @@ type PLM struct {
 	connections []chan<- *Packet
 	queue       []*PacketRequest
 
-	sendCh         chan *PacketRequest
-	upstreamSendCh chan<- []byte
-	upstreamRecvCh <-chan []byte
-	connectCh      chan chan<- *Packet
-	disconnectCh   chan chan<- *Packet
+	// sequence is incremented for every packet sent and served records
+	// the sequence number of the last packet sent to each destination
+	sequence uint64
+	served   map[insteon.Address]uint64
+
+	sendCh          chan *PacketRequest
+	closeCh         chan struct{}
+	upstreamSendCh  chan<- []byte
+	upstreamRecvCh  <-chan []byte
+	upstreamResetCh <-chan error
+	connectCh       chan chan<- *Packet
+	disconnectCh    chan chan<- *Packet
 
 	Network *insteon.Network
 }
@@ -76,11 +90,13 @@ func New(port *Port, timeout time.Duration) *PLM {
 	plm := &PLM{
 		timeout: timeout,
 
-		sendCh:         make(chan *PacketRequest, 1),
-		upstreamSendCh: port.sendCh,
-		upstreamRecvCh: port.recvCh,
-		connectCh:      make(chan chan<- *Packet),
-		disconnectCh:   make(chan chan<- *Packet),
+		sendCh:          make(chan *PacketRequest, 1),
+		closeCh:         make(chan struct{}),
+		upstreamSendCh:  port.sendCh,
+		upstreamRecvCh:  port.recvCh,
+		upstreamResetCh: port.resetCh,
+		connectCh:       make(chan chan<- *Packet),
+		disconnectCh:    make(chan chan<- *Packet),
 	}
 
 	go plm.process()
@@ -99,11 +115,12 @@ func (plm *PLM) process() {
 				return
 			}
 			plm.receive(buf)
-		case request, open := <-plm.sendCh:
-			if !open {
-				plm.close()
-				return
-			}
+		case err := <-plm.upstreamResetCh:
+			plm.reset(err)
+		case <-plm.closeCh:
+			plm.close()
+			return
+		case request := <-plm.sendCh:
 			plm.queue = append(plm.queue, request)
 			if len(plm.queue) == 1 {
 				plm.send()
@@ -116,18 +133,58 @@ func (plm *PLM) process() {
 			if len(plm.queue) > 0 && plm.queue[0].timeout.Before(time.Now()) {
 				request := plm.queue[0]
 				request.Err = ErrReadTimeout
+				observe().AckTimeout()
 				request.DoneCh <- request
 				plm.queue = plm.queue[1:]
+				plm.send()
 			}
 		}
 	}
 }
 
+// destination returns the Insteon address that a packet is sent to.  Modem
+// commands are all considered to be sent to the zero address
+func destination(packet *Packet) (dst insteon.Address) {
+	if packet.Command == CmdSendInsteonMsg && len(packet.Payload) >= 3 {
+		copy(dst[:], packet.Payload[0:3])
+	}
+	return dst
+}
+
+// schedule moves the next request to be sent to the front of the queue.
+// The request with the highest priority is chosen.  Requests with the
+// same priority are chosen by the destination that was served the
+// longest time ago so that one device can not monopolize the modem, and
+// finally by the order that they were queued
+func (plm *PLM) schedule() {
+	best := 0
+	for i := 1; i < len(plm.queue); i++ {
+		candidate, current := plm.queue[i], plm.queue[best]
+		if candidate.Priority.Level() > current.Priority.Level() {
+			best = i
+		} else if candidate.Priority.Level() == current.Priority.Level() && plm.served[destination(candidate.Packet)] < plm.served[destination(current.Packet)] {
+			best = i
+		}
+	}
+
+	if best > 0 {
+		request := plm.queue[best]
+		copy(plm.queue[1:best+1], plm.queue[0:best])
+		plm.queue[0] = request
+	}
+}
+
 func (plm *PLM) send() {
 	if len(plm.queue) > 0 {
+		plm.schedule()
 		request := plm.queue[0]
 		if buf, err := request.Packet.MarshalBinary(); err == nil {
 			insteon.Log.Debugf("Sending packet to port")
+			if plm.served == nil {
+				plm.served = make(map[insteon.Address]uint64)
+			}
+			plm.sequence++
+			plm.served[destination(request.Packet)] = plm.sequence
 			request.timeout = time.Now().Add(plm.timeout)
 			plm.upstreamSendCh <- buf
 		} else {
@@ -140,6 +197,16 @@ func (plm *PLM) send() {
 	}
 }
 
+// reset fails every queued request with err.  This happens when the
+// connection to the PLM is lost and none of the requests can be answered
+func (plm *PLM) reset(err error) {
+	for _, request := range plm.queue {
+		request.Err = err
+		request.DoneCh <- request
+	}
+	plm.queue = nil
+}
+
 func (plm *PLM) disconnect(connection chan<- *Packet) {
 	for i, conn := range plm.connections {
 		if conn == connection {
@@ -175,6 +242,7 @@ func (plm *PLM) receiveAck(packet *Packet) {
 			request.Ack = packet
 			if packet.NAK() {
 				request.Err = ErrNak
+				observe().Nak()
 			}
 			request.DoneCh <- plm.queue[0]
 			plm.queue = plm.queue[1:]
@@ -188,31 +256,61 @@ func (plm *PLM) receiveAck(packet *Packet) {
 // continues until the packet is sent (as acknowledged by the PLM) or retries
 // reaches zero
 func (plm *PLM) Retry(packet *Packet, retries int) (ack *Packet, err error) {
+	return plm.RetryPriority(packet, retries, insteon.PriorityDefault)
+}
+
+// RetryPriority is the same as Retry, except that the packet is queued
+// with the given priority
+func (plm *PLM) RetryPriority(packet *Packet, retries int, priority insteon.Priority) (ack *Packet, err error) {
 	doneCh := make(chan *PacketRequest, 1)
 	request := &PacketRequest{
-		Packet: packet,
-		Retry:  retries,
-		DoneCh: doneCh,
+		Packet:   packet,
+		Retry:    retries,
+		Priority: priority,
+		DoneCh:   doneCh,
+	}
+
+	if err := plm.submit(request, doneCh); err != nil {
+		return nil, err
 	}
 
-	plm.sendCh <- request
-	<-doneCh
 	if request.Err == ErrNak && retries > 0 {
 		for request.Err == ErrNak && retries > 0 {
 			insteon.Log.Debugf("Received NAK sending %q. Retrying", packet)
+			observe().Retry()
 			retries--
-			plm.sendCh <- request
-			<-doneCh
+			if err := plm.submit(request, doneCh); err != nil {
+				return nil, err
+			}
 		}
 
 		if request.Err == ErrNak {
 			insteon.Log.Debugf("Retry count exceeded")
+			observe().RetryExceeded()
 			request.Err = ErrRetryCountExceeded
 		}
 	}
 	return request.Ack, request.Err
 }
 
+// submit queues the request and waits for it to complete.  ErrClosed is
+// returned if the PLM is closed before the request completes, since
+// requests may still be sent by other goroutines during shutdown
+func (plm *PLM) submit(request *PacketRequest, doneCh <-chan *PacketRequest) error {
+	select {
+	case plm.sendCh <- request:
+	case <-plm.closeCh:
+		return ErrClosed
+	}
+
+	select {
+	case <-doneCh:
+	case <-plm.closeCh:
+		return ErrClosed
+	}
+	return nil
+}
+
 func (plm *PLM) Connect(sendCmd Command, recvCmds ...Command) (chan<- *insteon.PacketRequest, <-chan []byte) {
 	conn := plm.connect(sendCmd, recvCmds...)
 	return conn.sendCh, conn.recvCh
@@ -224,9 +322,34 @@ func (plm *PLM) connect(sendCmd Command, recvCmds ...Command) *connection {
 	plm.connectCh <- recvCh
 
 	go func() {
+		// requests for the same destination are sent one at a time in
+		// the order they were made, while requests for different
+		// destinations are queued concurrently so that the scheduler
+		// can choose between them
+		var mutex sync.Mutex
+		pending := make(map[insteon.Address][]*CommandRequest)
 		for request := range sendCh {
-			_, request.Err = plm.Retry(&Packet{Command: request.Command, Payload: request.Payload}, 0)
-			request.DoneCh <- request
+			dst := destination(&Packet{Command: request.Command, Payload: request.Payload})
+			mutex.Lock()
+			pending[dst] = append(pending[dst], request)
+			start := len(pending[dst]) == 1
+			mutex.Unlock()
+
+			if start {
+				go func(dst insteon.Address) {
+					mutex.Lock()
+					for len(pending[dst]) > 0 {
+						request := pending[dst][0]
+						mutex.Unlock()
+						_, request.Err = plm.RetryPriority(&Packet{Command: request.Command, Payload: request.Payload}, 0, request.Priority)
+						request.DoneCh <- request
+						mutex.Lock()
+						pending[dst] = pending[dst][1:]
+					}
+					delete(pending, dst)
+					mutex.Unlock()
+				}(dst)
+			}
 		}
 		plm.disconnectCh <- recvCh
 	}()
@@ -309,6 +432,6 @@ func (plm *PLM) close() {
 }
 
 func (plm *PLM) Close() error {
-	close(plm.sendCh)
+	close(plm.closeCh)
 	return nil
 }
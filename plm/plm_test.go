@@ -0,0 +1,136 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+func TestPLMSchedule(t *testing.T) {
+	packet := func(dst byte) *Packet {
+		return &Packet{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, dst, 0x0f, 0x11, 0xff}}
+	}
+
+	tests := []struct {
+		desc     string
+		served   map[insteon.Address]uint64
+		queue    []*PacketRequest
+		expected []int // order that the queued requests are sent
+	}{
+		{
+			desc:     "fifo",
+			queue:    []*PacketRequest{{Packet: packet(1)}, {Packet: packet(1)}, {Packet: packet(1)}},
+			expected: []int{0, 1, 2},
+		},
+		{
+			desc: "priority",
+			queue: []*PacketRequest{
+				{Packet: packet(1), Priority: insteon.PriorityBackground},
+				{Packet: packet(2)},
+				{Packet: packet(3), Priority: insteon.PriorityInteractive},
+			},
+			expected: []int{2, 1, 0},
+		},
+		{
+			desc: "fairness",
+			queue: []*PacketRequest{
+				{Packet: packet(1)},
+				{Packet: packet(1)},
+				{Packet: packet(1)},
+				{Packet: packet(2)},
+			},
+			expected: []int{0, 3, 1, 2},
+		},
+		{
+			desc:     "least recently served",
+			served:   map[insteon.Address]uint64{{0, 0, 1}: 2, {0, 0, 2}: 1},
+			queue:    []*PacketRequest{{Packet: packet(1)}, {Packet: packet(2)}},
+			expected: []int{1, 0},
+		},
+	}
+
+	for _, test := range tests {
+		plm := &PLM{served: test.served, sequence: uint64(len(test.served))}
+		sendCh := make(chan []byte, len(test.queue))
+		plm.upstreamSendCh = sendCh
+		plm.queue = append(plm.queue, test.queue...)
+
+		for i, expected := range test.expected {
+			plm.send()
+			<-sendCh
+			if plm.queue[0] != test.queue[expected] {
+				for j, request := range test.queue {
+					if request == plm.queue[0] {
+						t.Errorf("%s: send %d expected request %d got %d", test.desc, i, expected, j)
+					}
+				}
+			}
+			plm.queue = plm.queue[1:]
+		}
+	}
+}
+
+func TestPLMConnectOrder(t *testing.T) {
+	plm := &PLM{
+		sendCh:    make(chan *PacketRequest, 3),
+		closeCh:   make(chan struct{}),
+		connectCh: make(chan chan<- *Packet, 1),
+	}
+	conn := plm.connect(CmdSendInsteonMsg)
+
+	// two requests for the first device and one for the second are made
+	// without waiting for any of them to complete
+	doneCh := make(chan *CommandRequest, 3)
+	for _, dst := range []byte{1, 1, 2} {
+		conn.upstreamSendCh <- &CommandRequest{Command: CmdSendInsteonMsg, Payload: []byte{0, 0, dst, 0x0f, 0x11, 0xff}, DoneCh: doneCh}
+	}
+
+	receive := func() *PacketRequest {
+		select {
+		case request := <-plm.sendCh:
+			return request
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for request")
+		}
+		return nil
+	}
+
+	// the scheduler can choose between the devices, but the second
+	// request for the first device must wait for the first one
+	queued := map[insteon.Address]*PacketRequest{}
+	for i := 0; i < 2; i++ {
+		request := receive()
+		queued[destination(request.Packet)] = request
+	}
+
+	if len(queued) != 2 {
+		t.Fatalf("expected one request for each device got %v", queued)
+	}
+
+	select {
+	case request := <-plm.sendCh:
+		t.Fatalf("expected the second request for the device to wait got %v", request.Packet)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	first := queued[insteon.Address{0, 0, 1}]
+	first.DoneCh <- first
+	if request := receive(); destination(request.Packet) != (insteon.Address{0, 0, 1}) {
+		t.Errorf("expected the second request for 00.00.01 got %v", request.Packet)
+	}
+}
@@ -17,59 +17,194 @@ package plm
 import (
 	"bufio"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/abates/insteon"
 )
 
-const (
-	writeDelay = 500 * time.Millisecond
-)
+// Pacing controls how quickly frames are written to the PLM.  After every
+// write the port waits for the PLM to echo the command back (with an ACK
+// or NAK) and then waits for the gap that matches the kind of frame that
+// was written before writing the next frame
+type Pacing struct {
+	// EchoTimeout is the longest time to wait for the PLM to echo a frame
+	EchoTimeout time.Duration
+
+	// MinGap is the gap after modem commands that are not sent to the
+	// Insteon network
+	MinGap time.Duration
+
+	// StandardGap is the gap after a standard length Insteon message
+	StandardGap time.Duration
+
+	// ExtendedGap is the gap after an extended length Insteon message
+	ExtendedGap time.Duration
+
+	// BroadcastGap is the gap after an all-link or broadcast message,
+	// which is followed by cleanup traffic on the network
+	BroadcastGap time.Duration
+}
+
+// DefaultPacing is used by NewPort
+var DefaultPacing = Pacing{
+	EchoTimeout:  500 * time.Millisecond,
+	MinGap:       10 * time.Millisecond,
+	StandardGap:  50 * time.Millisecond,
+	ExtendedGap:  100 * time.Millisecond,
+	BroadcastGap: 250 * time.Millisecond,
+}
+
+// gap returns the time to wait after the frame in buf has been echoed
+func (pacing Pacing) gap(buf []byte) time.Duration {
+	if len(buf) < 2 {
+		return pacing.MinGap
+	}
+
+	switch Command(buf[1]) {
+	case CmdSendAllLink:
+		return pacing.BroadcastGap
+	case CmdSendInsteonMsg:
+		if len(buf) < 6 {
+			break
+		}
+		flags := insteon.Flags(buf[5])
+		if flags.Type() == insteon.MsgTypeBroadcast || flags.Type() == insteon.MsgTypeAllLinkBroadcast {
+			return pacing.BroadcastGap
+		} else if flags.Extended() {
+			return pacing.ExtendedGap
+		}
+		return pacing.StandardGap
+	}
+	return pacing.MinGap
+}
 
 type Port struct {
 	in      *bufio.Reader
 	out     io.Writer
 	timeout time.Duration
+	pacing  Pacing
+
+	captureMutex sync.Mutex
+	capture      *CaptureWriter
 
 	sendCh  chan []byte
 	readCh  chan []byte
 	recvCh  chan []byte
+	resetCh chan error
 	closeCh chan chan error
+	doneCh  chan struct{}
 }
 
+// NewPort returns a Port that communicates with a PLM over readWriter
+// using DefaultPacing
 func NewPort(readWriter io.ReadWriter, timeout time.Duration) *Port {
+	return NewPacedPort(readWriter, timeout, DefaultPacing)
+}
+
+// NewPacedPort returns a Port that writes frames according to pacing
+func NewPacedPort(readWriter io.ReadWriter, timeout time.Duration, pacing Pacing) *Port {
 	port := &Port{
 		in:      bufio.NewReader(readWriter),
 		out:     readWriter,
 		timeout: timeout,
+		pacing:  pacing,
 
 		sendCh:  make(chan []byte, 1),
 		readCh:  make(chan []byte, 1),
 		recvCh:  make(chan []byte, 1),
+		resetCh: make(chan error, 1),
 		closeCh: make(chan chan error),
+		doneCh:  make(chan struct{}),
 	}
 	go port.readLoop()
 	go port.process()
 	return port
 }
 
+const (
+	minReadBackoff = 10 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
 func (port *Port) readLoop() {
+	backoff := time.Duration(0)
 	for {
 		packet, err := port.readPacket()
+		observe().PortRead(len(packet), err)
 		if err == nil {
+			backoff = 0
+			port.record(DirectionRx, packet)
 			port.readCh <- packet
-		} else {
-			insteon.Log.Infof("Error reading packet: %v", err)
+			continue
+		}
+
+		select {
+		case <-port.doneCh:
+			// the port has been closed
+			return
+		default:
+		}
+		insteon.Log.Infof("Error reading packet: %v", err)
+		port.reset(err)
+		if err == insteon.ErrReadTimeout {
+			continue
+		}
+
+		// errors such as EOF or EIO from an unplugged serial device are
+		// returned immediately by every read, so wait a little longer
+		// after each one instead of spinning
+		if backoff < minReadBackoff {
+			backoff = minReadBackoff
+		} else if backoff *= 2; backoff > maxReadBackoff {
+			backoff = maxReadBackoff
+		}
+
+		select {
+		case <-port.doneCh:
+			return
+		case <-time.After(backoff):
 		}
 	}
 }
 
+// process delivers received packets and writes queued frames.  Once a
+// frame is written, no other frame is written until the PLM has echoed
+// it (or the echo timeout expires) and the pacing gap has elapsed
 func (port *Port) process() {
+	defer close(port.doneCh)
+
+	// sendCh is nil while waiting for an echo or a gap
+	sendCh := port.sendCh
+	var pending []byte
+	var timer *time.Timer
+	var timerCh <-chan time.Time
+
+	wait := func(d time.Duration) {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.NewTimer(d)
+		timerCh = timer.C
+	}
+
 	for {
 		select {
 		case packet := <-port.readCh:
+			if pending != nil && len(packet) > 1 && packet[1] == pending[1] {
+				// the PLM echoed the pending frame
+				wait(port.pacing.gap(pending))
+				pending = nil
+			}
 			port.recvCh <- packet
-		case buf, open := <-port.sendCh:
+		case <-timerCh:
+			if pending != nil {
+				insteon.Log.Debugf("Timeout waiting for PLM to echo %s", hexDump("%02x", pending, " "))
+				pending = nil
+			}
+			timerCh = nil
+			sendCh = port.sendCh
+		case buf, open := <-sendCh:
 			if !open {
 				if closer, ok := port.out.(io.Closer); ok {
 					err := closer.Close()
@@ -79,7 +214,14 @@ func (port *Port) process() {
 				}
 				return
 			}
-			port.send(buf)
+
+			sendCh = nil
+			if port.send(buf) && len(buf) > 1 {
+				pending = buf
+				wait(port.pacing.EchoTimeout)
+			} else {
+				wait(port.pacing.MinGap)
+			}
 		case closeCh := <-port.closeCh:
 			closeCh <- nil
 			return
@@ -87,24 +229,70 @@ func (port *Port) process() {
 	}
 }
 
-func (port *Port) send(buf []byte) {
-	_, err := port.out.Write(buf)
-	<-time.After(writeDelay)
+// send writes buf to the PLM and returns true if the write succeeded
+func (port *Port) send(buf []byte) bool {
+	n, err := port.out.Write(buf)
+	observe().PortWrite(n, err)
 	if err == nil {
+		port.record(DirectionTx, buf)
 		insteon.Log.Tracef("TX %s", hexDump("%02x", buf, " "))
-	} else {
-		insteon.Log.Infof("Failed to write: %v", err)
+		return true
+	}
+	insteon.Log.Infof("Failed to write: %v", err)
+	port.reset(err)
+	return false
+}
+
+// reset tells the PLM that the connection was lost so that pending
+// requests fail instead of waiting for a response that will never arrive.
+// Any error other than a read timeout is treated as a lost connection
+func (port *Port) reset(err error) {
+	if err == insteon.ErrReadTimeout {
+		return
+	}
+
+	select {
+	case port.resetCh <- ErrDisconnected:
+	default:
+	}
+}
+
+// SetCapture starts recording every frame read from and written to the
+// port.  Passing nil stops recording
+func (port *Port) SetCapture(capture *CaptureWriter) {
+	port.captureMutex.Lock()
+	port.capture = capture
+	port.captureMutex.Unlock()
+}
+
+func (port *Port) record(direction Direction, frame []byte) {
+	port.captureMutex.Lock()
+	capture := port.capture
+	port.captureMutex.Unlock()
+
+	if capture != nil {
+		err := capture.Write(direction, frame)
+		insteon.Log.Errorf(err, "Failed to write capture record: %v", err)
 	}
 }
 
 func (port *Port) readPacket() (buf []byte, err error) {
 	timeout := time.Now().Add(port.timeout)
+	discarded := 0
+	defer func() {
+		if discarded > 0 {
+			observe().FramingError(discarded)
+		}
+	}()
 
 	// synchronize
 	for err == nil {
 		var b byte
 		b, err = port.in.ReadByte()
 		if b != 0x02 {
+			if err == nil {
+				discarded++
+			}
 			continue
 		} else {
 			b, err = port.in.ReadByte()
@@ -114,6 +302,7 @@ func (port *Port) readPacket() (buf []byte, err error) {
 				_, err = io.ReadAtLeast(port.in, buf[2:], packetLen)
 				break
 			} else {
+				discarded++
 				err = port.in.UnreadByte()
 			}
 		}
@@ -137,6 +326,11 @@ func (port *Port) readPacket() (buf []byte, err error) {
 func (port *Port) Close() error {
 	close(port.sendCh)
 	closeCh := make(chan error)
-	port.closeCh <- closeCh
-	return <-closeCh
+	select {
+	case port.closeCh <- closeCh:
+		return <-closeCh
+	case <-port.doneCh:
+		// process saw the closed send channel first
+		return nil
+	}
 }
@@ -0,0 +1,163 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/sim"
+)
+
+func TestPacingGap(t *testing.T) {
+	pacing := Pacing{MinGap: 1, StandardGap: 2, ExtendedGap: 3, BroadcastGap: 4}
+	tests := []struct {
+		desc     string
+		input    []byte
+		expected time.Duration
+	}{
+		{"get info", []byte{0x02, 0x60}, 1},
+		{"short", []byte{0x02}, 1},
+		{"standard", []byte{0x02, 0x62, 1, 2, 3, 0x0f, 0x11, 0xff}, 2},
+		{"extended", []byte{0x02, 0x62, 1, 2, 3, 0x1f, 0x2e, 0x00}, 3},
+		{"broadcast", []byte{0x02, 0x62, 0, 0, 1, 0xcf, 0x11, 0xff}, 4},
+		{"all-link", []byte{0x02, 0x61, 0x01, 0x11, 0x00}, 4},
+	}
+
+	for _, test := range tests {
+		if got := pacing.gap(test.input); got != test.expected {
+			t.Errorf("%s: expected %v got %v", test.desc, test.expected, got)
+		}
+	}
+}
+
+func TestPortPacing(t *testing.T) {
+	fp := newFailingPort()
+	port := NewPacedPort(fp, time.Second, Pacing{EchoTimeout: time.Second, MinGap: time.Millisecond})
+	defer port.Close()
+
+	port.sendCh <- []byte{0x02, 0x60}
+	port.sendCh <- []byte{0x02, 0x73}
+
+	// the second frame must wait for the first to be echoed
+	time.Sleep(20 * time.Millisecond)
+	if got := fp.Written(); !bytes.Equal(got, []byte{0x02, 0x60}) {
+		t.Fatalf("expected 0260 got %x", got)
+	}
+
+	fp.Input(0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06)
+	<-port.recvCh
+	for i := 0; i < 100 && len(fp.Written()) < 4; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := fp.Written(); !bytes.Equal(got, []byte{0x02, 0x60, 0x02, 0x73}) {
+		t.Errorf("expected 02600273 got %x", got)
+	}
+}
+
+func TestPortEchoTimeout(t *testing.T) {
+	fp := newFailingPort()
+	port := NewPacedPort(fp, time.Second, Pacing{EchoTimeout: 10 * time.Millisecond})
+	defer port.Close()
+
+	port.sendCh <- []byte{0x02, 0x60}
+	port.sendCh <- []byte{0x02, 0x73}
+	for i := 0; i < 100 && len(fp.Written()) < 4; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := fp.Written(); !bytes.Equal(got, []byte{0x02, 0x60, 0x02, 0x73}) {
+		t.Errorf("expected 02600273 got %x", got)
+	}
+}
+
+// countingReader counts the reads made on the underlying port
+type countingReader struct {
+	*failingPort
+	mutex sync.Mutex
+	reads int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	cr.mutex.Lock()
+	cr.reads++
+	cr.mutex.Unlock()
+	return cr.failingPort.Read(p)
+}
+
+func (cr *countingReader) Reads() int {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return cr.reads
+}
+
+func TestPortReadError(t *testing.T) {
+	tests := []struct {
+		desc string
+		err  error
+	}{
+		{"EOF", io.EOF},
+		{"unplugged", errTestUnplugged},
+	}
+
+	for _, test := range tests {
+		cr := &countingReader{failingPort: newFailingPort()}
+		port := NewPort(cr, time.Second)
+		cr.Fail(test.err)
+
+		select {
+		case err := <-port.resetCh:
+			if err != ErrDisconnected {
+				t.Errorf("%s: expected %v got %v", test.desc, ErrDisconnected, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: timed out waiting for reset", test.desc)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if reads := cr.Reads(); reads > 10 {
+			t.Errorf("%s: expected the read loop to back off, got %d reads", test.desc, reads)
+		}
+		port.Close()
+	}
+}
+
+func benchmarkPing(b *testing.B, pacing Pacing) {
+	modem := sim.NewPLM(insteon.Address{1, 2, 3})
+	device := sim.NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{4, 5, 6}, DevCat: insteon.DevCat{0x01, 0x20}, EngineVersion: insteon.VerI2})
+	modem.AddDevice(device)
+
+	plm := New(NewPacedPort(modem, time.Second, pacing), time.Second)
+	defer plm.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := plm.Network.EngineVersion(device.Address()); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkPortDefaultPacing(b *testing.B) { benchmarkPing(b, DefaultPacing) }
+
+func BenchmarkPortFixedDelay(b *testing.B) {
+	// approximates the fixed 500ms delay that was used before pacing
+	benchmarkPing(b, Pacing{EchoTimeout: 500 * time.Millisecond, MinGap: 500 * time.Millisecond, StandardGap: 500 * time.Millisecond, ExtendedGap: 500 * time.Millisecond, BroadcastGap: 500 * time.Millisecond})
+}
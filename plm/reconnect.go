@@ -0,0 +1,267 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// ErrDisconnected is returned when the connection to the PLM is lost.  Any
+// requests waiting on the PLM when the connection drops fail with this error
+var ErrDisconnected = errors.New("Connection to the PLM was lost")
+
+// Opener opens the underlying connection (usually a serial port) to a PLM
+type Opener func() (io.ReadWriteCloser, error)
+
+// ConnectionState indicates whether a Reconnector currently has an open
+// connection to the PLM
+type ConnectionState int
+
+const (
+	Disconnected ConnectionState = iota
+	Connected
+)
+
+func (cs ConnectionState) String() string {
+	switch cs {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(cs))
+}
+
+// ConnectionEvent is delivered to subscribers whenever the state of a
+// Reconnector changes.  Err is the failure that caused a disconnect
+type ConnectionEvent struct {
+	State ConnectionState
+	Err   error
+}
+
+// ReconnectConfig controls how quickly a Reconnector tries to reopen a
+// failed connection
+type ReconnectConfig struct {
+	// MinBackoff is the delay before the first attempt to reopen the
+	// connection.  The delay is doubled after every failed attempt.
+	// Defaults to 500ms
+	MinBackoff time.Duration
+
+	// MaxBackoff is the longest delay between attempts.  Defaults to 30s
+	MaxBackoff time.Duration
+}
+
+// Reconnector is an io.ReadWriteCloser that supervises the connection to a
+// PLM.  When a read or write fails the connection is closed and reopened
+// in the background with exponential backoff.  The failed Read or Write
+// returns ErrDisconnected so that the Port abandons any partially read
+// packet and re-synchronizes on the next start byte.  Reads then block
+// until the connection is back, while writes fail immediately with
+// ErrDisconnected
+type Reconnector struct {
+	open   Opener
+	config ReconnectConfig
+
+	mutex       sync.Mutex
+	cond        *sync.Cond
+	conn        io.ReadWriteCloser
+	closed      bool
+	closeCh     chan struct{}
+	subscribers []chan<- ConnectionEvent
+}
+
+// NewReconnector opens the initial connection and returns a Reconnector
+// that will reopen it whenever it fails.  An error is returned if the
+// initial connection cannot be opened
+func NewReconnector(open Opener, config ReconnectConfig) (*Reconnector, error) {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 500 * time.Millisecond
+	}
+
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 30 * time.Second
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+
+	conn, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reconnector{
+		open:    open,
+		config:  config,
+		conn:    conn,
+		closeCh: make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mutex)
+	return r, nil
+}
+
+// Subscribe delivers connection state changes to ch.  Events are
+// dropped if ch is not ready to receive them
+func (r *Reconnector) Subscribe(ch chan<- ConnectionEvent) {
+	r.mutex.Lock()
+	r.subscribers = append(r.subscribers, ch)
+	r.mutex.Unlock()
+}
+
+// State returns the current connection state
+func (r *Reconnector) State() ConnectionState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.conn == nil {
+		return Disconnected
+	}
+	return Connected
+}
+
+// emit must be called with the mutex held
+func (r *Reconnector) emit(event ConnectionEvent) {
+	for _, ch := range r.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// fail closes conn and starts reconnecting, unless conn has already
+// been replaced or the Reconnector is closed
+func (r *Reconnector) fail(conn io.ReadWriteCloser, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed || r.conn != conn {
+		return
+	}
+
+	insteon.Log.Infof("Connection to PLM failed: %v", err)
+	r.conn = nil
+	conn.Close()
+	r.emit(ConnectionEvent{State: Disconnected, Err: err})
+	go r.reconnect()
+}
+
+func (r *Reconnector) reconnect() {
+	delay := r.config.MinBackoff
+	for {
+		select {
+		case <-time.After(delay):
+		case <-r.closeCh:
+			return
+		}
+
+		conn, err := r.open()
+		if err == nil {
+			r.mutex.Lock()
+			if r.closed {
+				r.mutex.Unlock()
+				conn.Close()
+				return
+			}
+			insteon.Log.Infof("Connection to PLM restored")
+			r.conn = conn
+			r.emit(ConnectionEvent{State: Connected})
+			r.cond.Broadcast()
+			r.mutex.Unlock()
+			return
+		}
+
+		insteon.Log.Debugf("Failed to reopen PLM connection: %v", err)
+		delay *= 2
+		if delay > r.config.MaxBackoff {
+			delay = r.config.MaxBackoff
+		}
+	}
+}
+
+// Read reads from the current connection, waiting for the connection to
+// be reopened if necessary.  io.EOF is returned once the Reconnector
+// has been closed
+func (r *Reconnector) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	for r.conn == nil && !r.closed {
+		r.cond.Wait()
+	}
+	conn, closed := r.conn, r.closed
+	r.mutex.Unlock()
+
+	if closed {
+		return 0, io.EOF
+	}
+
+	n, err := conn.Read(p)
+	if err != nil {
+		r.fail(conn, err)
+		if r.isClosed() {
+			return n, io.EOF
+		}
+		return n, ErrDisconnected
+	}
+	return n, nil
+}
+
+// Write writes to the current connection.  ErrDisconnected is returned if
+// the connection is down or the write fails
+func (r *Reconnector) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	conn, closed := r.conn, r.closed
+	r.mutex.Unlock()
+
+	if closed {
+		return 0, io.ErrClosedPipe
+	} else if conn == nil {
+		return 0, ErrDisconnected
+	}
+
+	n, err := conn.Write(p)
+	if err != nil {
+		r.fail(conn, err)
+		return n, ErrDisconnected
+	}
+	return n, nil
+}
+
+func (r *Reconnector) isClosed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+// Close closes the current connection and stops any attempt to reopen it
+func (r *Reconnector) Close() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	close(r.closeCh)
+	if r.conn != nil {
+		err = r.conn.Close()
+		r.conn = nil
+	}
+	r.cond.Broadcast()
+	return err
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTestUnplugged = errors.New("device unplugged")
+
+// failingPort is a fake serial port.  Reads return whatever is passed to
+// input and both reads and writes fail once Fail is called
+type failingPort struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	input   []byte
+	written []byte
+	err     error
+}
+
+func newFailingPort() *failingPort {
+	fp := &failingPort{}
+	fp.cond = sync.NewCond(&fp.mutex)
+	return fp
+}
+
+func (fp *failingPort) Input(buf ...byte) {
+	fp.mutex.Lock()
+	fp.input = append(fp.input, buf...)
+	fp.cond.Broadcast()
+	fp.mutex.Unlock()
+}
+
+func (fp *failingPort) Fail(err error) {
+	fp.mutex.Lock()
+	fp.err = err
+	fp.cond.Broadcast()
+	fp.mutex.Unlock()
+}
+
+func (fp *failingPort) Written() []byte {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	return append([]byte(nil), fp.written...)
+}
+
+func (fp *failingPort) Read(p []byte) (int, error) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	for len(fp.input) == 0 && fp.err == nil {
+		fp.cond.Wait()
+	}
+
+	if fp.err != nil {
+		return 0, fp.err
+	}
+	n := copy(p, fp.input)
+	fp.input = fp.input[n:]
+	return n, nil
+}
+
+func (fp *failingPort) Write(p []byte) (int, error) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	if fp.err != nil {
+		return 0, fp.err
+	}
+	fp.written = append(fp.written, p...)
+	return len(p), nil
+}
+
+func (fp *failingPort) Close() error {
+	fp.Fail(io.ErrClosedPipe)
+	return nil
+}
+
+// testOpener hands out failingPorts and fails the first failures attempts
+// after the initial open
+type testOpener struct {
+	mutex    sync.Mutex
+	ports    []*failingPort
+	attempts int
+	failures int
+}
+
+func (to *testOpener) Open() (io.ReadWriteCloser, error) {
+	to.mutex.Lock()
+	defer to.mutex.Unlock()
+	to.attempts++
+	if len(to.ports) > 0 && to.failures > 0 {
+		to.failures--
+		return nil, errTestUnplugged
+	}
+	port := newFailingPort()
+	to.ports = append(to.ports, port)
+	return port, nil
+}
+
+func (to *testOpener) Port(i int) *failingPort {
+	to.mutex.Lock()
+	defer to.mutex.Unlock()
+	if i < len(to.ports) {
+		return to.ports[i]
+	}
+	return nil
+}
+
+func (to *testOpener) Attempts() int {
+	to.mutex.Lock()
+	defer to.mutex.Unlock()
+	return to.attempts
+}
+
+func waitEvent(t *testing.T, eventCh <-chan ConnectionEvent) ConnectionEvent {
+	select {
+	case event := <-eventCh:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for connection event")
+	}
+	return ConnectionEvent{}
+}
+
+func TestReconnector(t *testing.T) {
+	opener := &testOpener{failures: 2}
+	r, err := NewReconnector(opener.Open, ReconnectConfig{MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+
+	eventCh := make(chan ConnectionEvent, 2)
+	r.Subscribe(eventCh)
+
+	opener.Port(0).Input(0x02)
+	buf := make([]byte, 1)
+	if n, err := r.Read(buf); n != 1 || err != nil {
+		t.Fatalf("expected 1 byte got %d (%v)", n, err)
+	}
+
+	opener.Port(0).Fail(errTestUnplugged)
+	if _, err = r.Read(buf); err != ErrDisconnected {
+		t.Errorf("expected %v got %v", ErrDisconnected, err)
+	}
+
+	event := waitEvent(t, eventCh)
+	if event.State != Disconnected || event.Err != errTestUnplugged {
+		t.Errorf("expected disconnected event got %v (%v)", event.State, event.Err)
+	}
+
+	event = waitEvent(t, eventCh)
+	if event.State != Connected {
+		t.Errorf("expected connected event got %v", event.State)
+	}
+
+	if opener.Attempts() != 4 {
+		t.Errorf("expected 4 open attempts got %d", opener.Attempts())
+	}
+
+	if _, err = r.Write([]byte{0x02, 0x60}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if got := opener.Port(1).Written(); !bytes.Equal(got, []byte{0x02, 0x60}) {
+		t.Errorf("expected 0260 got %x", got)
+	}
+}
+
+func TestReconnectorWriteDisconnected(t *testing.T) {
+	opener := &testOpener{failures: 1000}
+	r, _ := NewReconnector(opener.Open, ReconnectConfig{MinBackoff: time.Hour})
+
+	opener.Port(0).Fail(errTestUnplugged)
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write([]byte{0x02}); err != ErrDisconnected {
+			t.Errorf("write %d: expected %v got %v", i, ErrDisconnected, err)
+		}
+	}
+
+	if r.State() != Disconnected {
+		t.Errorf("expected %v got %v", Disconnected, r.State())
+	}
+
+	// Close must unblock readers waiting for the connection
+	doneCh := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		doneCh <- err
+	}()
+	r.Close()
+
+	select {
+	case err := <-doneCh:
+		if err != io.EOF {
+			t.Errorf("expected %v got %v", io.EOF, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for Read to return")
+	}
+}
+
+func TestPLMReconnect(t *testing.T) {
+	opener := &testOpener{}
+	r, _ := NewReconnector(opener.Open, ReconnectConfig{MinBackoff: time.Millisecond})
+	plm := New(NewPort(r, time.Second), 5*time.Second)
+	defer plm.Close()
+
+	eventCh := make(chan ConnectionEvent, 2)
+	r.Subscribe(eventCh)
+
+	// the request is pending when the port fails part way through the
+	// response, and must fail quickly rather than waiting for the timeout
+	go func() {
+		opener.Port(0).Input(0x02, 0x60, 1)
+		time.Sleep(10 * time.Millisecond)
+		opener.Port(0).Fail(errTestUnplugged)
+	}()
+
+	start := time.Now()
+	if _, err := plm.Info(); err != ErrDisconnected {
+		t.Errorf("expected %v got %v", ErrDisconnected, err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("expected pending request to fail when the connection dropped")
+	}
+
+	waitEvent(t, eventCh)
+	if event := waitEvent(t, eventCh); event.State != Connected {
+		t.Fatalf("expected connected event got %v", event.State)
+	}
+
+	// the partial packet read before the failure must not corrupt the
+	// response from the new connection
+	port := opener.Port(1)
+	go func() {
+		for len(port.Written()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		port.Input(0x00, 0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06)
+	}()
+
+	info, err := plm.Info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Address != [3]byte{1, 2, 3} {
+		t.Errorf("expected 01.02.03 got %v", info.Address)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// ErrReplayFinished is returned by a strict Replay when a frame is written
+// after every recorded frame has been replayed
+var ErrReplayFinished = errors.New("Replay has no more recorded frames")
+
+// ReplayMismatchError is returned by a strict Replay when a written frame
+// does not match the recording
+type ReplayMismatchError struct {
+	Index    int   // index of the record in the capture file
+	Expected Frame // the recorded frame
+	Got      Frame // the frame that was written
+}
+
+func (rme *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("record %d: expected frame %x got %x", rme.Index, []byte(rme.Expected), []byte(rme.Got))
+}
+
+// ReplayConfig controls how a capture is replayed
+type ReplayConfig struct {
+	// RealTime delays each received frame by the same amount of time that
+	// separated it from the previous frame in the recording.  Otherwise
+	// received frames are delivered as fast as they are read
+	RealTime bool
+
+	// Strict causes writes that do not match the next recorded frame
+	// (or that occur after the recording is finished) to fail.  The
+	// first failure is also returned by Err
+	Strict bool
+}
+
+// Replay is an io.ReadWriter that plays back a capture file so that it can
+// be handed to NewPort in place of a serial port.  Frames that were read
+// from the PLM are returned by Read in order.  Each received frame is only
+// delivered once the frames that were written before it in the recording
+// have been written to the Replay
+type Replay struct {
+	config  ReplayConfig
+	records []*CaptureRecord
+
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	pos        int
+	pending    []byte
+	lastRecord time.Time
+	lastReplay time.Time
+	err        error
+	closed     bool
+	doneCh     chan struct{}
+}
+
+// NewReplay reads every record from the capture file and returns a
+// Replay for them
+func NewReplay(reader io.Reader, config ReplayConfig) (*Replay, error) {
+	records := []*CaptureRecord{}
+	captureReader := NewCaptureReader(reader)
+	for {
+		record, err := captureReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return newReplay(records, config), nil
+}
+
+func newReplay(records []*CaptureRecord, config ReplayConfig) *Replay {
+	replay := &Replay{
+		config:     config,
+		records:    records,
+		lastReplay: time.Now(),
+		doneCh:     make(chan struct{}),
+	}
+	replay.cond = sync.NewCond(&replay.mutex)
+
+	if len(records) > 0 {
+		replay.lastRecord = records[0].Time
+	} else {
+		close(replay.doneCh)
+	}
+	return replay
+}
+
+// advance moves past the current record.  The mutex must be held
+func (replay *Replay) advance() {
+	replay.lastRecord = replay.records[replay.pos].Time
+	replay.lastReplay = time.Now()
+	replay.pos++
+	if replay.pos == len(replay.records) {
+		close(replay.doneCh)
+	}
+	replay.cond.Broadcast()
+}
+
+// Read returns the next recorded frame that was received from the PLM.
+// Read blocks until the frames recorded before it have been written. Once
+// every frame has been replayed Read blocks, like an idle serial port,
+// until the Replay is closed and then returns io.EOF
+func (replay *Replay) Read(p []byte) (n int, err error) {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+
+	for len(replay.pending) == 0 {
+		for !replay.closed && (replay.pos == len(replay.records) || replay.records[replay.pos].Direction != DirectionRx) {
+			replay.cond.Wait()
+		}
+
+		if replay.closed {
+			return 0, io.EOF
+		}
+
+		record := replay.records[replay.pos]
+		if replay.config.RealTime {
+			delay := replay.lastReplay.Add(record.Time.Sub(replay.lastRecord)).Sub(time.Now())
+			if delay > 0 {
+				// only Read advances past received frames, so the
+				// position cannot change while the mutex is released
+				replay.mutex.Unlock()
+				time.Sleep(delay)
+				replay.mutex.Lock()
+			}
+		}
+
+		replay.pending = append([]byte{}, record.Frame...)
+		replay.advance()
+	}
+
+	n = copy(p, replay.pending)
+	replay.pending = replay.pending[n:]
+	return n, nil
+}
+
+// Write compares the frame to the next recorded frame that was written to
+// the PLM.  Write blocks until the received frames recorded before it have
+// been read
+func (replay *Replay) Write(p []byte) (n int, err error) {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+
+	for !replay.closed && replay.pos < len(replay.records) && replay.records[replay.pos].Direction != DirectionTx {
+		replay.cond.Wait()
+	}
+
+	if replay.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if replay.pos == len(replay.records) {
+		insteon.Log.Debugf("Replay received %x after the recording finished", p)
+		if replay.config.Strict {
+			return 0, replay.fail(ErrReplayFinished)
+		}
+		return len(p), nil
+	}
+
+	record := replay.records[replay.pos]
+	if !bytes.Equal(record.Frame, p) {
+		mismatch := &ReplayMismatchError{Index: replay.pos, Expected: record.Frame, Got: append(Frame{}, p...)}
+		insteon.Log.Debugf("Replay mismatch: %v", mismatch)
+		if replay.config.Strict {
+			return 0, replay.fail(mismatch)
+		}
+	}
+
+	replay.advance()
+	return len(p), nil
+}
+
+// fail records the first error.  The mutex must be held
+func (replay *Replay) fail(err error) error {
+	if replay.err == nil {
+		replay.err = err
+	}
+	return err
+}
+
+// Err returns the first mismatch found by a strict Replay
+func (replay *Replay) Err() error {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+	return replay.err
+}
+
+// Remaining returns the number of records that have not been replayed
+func (replay *Replay) Remaining() int {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+	return len(replay.records) - replay.pos
+}
+
+// Done returns a channel that is closed once every record has been replayed
+func (replay *Replay) Done() <-chan struct{} {
+	return replay.doneCh
+}
+
+// Close stops the replay.  Blocked reads return io.EOF and blocked
+// writes fail
+func (replay *Replay) Close() error {
+	replay.mutex.Lock()
+	replay.closed = true
+	replay.cond.Broadcast()
+	replay.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+var testCapture = strings.Join([]string{
+	`{"time":"2019-06-01T10:00:00Z","dir":"tx","frame":"0260"}`,
+	`{"time":"2019-06-01T10:00:00.05Z","dir":"rx","frame":"026001020303159b06"}`,
+	`{"time":"2019-06-01T10:00:01Z","dir":"tx","frame":"02620405060f11ff"}`,
+	`{"time":"2019-06-01T10:00:01.1Z","dir":"rx","frame":"02620405060f11ff06"}`,
+}, "\n")
+
+func newTestReplay(t *testing.T, config ReplayConfig) *Replay {
+	replay, err := NewReplay(strings.NewReader(testCapture), config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return replay
+}
+
+func TestReplayReadWrite(t *testing.T) {
+	replay := newTestReplay(t, ReplayConfig{})
+	if replay.Remaining() != 4 {
+		t.Fatalf("expected 4 records got %d", replay.Remaining())
+	}
+
+	readCh := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 4)
+		n, _ := replay.Read(buf)
+		readCh <- buf[:n]
+	}()
+
+	select {
+	case <-readCh:
+		t.Fatalf("expected read to block until the recorded write")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	_, err := replay.Write([]byte{0x02, 0x60})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the frame is returned in pieces when the buffer is too small
+	got := <-readCh
+	buf := make([]byte, 32)
+	n, _ := replay.Read(buf)
+	got = append(got, buf[:n]...)
+	expected := []byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06}
+	if !bytes.Equal(expected, got) {
+		t.Errorf("expected %x got %x", expected, got)
+	}
+
+	// mismatches are accepted when the replay is not strict
+	_, err = replay.Write([]byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x13, 0x00})
+	if err != nil || replay.Err() != nil {
+		t.Errorf("unexpected error: %v %v", err, replay.Err())
+	}
+
+	n, _ = replay.Read(buf)
+	if n != 9 || replay.Remaining() != 0 {
+		t.Errorf("expected 9 bytes and no remaining records, got %d and %d", n, replay.Remaining())
+	}
+
+	select {
+	case <-replay.Done():
+	default:
+		t.Errorf("expected replay to be done")
+	}
+
+	go replay.Close()
+	if _, err = replay.Read(buf); err != io.EOF {
+		t.Errorf("expected %v got %v", io.EOF, err)
+	}
+}
+
+func TestReplayStrict(t *testing.T) {
+	replay := newTestReplay(t, ReplayConfig{Strict: true})
+	_, err := replay.Write([]byte{0x02, 0x61})
+	if mismatch, ok := err.(*ReplayMismatchError); !ok || mismatch.Index != 0 {
+		t.Errorf("expected mismatch at record 0 got %v", err)
+	}
+
+	if replay.Err() != err {
+		t.Errorf("expected %v got %v", err, replay.Err())
+	}
+
+	replay = newTestReplay(t, ReplayConfig{Strict: true})
+	buf := make([]byte, 32)
+	replay.Write([]byte{0x02, 0x60})
+	replay.Read(buf)
+	replay.Write([]byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff})
+	replay.Read(buf)
+
+	_, err = replay.Write([]byte{0x02, 0x60})
+	if err != ErrReplayFinished {
+		t.Errorf("expected %v got %v", ErrReplayFinished, err)
+	}
+}
+
+func TestReplayRealTime(t *testing.T) {
+	replay := newTestReplay(t, ReplayConfig{RealTime: true})
+	replay.Write([]byte{0x02, 0x60})
+
+	start := time.Now()
+	replay.Read(make([]byte, 32))
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected received frame to be delayed by 50ms, got %v", elapsed)
+	}
+}
+
+func TestReplayPLM(t *testing.T) {
+	replay := newTestReplay(t, ReplayConfig{Strict: true})
+	modem := New(NewPort(replay, time.Second), time.Second)
+	defer modem.Close()
+
+	info, err := modem.Info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Address != (insteon.Address{1, 2, 3}) {
+		t.Errorf("expected %v got %v", insteon.Address{1, 2, 3}, info.Address)
+	}
+
+	if replay.Remaining() != 2 || replay.Err() != nil {
+		t.Errorf("expected 2 remaining records got %d (%v)", replay.Remaining(), replay.Err())
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrNotPollable is returned when a device is added to a Poller but
+// the device implements neither the Switch nor the PingableDevice interface
+var ErrNotPollable = errors.New("Device cannot be polled")
+
+// PollEventType indicates what condition a PollEvent is reporting
+type PollEventType int
+
+// Poll event types
+const (
+	// DeviceUnresponsive is emitted when a polled device has failed to
+	// respond to consecutive polls
+	DeviceUnresponsive PollEventType = iota
+
+	// DeviceResponsive is emitted when a device that was previously
+	// unresponsive responds to a poll
+	DeviceResponsive
+
+	// HeartbeatMissed is emitted when no message has been received from a
+	// device within its expected heartbeat interval
+	HeartbeatMissed
+
+	// HeartbeatRestored is emitted when a message is received from a device
+	// that had previously missed its heartbeat
+	HeartbeatRestored
+)
+
+func (pet PollEventType) String() string {
+	switch pet {
+	case DeviceUnresponsive:
+		return "device unresponsive"
+	case DeviceResponsive:
+		return "device responsive"
+	case HeartbeatMissed:
+		return "heartbeat missed"
+	case HeartbeatRestored:
+		return "heartbeat restored"
+	}
+	return sprintf("PollEventType(%d)", int(pet))
+}
+
+// PollEvent is delivered on the Poller's event channel when a device
+// changes between responsive and unresponsive
+type PollEvent struct {
+	Type    PollEventType
+	Address Address
+	Time    time.Time
+
+	// Err is the error returned by the last failed poll.  It is only
+	// set for DeviceUnresponsive events
+	Err error
+}
+
+func (pe PollEvent) String() string {
+	return sprintf("%s %v", pe.Address, pe.Type)
+}
+
+// Default poller settings
+const (
+	DefaultPollInterval = 5 * time.Minute
+	DefaultPollGap      = time.Second
+	DefaultPollFailures = 2
+)
+
+// PollConfig controls how often devices are polled
+type PollConfig struct {
+	// Interval is the time between polls of the same device
+	Interval time.Duration
+
+	// Jitter is the maximum random amount of time added to each device's
+	// poll interval so that devices don't all get polled at once
+	Jitter time.Duration
+
+	// Gap is the minimum time between any two polls.  Only one poll is sent
+	// at a time, so the gap leaves room in the PLM queue for interactive
+	// commands
+	Gap time.Duration
+
+	// Failures is the number of consecutive failed polls before a device
+	// is considered unresponsive
+	Failures int
+
+	// Tracker, if set, will be updated with the levels returned by polling
+	// Switch devices
+	Tracker *StateTracker
+}
+
+type pollTarget struct {
+	device       Device
+	next         time.Time
+	failures     int
+	unresponsive bool
+}
+
+type heartbeatTarget struct {
+	interval time.Duration
+	lastSeen time.Time
+	missed   bool
+}
+
+// Poller periodically polls devices to make sure they are still responding
+// and watches for the heartbeat messages that battery operated devices
+// send.  Switch devices are polled with a status request while other
+// devices that implement PingableDevice are pinged.  Events are delivered
+// on the event channel when a device stops (or resumes) responding
+type Poller struct {
+	mutex      sync.Mutex
+	config     PollConfig
+	targets    map[Address]*pollTarget
+	heartbeats map[Address]*heartbeatTarget
+
+	network *Network
+	eventCh chan<- PollEvent
+	recvCh  chan *Message
+	closeCh chan bool
+	doneCh  chan bool
+}
+
+// NewPoller will create a poller that polls devices on the network.  Every
+// message received by the network counts towards a device's heartbeat.  If
+// eventCh is not nil, poll events will be delivered to it and must be
+// continually read
+func NewPoller(network *Network, config PollConfig, eventCh chan<- PollEvent) *Poller {
+	poller := newPoller(make(chan *Message, 1), config, eventCh)
+	poller.network = network
+	network.Subscribe(poller.recvCh)
+	return poller
+}
+
+func newPoller(recvCh chan *Message, config PollConfig, eventCh chan<- PollEvent) *Poller {
+	if config.Interval <= 0 {
+		config.Interval = DefaultPollInterval
+	}
+
+	if config.Gap <= 0 {
+		config.Gap = DefaultPollGap
+	}
+
+	if config.Failures <= 0 {
+		config.Failures = DefaultPollFailures
+	}
+
+	poller := &Poller{
+		config:     config,
+		targets:    make(map[Address]*pollTarget),
+		heartbeats: make(map[Address]*heartbeatTarget),
+		eventCh:    eventCh,
+		recvCh:     recvCh,
+		closeCh:    make(chan bool),
+		doneCh:     make(chan bool),
+	}
+
+	go poller.receive()
+	go poller.process()
+	return poller
+}
+
+func (p *Poller) jitter() time.Duration {
+	if p.config.Jitter > 0 {
+		return time.Duration(rand.Int63n(int64(p.config.Jitter)))
+	}
+	return 0
+}
+
+// Add will schedule the device to be polled.  The first poll occurs within
+// the configured jitter
+func (p *Poller) Add(device Device) error {
+	switch device.(type) {
+	case Switch, PingableDevice:
+	default:
+		return ErrNotPollable
+	}
+
+	p.mutex.Lock()
+	p.targets[device.Address()] = &pollTarget{device: device, next: time.Now().Add(p.jitter())}
+	p.mutex.Unlock()
+	return nil
+}
+
+// Remove will stop polling the device and stop watching for its heartbeat
+func (p *Poller) Remove(address Address) {
+	p.mutex.Lock()
+	delete(p.targets, address)
+	delete(p.heartbeats, address)
+	p.mutex.Unlock()
+}
+
+// ExpectHeartbeat will watch for messages from the device at address. If no
+// message (usually a CmdHeartbeat broadcast) is received within the interval
+// then a HeartbeatMissed event is emitted
+func (p *Poller) ExpectHeartbeat(address Address, interval time.Duration) {
+	p.mutex.Lock()
+	p.heartbeats[address] = &heartbeatTarget{interval: interval, lastSeen: time.Now()}
+	p.mutex.Unlock()
+}
+
+func (p *Poller) emit(events ...PollEvent) {
+	if p.eventCh == nil {
+		return
+	}
+
+	for _, event := range events {
+		Log.Debugf("Poller: %v", event)
+		p.eventCh <- event
+	}
+}
+
+func (p *Poller) receive() {
+	for msg := range p.recvCh {
+		events := []PollEvent{}
+		p.mutex.Lock()
+		if heartbeat, found := p.heartbeats[msg.Src]; found {
+			heartbeat.lastSeen = time.Now()
+			if heartbeat.missed {
+				heartbeat.missed = false
+				events = append(events, PollEvent{Type: HeartbeatRestored, Address: msg.Src, Time: heartbeat.lastSeen})
+			}
+		}
+		p.mutex.Unlock()
+		p.emit(events...)
+	}
+}
+
+func (p *Poller) process() {
+	defer close(p.doneCh)
+	timer := time.NewTimer(p.config.Gap)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case now := <-timer.C:
+			p.checkHeartbeats(now)
+			if target := p.nextTarget(now); target != nil {
+				p.poll(target)
+			}
+			timer.Reset(p.config.Gap)
+		}
+	}
+}
+
+func (p *Poller) checkHeartbeats(now time.Time) {
+	events := []PollEvent{}
+	p.mutex.Lock()
+	for address, heartbeat := range p.heartbeats {
+		if !heartbeat.missed && now.Sub(heartbeat.lastSeen) > heartbeat.interval {
+			heartbeat.missed = true
+			events = append(events, PollEvent{Type: HeartbeatMissed, Address: address, Time: now})
+		}
+	}
+	p.mutex.Unlock()
+	p.emit(events...)
+}
+
+// nextTarget returns the most overdue target, or nil if no target is due
+func (p *Poller) nextTarget(now time.Time) (next *pollTarget) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, target := range p.targets {
+		if !target.next.After(now) && (next == nil || target.next.Before(next.next)) {
+			next = target
+		}
+	}
+
+	if next != nil {
+		next.next = now.Add(p.config.Interval + p.jitter())
+	}
+	return next
+}
+
+// poll sends a status request to Switch devices and pings the others.
+// Devices that are PrioritySenders are polled at PriorityBackground so
+// that polls never delay interactive commands, without changing the
+// priority of the device for its other users
+func (p *Poller) poll(target *pollTarget) {
+	var err error
+	address := target.device.Address()
+	_, background := target.device.(PrioritySender)
+	if sw, ok := target.device.(Switch); ok {
+		var level int
+		if background {
+			var response Command
+			response, err = SendCommandPriority(target.device, CmdLightStatusRequest, nil, PriorityBackground)
+			level = int(response[1])
+		} else {
+			level, err = sw.Status()
+		}
+
+		if err == nil && p.config.Tracker != nil {
+			p.config.Tracker.Update(address, level)
+		}
+	} else if pingable, ok := target.device.(PingableDevice); ok {
+		if background {
+			_, err = SendCommandPriority(target.device, CmdPing, nil, PriorityBackground)
+		} else {
+			err = pingable.Ping()
+		}
+	}
+
+	events := []PollEvent{}
+	p.mutex.Lock()
+	if err == nil {
+		target.failures = 0
+		if target.unresponsive {
+			target.unresponsive = false
+			events = append(events, PollEvent{Type: DeviceResponsive, Address: address, Time: time.Now()})
+		}
+	} else {
+		Log.Debugf("Poll of %v failed: %v", address, err)
+		target.failures++
+		if !target.unresponsive && target.failures >= p.config.Failures {
+			target.unresponsive = true
+			events = append(events, PollEvent{Type: DeviceUnresponsive, Address: address, Time: time.Now(), Err: err})
+		}
+	}
+	p.mutex.Unlock()
+	p.emit(events...)
+}
+
+// Close will stop polling
+func (p *Poller) Close() {
+	close(p.closeCh)
+	<-p.doneCh
+	if p.network == nil {
+		close(p.recvCh)
+	} else {
+		p.network.Unsubscribe(p.recvCh)
+	}
+}
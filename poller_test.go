@@ -0,0 +1,191 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDevice is a Device that does not support any commands
+type testDevice struct {
+	address Address
+}
+
+func (td *testDevice) Address() Address { return td.address }
+
+func (td *testDevice) SendCommand(Command, []byte) (Command, error) {
+	return Command{}, ErrNotImplemented
+}
+
+func (td *testDevice) SendCommandAndListen(Command, []byte) (<-chan *CommandResponse, error) {
+	return nil, ErrNotImplemented
+}
+
+type testPingable struct {
+	testDevice
+	devicePriority
+	sync.Mutex
+	pings int
+	err   error
+}
+
+func (tp *testPingable) Ping() error {
+	tp.Lock()
+	defer tp.Unlock()
+	tp.pings++
+	return tp.err
+}
+
+func (tp *testPingable) setErr(err error) {
+	tp.Lock()
+	tp.err = err
+	tp.Unlock()
+}
+
+func readPollEvent(t *testing.T, eventCh <-chan PollEvent) PollEvent {
+	select {
+	case event := <-eventCh:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for poll event")
+	}
+	return PollEvent{}
+}
+
+func TestPollerAdd(t *testing.T) {
+	poller := newPoller(make(chan *Message), PollConfig{}, nil)
+	defer poller.Close()
+
+	if poller.config.Interval != DefaultPollInterval || poller.config.Gap != DefaultPollGap || poller.config.Failures != DefaultPollFailures {
+		t.Errorf("expected default config got %+v", poller.config)
+	}
+
+	err := poller.Add(&testDevice{})
+	if err != ErrNotPollable {
+		t.Errorf("expected %v got %v", ErrNotPollable, err)
+	}
+
+	pingable := &testPingable{}
+	err = poller.Add(pingable)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// polling must not change the priority of the device for its
+	// other users
+	if pingable.Priority() != PriorityDefault {
+		t.Errorf("expected %v got %v", PriorityDefault, pingable.Priority())
+	}
+}
+
+// testPrioritySender records the priority of the commands sent to it
+type testPrioritySender struct {
+	testPingable
+	priorities chan Priority
+}
+
+func (tps *testPrioritySender) SendCommandPriority(command Command, payload []byte, priority Priority) (Command, error) {
+	tps.priorities <- priority
+	return command, nil
+}
+
+func TestPollerPriority(t *testing.T) {
+	poller := newPoller(make(chan *Message), PollConfig{Interval: time.Hour, Gap: time.Millisecond}, nil)
+	defer poller.Close()
+
+	device := &testPrioritySender{testPingable: testPingable{testDevice: testDevice{Address{1, 2, 3}}}, priorities: make(chan Priority, 1)}
+	device.SetPriority(PriorityInteractive)
+	poller.Add(device)
+
+	select {
+	case priority := <-device.priorities:
+		if priority != PriorityBackground {
+			t.Errorf("expected poll at %v got %v", PriorityBackground, priority)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for poll")
+	}
+
+	if device.Priority() != PriorityInteractive {
+		t.Errorf("expected device priority %v got %v", PriorityInteractive, device.Priority())
+	}
+}
+
+func TestPollerUnresponsive(t *testing.T) {
+	eventCh := make(chan PollEvent, 1)
+	poller := newPoller(make(chan *Message), PollConfig{Interval: time.Millisecond, Gap: time.Millisecond, Failures: 2}, eventCh)
+	defer poller.Close()
+
+	device := &testPingable{testDevice: testDevice{Address{1, 2, 3}}, err: ErrReadTimeout}
+	poller.Add(device)
+
+	event := readPollEvent(t, eventCh)
+	if event.Type != DeviceUnresponsive || event.Address != device.address || event.Err != ErrReadTimeout {
+		t.Errorf("expected %v unresponsive got %v (%v)", device.address, event, event.Err)
+	}
+
+	device.Lock()
+	if device.pings < 2 {
+		t.Errorf("expected at least 2 pings got %d", device.pings)
+	}
+	device.Unlock()
+
+	device.setErr(nil)
+	event = readPollEvent(t, eventCh)
+	if event.Type != DeviceResponsive {
+		t.Errorf("expected %v got %v", DeviceResponsive, event.Type)
+	}
+}
+
+func TestPollerHeartbeat(t *testing.T) {
+	eventCh := make(chan PollEvent, 1)
+	recvCh := make(chan *Message)
+	poller := newPoller(recvCh, PollConfig{Gap: time.Millisecond}, eventCh)
+	defer poller.Close()
+
+	address := Address{1, 2, 3}
+	poller.ExpectHeartbeat(address, 5*time.Millisecond)
+	event := readPollEvent(t, eventCh)
+	if event.Type != HeartbeatMissed || event.Address != address {
+		t.Errorf("expected %v %v got %v", address, HeartbeatMissed, event)
+	}
+
+	recvCh <- &Message{Src: address, Dst: Address{0, 0, 4}, Flags: StandardAllLinkBroadcast, Command: CmdHeartbeat}
+	event = readPollEvent(t, eventCh)
+	if event.Type != HeartbeatRestored {
+		t.Errorf("expected %v got %v", HeartbeatRestored, event.Type)
+	}
+}
+
+func TestPollEventTypeString(t *testing.T) {
+	tests := []struct {
+		input    PollEventType
+		expected string
+	}{
+		{DeviceUnresponsive, "device unresponsive"},
+		{DeviceResponsive, "device responsive"},
+		{HeartbeatMissed, "heartbeat missed"},
+		{HeartbeatRestored, "heartbeat restored"},
+		{PollEventType(42), "PollEventType(42)"},
+	}
+
+	for i, test := range tests {
+		if test.input.String() != test.expected {
+			t.Errorf("tests[%d] expected %q got %q", i, test.expected, test.input.String())
+		}
+	}
+}
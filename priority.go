@@ -0,0 +1,118 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import "sync/atomic"
+
+// Priority determines the order in which queued messages are delivered to
+// the modem.  Messages with a higher priority are always sent before
+// those with a lower priority, and messages with the same priority are
+// shared fairly between destinations
+type Priority int
+
+const (
+	// PriorityDefault indicates that no priority was given for a
+	// request.  The priority of the device (or PriorityAutomation) is
+	// used instead
+	PriorityDefault Priority = iota
+
+	// PriorityBackground is used for bulk operations such as reading
+	// or writing link databases
+	PriorityBackground
+
+	// PriorityAutomation is used for scheduled or rule driven commands
+	PriorityAutomation
+
+	// PriorityInteractive is used for commands issued directly by a user
+	PriorityInteractive
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityDefault:
+		return "default"
+	case PriorityBackground:
+		return "background"
+	case PriorityAutomation:
+		return "automation"
+	case PriorityInteractive:
+		return "interactive"
+	}
+	return sprintf("Priority(%d)", int(p))
+}
+
+// Level returns the effective priority, where PriorityDefault is
+// treated as PriorityAutomation
+func (p Priority) Level() Priority {
+	if p == PriorityDefault {
+		return PriorityAutomation
+	}
+	return p
+}
+
+// Prioritizer is implemented by devices that can change the priority of
+// the messages they send
+type Prioritizer interface {
+	// SetPriority sets the priority of every subsequent message sent
+	// by the device that does not already have a priority
+	SetPriority(priority Priority)
+
+	// Priority returns the current priority of the device
+	Priority() Priority
+}
+
+// PrioritySender is implemented by devices that can send a single command
+// with a priority other than the device's own
+type PrioritySender interface {
+	// SendCommandPriority is the same as SendCommand except that the
+	// command is sent with the given priority
+	SendCommandPriority(command Command, payload []byte, priority Priority) (response Command, err error)
+}
+
+// SendCommandPriority sends the command to the device with the given
+// priority without changing the priority of the device.  If the device
+// does not implement PrioritySender then the command is sent with the
+// device's own priority
+func SendCommandPriority(device Commandable, command Command, payload []byte, priority Priority) (response Command, err error) {
+	if sender, ok := device.(PrioritySender); ok {
+		return sender.SendCommandPriority(command, payload, priority)
+	}
+	return device.SendCommand(command, payload)
+}
+
+// devicePriority is embedded by devices to implement Prioritizer
+type devicePriority struct {
+	value int32
+}
+
+func (dp *devicePriority) SetPriority(priority Priority) {
+	atomic.StoreInt32(&dp.value, int32(priority))
+}
+
+func (dp *devicePriority) Priority() Priority {
+	return Priority(atomic.LoadInt32(&dp.value))
+}
+
+// SetPriority changes the priority of device and returns the previous
+// priority.  If the device does not implement Prioritizer then nothing
+// is changed and PriorityDefault is returned
+func SetPriority(device Device, priority Priority) Priority {
+	if prioritizer, ok := device.(Prioritizer); ok {
+		previous := prioritizer.Priority()
+		prioritizer.SetPriority(priority)
+		return previous
+	}
+	return PriorityDefault
+}
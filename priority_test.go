@@ -0,0 +1,67 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import "testing"
+
+func TestPriorityLevel(t *testing.T) {
+	tests := []struct {
+		input    Priority
+		expected Priority
+	}{
+		{PriorityDefault, PriorityAutomation},
+		{PriorityBackground, PriorityBackground},
+		{PriorityAutomation, PriorityAutomation},
+		{PriorityInteractive, PriorityInteractive},
+	}
+
+	for _, test := range tests {
+		if got := test.input.Level(); got != test.expected {
+			t.Errorf("%v: expected %v got %v", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestI1DevicePriority(t *testing.T) {
+	tests := []struct {
+		desc     string
+		device   Priority
+		request  Priority
+		command  Command
+		payload  []byte
+		expected Priority
+	}{
+		{"default", PriorityDefault, PriorityDefault, CmdLightOn, nil, PriorityDefault},
+		{"device", PriorityInteractive, PriorityDefault, CmdLightOn, nil, PriorityInteractive},
+		{"request", PriorityInteractive, PriorityBackground, CmdLightOn, nil, PriorityBackground},
+		{"link database", PriorityDefault, PriorityDefault, CmdReadWriteALDB, make([]byte, 14), PriorityBackground},
+		{"link database (device)", PriorityInteractive, PriorityDefault, CmdReadWriteALDB, make([]byte, 14), PriorityInteractive},
+	}
+
+	for _, test := range tests {
+		upstreamSendCh := make(chan *MessageRequest, 1)
+		device := &I1Device{
+			upstreamSendCh: upstreamSendCh,
+			queue:          []*CommandRequest{{Command: test.command, Payload: test.payload, Priority: test.request}},
+		}
+		SetPriority(device, test.device)
+		device.send()
+
+		request := <-upstreamSendCh
+		if request.Priority != test.expected {
+			t.Errorf("%s: expected %v got %v", test.desc, test.expected, request.Priority)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// qualityWindow is the number of recent ACKs used to tune the max hops
+// for a device
+const qualityWindow = 8
+
+// LinkQuality summarizes the direct messages exchanged with a device.
+// Devices that regularly need several hops, retries or that fail to
+// respond are candidates for a range extender
+type LinkQuality struct {
+	Address Address `json:"address"`
+
+	// Acks is the number of messages the device responded to (with
+	// either an ACK or a NAK)
+	Acks int `json:"acks"`
+
+	// Retries is the number of messages that were resent
+	Retries int `json:"retries"`
+
+	// Failures is the number of messages that were never acknowledged
+	Failures int `json:"failures"`
+
+	// Hops is the number of hops used by the most recent ACK
+	Hops int `json:"hops"`
+
+	// MaxHopsUsed is the most hops used by any of the recent ACKs
+	MaxHopsUsed int `json:"maxHopsUsed"`
+
+	// MaxHops is the max hops that new messages to the device are sent
+	// with.  It is one more than MaxHopsUsed, up to three
+	MaxHops int `json:"maxHops"`
+
+	// Latency is the average time from sending a message until the
+	// device responded
+	Latency time.Duration `json:"latency"`
+}
+
+type linkStats struct {
+	quality LinkQuality
+	recent  []int
+	latency time.Duration
+}
+
+// qualityTracker collects LinkQuality for every device on a network.  A
+// nil qualityTracker ignores everything
+type qualityTracker struct {
+	mutex sync.Mutex
+	stats map[Address]*linkStats
+}
+
+func newQualityTracker() *qualityTracker {
+	return &qualityTracker{stats: make(map[Address]*linkStats)}
+}
+
+// find must be called with the mutex held
+func (qt *qualityTracker) find(address Address) *linkStats {
+	stats, found := qt.stats[address]
+	if !found {
+		stats = &linkStats{quality: LinkQuality{Address: address}}
+		qt.stats[address] = stats
+	}
+	return stats
+}
+
+// hopsUsed is the number of times a message was repeated before it was
+// received
+func hopsUsed(flags Flags) int {
+	hops := flags.MaxTTL() - flags.TTL()
+	if hops < 0 {
+		hops = 0
+	}
+	return hops
+}
+
+func (qt *qualityTracker) acked(address Address, ack *Message, latency time.Duration) {
+	if qt == nil {
+		return
+	}
+
+	qt.mutex.Lock()
+	defer qt.mutex.Unlock()
+	stats := qt.find(address)
+	stats.quality.Acks++
+	stats.latency += latency
+	stats.quality.Latency = stats.latency / time.Duration(stats.quality.Acks)
+	stats.quality.Hops = hopsUsed(ack.Flags)
+
+	stats.recent = append(stats.recent, stats.quality.Hops)
+	if len(stats.recent) > qualityWindow {
+		stats.recent = stats.recent[1:]
+	}
+
+	stats.quality.MaxHopsUsed = 0
+	for _, hops := range stats.recent {
+		if hops > stats.quality.MaxHopsUsed {
+			stats.quality.MaxHopsUsed = hops
+		}
+	}
+
+	stats.quality.MaxHops = stats.quality.MaxHopsUsed + 1
+	if stats.quality.MaxHops > 3 {
+		stats.quality.MaxHops = 3
+	}
+}
+
+func (qt *qualityTracker) retried(address Address) {
+	if qt == nil {
+		return
+	}
+
+	qt.mutex.Lock()
+	qt.find(address).quality.Retries++
+	qt.mutex.Unlock()
+}
+
+func (qt *qualityTracker) failed(address Address) {
+	if qt == nil {
+		return
+	}
+
+	qt.mutex.Lock()
+	qt.find(address).quality.Failures++
+	qt.mutex.Unlock()
+}
+
+// maxHops returns the tuned max hops for the device, if any ACKs have
+// been received from it
+func (qt *qualityTracker) maxHops(address Address) (int, bool) {
+	if qt == nil {
+		return 0, false
+	}
+
+	qt.mutex.Lock()
+	defer qt.mutex.Unlock()
+	if stats, found := qt.stats[address]; found && stats.quality.MaxHops > 0 {
+		return stats.quality.MaxHops, true
+	}
+	return 0, false
+}
+
+func (qt *qualityTracker) get(address Address) (LinkQuality, bool) {
+	qt.mutex.Lock()
+	defer qt.mutex.Unlock()
+	if stats, found := qt.stats[address]; found {
+		return stats.quality, true
+	}
+	return LinkQuality{Address: address}, false
+}
+
+func (qt *qualityTracker) all() []LinkQuality {
+	qt.mutex.Lock()
+	qualities := make([]LinkQuality, 0, len(qt.stats))
+	for _, stats := range qt.stats {
+		qualities = append(qualities, stats.quality)
+	}
+	qt.mutex.Unlock()
+
+	sort.Slice(qualities, func(i, j int) bool {
+		return qualities[i].Address.String() < qualities[j].Address.String()
+	})
+	return qualities
+}
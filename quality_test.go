@@ -0,0 +1,82 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQualityTracker(t *testing.T) {
+	tests := []struct {
+		desc            string
+		acks            []Flags
+		expectedHops    int
+		expectedMaxUsed int
+		expectedMaxHops int
+	}{
+		{"direct", []Flags{StandardDirectAck}, 0, 0, 1},
+		{"one hop", []Flags{Flags(0x26)}, 1, 1, 2},
+		{"three hops", []Flags{Flags(0x23)}, 3, 3, 3},
+		{"recent", []Flags{Flags(0x23), StandardDirectAck}, 0, 3, 3},
+		{"window", []Flags{Flags(0x23), 0x2a, 0x2a, 0x2a, 0x2a, 0x2a, 0x2a, 0x2a, 0x2a}, 0, 0, 1},
+	}
+
+	for _, test := range tests {
+		qt := newQualityTracker()
+		for _, flags := range test.acks {
+			qt.acked(testDstAddr, &Message{Flags: flags}, 10*time.Millisecond)
+		}
+
+		quality, found := qt.get(testDstAddr)
+		if !found {
+			t.Errorf("%s: expected quality to be found", test.desc)
+			continue
+		}
+
+		if quality.Hops != test.expectedHops || quality.MaxHopsUsed != test.expectedMaxUsed || quality.MaxHops != test.expectedMaxHops {
+			t.Errorf("%s: expected hops %d/%d/%d got %d/%d/%d", test.desc, test.expectedHops, test.expectedMaxUsed, test.expectedMaxHops, quality.Hops, quality.MaxHopsUsed, quality.MaxHops)
+		}
+
+		if quality.Acks != len(test.acks) || quality.Latency != 10*time.Millisecond {
+			t.Errorf("%s: expected %d acks at 10ms got %d at %v", test.desc, len(test.acks), quality.Acks, quality.Latency)
+		}
+	}
+}
+
+func TestConnectionTunesHops(t *testing.T) {
+	qt := newQualityTracker()
+	qt.acked(testDstAddr, &Message{Flags: StandardDirectAck}, time.Millisecond)
+	qt.retried(testDstAddr)
+	qt.failed(testDstAddr)
+
+	upstreamSendCh := make(chan *MessageRequest, 1)
+	conn := &connection{addr: testDstAddr, quality: qt, upstreamSendCh: upstreamSendCh}
+	conn.queue = []*MessageRequest{{Message: &Message{Flags: StandardDirectMessage}}}
+	go func() {
+		request := <-upstreamSendCh
+		request.DoneCh <- request
+	}()
+	conn.send()
+
+	if got := conn.queue[0].Message.Flags; got != Flags(0x05) {
+		t.Errorf("expected flags 05 got %02x", byte(got))
+	}
+
+	qualities := qt.all()
+	if len(qualities) != 1 || qualities[0].Retries != 1 || qualities[0].Failures != 1 {
+		t.Errorf("expected 1 retry and 1 failure got %+v", qualities)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+// Client communicates with a Server.  The devices returned by the client
+// implement the same interfaces (Device, Switch, Dimmer and LinkableDevice)
+// as devices that are directly connected to a PLM, so programs can use
+// either interchangeably
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// NewClient returns a client for the server at the given base URL (for
+// instance http://localhost:8080)
+func NewClient(url string) *Client {
+	return &Client{
+		url:    strings.TrimRight(url, "/"),
+		client: http.DefaultClient,
+	}
+}
+
+// decodeError recreates the error from an ErrorResponse.  Errors that are
+// known to the insteon and plm packages are returned as those errors so
+// that callers can compare them in the usual way
+func decodeError(resp *http.Response) error {
+	errResp := &ErrorResponse{}
+	if json.NewDecoder(resp.Body).Decode(errResp) != nil || errResp.Error == "" {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	for _, err := range knownErrors {
+		if err.Error() == errResp.Error {
+			return err
+		}
+	}
+	return errors.New(errResp.Error)
+}
+
+func (c *Client) do(method, path string, input, output interface{}) error {
+	var body io.Reader
+	if input != nil {
+		buf, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+
+	if output != nil && resp.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(resp.Body).Decode(output)
+	}
+	return err
+}
+
+// Devices returns the list of devices known to the server
+func (c *Client) Devices() (devices []insteon.DeviceInfo, err error) {
+	err = c.do(http.MethodGet, "/devices", nil, &devices)
+	return devices, err
+}
+
+// DeviceInfo returns the server's information for the device at the address
+func (c *Client) DeviceInfo(address insteon.Address) (info insteon.DeviceInfo, err error) {
+	err = c.do(http.MethodGet, "/devices/"+address.String(), nil, &info)
+	return info, err
+}
+
+// LinkQuality returns the link quality that the server has collected for
+// the device at the address
+func (c *Client) LinkQuality(address insteon.Address) (quality insteon.LinkQuality, err error) {
+	err = c.do(http.MethodGet, "/devices/"+address.String()+"/quality", nil, &quality)
+	return quality, err
+}
+
+// Dial is the same as Connect.  The server determines the engine version
+// and device type
+func (c *Client) Dial(address insteon.Address) (insteon.Device, error) {
+	return c.Connect(address)
+}
+
+// Connect will return a device for the address.  If the server knows the
+// device to be a dimmer or switch, then the returned device will implement
+// the Dimmer or Switch interface
+func (c *Client) Connect(address insteon.Address) (device insteon.Device, err error) {
+	info, err := c.DeviceInfo(address)
+	if err == nil {
+		rd := &Device{client: c, path: "/devices/" + address.String(), address: address}
+		switch info.DevCat.Category() {
+		case insteon.Category(0x01):
+			device = &dimmer{Device: rd, Dimmer: insteon.NewDimmer(rd, info.FirmwareVersion)}
+		case insteon.Category(0x02):
+			device = &lightSwitch{Device: rd, Switch: insteon.NewSwitch(rd, info.FirmwareVersion)}
+		default:
+			device = rd
+		}
+	}
+	return device, err
+}
+
+// Device is a device that is connected to a remote server
+type Device struct {
+	client  *Client
+	path    string
+	address insteon.Address
+}
+
+// Address returns the address of the remote device
+func (d *Device) Address() insteon.Address { return d.address }
+
+// SendCommand sends the command to the device by way of the server
+func (d *Device) SendCommand(cmd insteon.Command, payload []byte) (insteon.Command, error) {
+	response := &CommandResponse{}
+	err := d.client.do(http.MethodPost, d.path+"/commands", &CommandRequest{Command: cmd, Payload: payload}, response)
+	return response.Command, err
+}
+
+// SendCommandAndListen is not supported by remote devices since the server
+// does not forward the messages received from the device.  The switch and
+// dimmer configuration, which are read this way by local devices, are
+// instead requested from the server
+func (d *Device) SendCommandAndListen(insteon.Command, []byte) (<-chan *insteon.CommandResponse, error) {
+	return nil, insteon.ErrNotImplemented
+}
+
+// Ping sends a ping request to the device
+func (d *Device) Ping() error {
+	_, err := d.SendCommand(insteon.CmdPing, nil)
+	return err
+}
+
+func (d *Device) linking(mode string, group insteon.Group) error {
+	return d.client.do(http.MethodPut, d.path+"/linking", &Linking{Mode: mode, Group: group}, nil)
+}
+
+// EnterLinkingMode puts the device into linking mode
+func (d *Device) EnterLinkingMode(group insteon.Group) error {
+	return d.linking(LinkingModeLink, group)
+}
+
+// EnterUnlinkingMode puts the device into unlinking mode
+func (d *Device) EnterUnlinkingMode(group insteon.Group) error {
+	return d.linking(LinkingModeUnlink, group)
+}
+
+// ExitLinkingMode takes the device out of linking/unlinking mode
+func (d *Device) ExitLinkingMode() error {
+	return d.linking(LinkingModeExit, 0)
+}
+
+// Links returns the device's All-Link database
+func (d *Device) Links() (links []*insteon.LinkRecord, err error) {
+	err = d.client.do(http.MethodGet, d.path+"/links", nil, &links)
+	return links, err
+}
+
+// AddLink will add the link record to the device's All-Link database
+func (d *Device) AddLink(link *insteon.LinkRecord) error {
+	return d.client.do(http.MethodPost, d.path+"/links", link, nil)
+}
+
+// RemoveLinks will remove the link records from the device's All-Link database
+func (d *Device) RemoveLinks(links ...*insteon.LinkRecord) error {
+	return d.client.do(http.MethodDelete, d.path+"/links", links, nil)
+}
+
+// UpdateLink replaces oldLink with newLink in the device's All-Link
+// database.  This makes the device an insteon.LinkUpdater so that
+// insteon.UpdateLink edits remote devices the same way as local ones
+func (d *Device) UpdateLink(oldLink, newLink *insteon.LinkRecord) error {
+	return d.client.do(http.MethodPut, d.path+"/links", &LinkUpdate{Old: oldLink, New: newLink}, nil)
+}
+
+// WriteLink is not supported by remote devices since the memory location
+// of link records is not exposed by the server.  Use UpdateLink (or
+// insteon.UpdateLink) to change an existing record
+func (d *Device) WriteLink(*insteon.LinkRecord) error {
+	return insteon.ErrNotImplemented
+}
+
+func (d *Device) switchConfig() (config insteon.SwitchConfig, err error) {
+	err = d.client.do(http.MethodGet, d.path+"/config", nil, &config)
+	return config, err
+}
+
+func (d *Device) String() string {
+	return fmt.Sprintf("Remote device (%s)", d.address)
+}
+
+type lightSwitch struct {
+	*Device
+	insteon.Switch
+}
+
+func (ls *lightSwitch) String() string { return ls.Switch.String() }
+
+// SwitchConfig requests the switch's configuration from the server
+func (ls *lightSwitch) SwitchConfig() (insteon.SwitchConfig, error) { return ls.switchConfig() }
+
+type dimmer struct {
+	*Device
+	insteon.Dimmer
+}
+
+func (dim *dimmer) String() string { return dim.Dimmer.String() }
+
+// SwitchConfig requests the dimmer's X10 configuration from the server
+func (dim *dimmer) SwitchConfig() (insteon.SwitchConfig, error) { return dim.switchConfig() }
+
+// DimmerConfig requests the dimmer's configuration from the server
+func (dim *dimmer) DimmerConfig() (config insteon.DimmerConfig, err error) {
+	err = dim.client.do(http.MethodGet, dim.path+"/dimmerconfig", nil, &config)
+	return config, err
+}
+
+// Modem returns the server's PLM.  The returned modem satisfies the
+// Modem interface
+func (c *Client) Modem() *RemoteModem {
+	return &RemoteModem{Device: &Device{client: c, path: "/plm"}}
+}
+
+// RemoteModem is the PLM connected to a remote server
+type RemoteModem struct {
+	*Device
+}
+
+// Address returns the PLM's address
+func (rm *RemoteModem) Address() insteon.Address {
+	info, err := rm.Info()
+	if err == nil {
+		return info.Address
+	}
+	return insteon.Address{}
+}
+
+// Info returns the PLM information
+func (rm *RemoteModem) Info() (*plm.Info, error) {
+	info := &plm.Info{}
+	err := rm.client.do(http.MethodGet, rm.path, nil, info)
+	return info, err
+}
+
+// Config returns the PLM configuration flags
+func (rm *RemoteModem) Config() (*plm.Config, error) {
+	config := plm.Config(0)
+	err := rm.client.do(http.MethodGet, rm.path+"/config", nil, &config)
+	return &config, err
+}
+
+// SetConfig sets the PLM configuration flags
+func (rm *RemoteModem) SetConfig(config *plm.Config) error {
+	return rm.client.do(http.MethodPut, rm.path+"/config", config, nil)
+}
+
+// Reset will factory reset the PLM
+func (rm *RemoteModem) Reset() error {
+	return rm.client.do(http.MethodPost, rm.path+"/reset", nil, nil)
+}
+
+// SendAllLinkCommand sends the command to all the responders in the group
+func (rm *RemoteModem) SendAllLinkCommand(group insteon.Group, cmd insteon.Command) error {
+	return rm.client.do(http.MethodPost, rm.path+"/alllink", &AllLinkRequest{Group: group, Command: cmd}, nil)
+}
+
+// SendCommand is not supported for the PLM
+func (rm *RemoteModem) SendCommand(insteon.Command, []byte) (insteon.Command, error) {
+	return insteon.Command{}, insteon.ErrNotImplemented
+}
+
+func (rm *RemoteModem) String() string {
+	return fmt.Sprintf("Remote PLM (%s)", rm.client.url)
+}
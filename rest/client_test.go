@@ -0,0 +1,206 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+func newTestClient() (*Client, *testModem, testNetwork, func()) {
+	server, modem, network := newTestServer()
+	httpServer := httptest.NewServer(server)
+	return NewClient(httpServer.URL + "/"), modem, network, httpServer.Close
+}
+
+func TestClientConnect(t *testing.T) {
+	client, _, network, cleanup := newTestClient()
+	defer cleanup()
+
+	tests := []struct {
+		address     insteon.Address
+		expectedErr error
+		check       func(insteon.Device) bool
+	}{
+		{insteon.Address{1, 2, 3}, nil, func(device insteon.Device) bool { _, ok := device.(insteon.Switch); return ok }},
+		{insteon.Address{4, 5, 6}, nil, func(device insteon.Device) bool { _, ok := device.(insteon.Dimmer); return ok }},
+		{insteon.Address{7, 8, 9}, nil, func(device insteon.Device) bool { _, ok := device.(insteon.Switch); return !ok }},
+		{insteon.Address{9, 9, 9}, insteon.ErrReadTimeout, nil},
+	}
+
+	for i, test := range tests {
+		device, err := client.Connect(test.address)
+		if err != test.expectedErr {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expectedErr, err)
+		} else if err == nil {
+			if !test.check(device) {
+				t.Errorf("tests[%d] unexpected device type %T", i, device)
+			}
+
+			if _, ok := device.(insteon.LinkableDevice); !ok {
+				t.Errorf("tests[%d] expected a LinkableDevice", i)
+			}
+		}
+	}
+
+	device, _ := client.Connect(insteon.Address{4, 5, 6})
+	err := device.(insteon.Dimmer).OnLevel(0x80)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	remote := network[insteon.Address{4, 5, 6}].(*testDimmer)
+	if len(remote.cmds) != 1 || remote.cmds[0] != insteon.CmdLightOn.SubCommand(0x80) {
+		t.Errorf("expected %v got %v", insteon.CmdLightOn.SubCommand(0x80), remote.cmds)
+	}
+
+	device, _ = client.Connect(insteon.Address{7, 8, 9})
+	err = device.(insteon.PingableDevice).Ping()
+	if err != insteon.ErrNak {
+		t.Errorf("expected %v got %v", insteon.ErrNak, err)
+	}
+}
+
+func TestClientLinkQuality(t *testing.T) {
+	client, _, _, cleanup := newTestClient()
+	defer cleanup()
+
+	tests := []struct {
+		address  insteon.Address
+		expected insteon.LinkQuality
+	}{
+		{insteon.Address{1, 2, 3}, insteon.LinkQuality{Address: insteon.Address{1, 2, 3}, Acks: 3, Hops: 1, MaxHops: 2}},
+		{insteon.Address{9, 9, 9}, insteon.LinkQuality{Address: insteon.Address{9, 9, 9}}},
+	}
+
+	for i, test := range tests {
+		quality, err := client.LinkQuality(test.address)
+		if err != nil {
+			t.Errorf("tests[%d] unexpected error: %v", i, err)
+		} else if quality != test.expected {
+			t.Errorf("tests[%d] expected %+v got %+v", i, test.expected, quality)
+		}
+	}
+}
+
+func TestClientLinks(t *testing.T) {
+	client, _, network, cleanup := newTestClient()
+	defer cleanup()
+
+	device, _ := client.Connect(insteon.Address{1, 2, 3})
+	linkable := device.(insteon.LinkableDevice)
+	link := &insteon.LinkRecord{Flags: 0xa2, Group: 1, Address: insteon.Address{0xaa, 0xbb, 0xcc}}
+	err := linkable.AddLink(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	links, err := linkable.Links()
+	if err != nil || len(links) != 1 || !links[0].Equal(link) {
+		t.Errorf("expected %v got %v (%v)", link, links, err)
+	}
+
+	newLink := &insteon.LinkRecord{Flags: 0xa2, Group: 2, Address: insteon.Address{0xaa, 0xbb, 0xcc}}
+	err = insteon.UpdateLink(linkable, link, newLink)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	links, err = linkable.Links()
+	if err != nil || len(links) != 1 || links[0].String() != newLink.String() {
+		t.Errorf("expected %v got %v (%v)", newLink, links, err)
+	}
+
+	err = insteon.UpdateLink(linkable, link, newLink)
+	if err != ErrLinkNotFound {
+		t.Errorf("expected %v got %v", ErrLinkNotFound, err)
+	}
+
+	err = linkable.RemoveLinks(newLink)
+	if err != nil || len(network[insteon.Address{1, 2, 3}].(*testSwitch).links) != 0 {
+		t.Errorf("expected link to be removed (%v)", err)
+	}
+
+	err = linkable.EnterLinkingMode(1)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	client, _, _, cleanup := newTestClient()
+	defer cleanup()
+
+	device, _ := client.Connect(insteon.Address{1, 2, 3})
+	config, err := device.(insteon.Switch).SwitchConfig()
+	expected := insteon.SwitchConfig{HouseCode: 0x0a, UnitCode: 0x0b}
+	if err != nil || config != expected {
+		t.Errorf("expected %+v got %+v (%v)", expected, config, err)
+	}
+
+	device, _ = client.Connect(insteon.Address{4, 5, 6})
+	dimmerConfig, err := device.(insteon.Dimmer).DimmerConfig()
+	expectedDimmer := insteon.DimmerConfig{HouseCode: 0x0a, UnitCode: 0x0b, Ramp: 0x1c, OnLevel: 0xff}
+	if err != nil || dimmerConfig != expectedDimmer {
+		t.Errorf("expected %+v got %+v (%v)", expectedDimmer, dimmerConfig, err)
+	}
+}
+
+func TestClientModem(t *testing.T) {
+	client, modem, _, cleanup := newTestClient()
+	defer cleanup()
+
+	remote := client.Modem()
+	if remote.Address() != modem.address {
+		t.Errorf("expected %v got %v", modem.address, remote.Address())
+	}
+
+	config := plm.Config(0x40)
+	err := remote.SetConfig(&config)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	got, err := remote.Config()
+	if err != nil || *got != config {
+		t.Errorf("expected %v got %v (%v)", &config, got, err)
+	}
+
+	err = remote.SendAllLinkCommand(4, insteon.CmdLightOff)
+	if err != nil || modem.group != 4 || modem.cmd != insteon.CmdLightOff {
+		t.Errorf("expected group 4 %v got %v %v (%v)", insteon.CmdLightOff, modem.group, modem.cmd, err)
+	}
+
+	link := &insteon.LinkRecord{Flags: 0xe2, Group: 1, Address: insteon.Address{1, 2, 3}}
+	modem.links = []*insteon.LinkRecord{link}
+	newLink := &insteon.LinkRecord{Flags: 0xe2, Group: 2, Address: insteon.Address{1, 2, 3}}
+	err = insteon.UpdateLink(remote, link, newLink)
+	if err != nil || len(modem.links) != 1 || modem.links[0].String() != newLink.String() {
+		t.Errorf("expected %v got %v (%v)", newLink, modem.links, err)
+	}
+
+	err = remote.Reset()
+	if err != nil || !modem.reset {
+		t.Errorf("expected modem to be reset (%v)", err)
+	}
+
+	devices, err := client.Devices()
+	if err != nil || len(devices) != 2 {
+		t.Errorf("expected 2 devices got %v (%v)", devices, err)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/abates/insteon"
+)
+
+// State is the request and response body for a device's state
+type State struct {
+	Level int `json:"level"`
+}
+
+// CommandRequest is the request body used to send an arbitrary command to
+// a device.  The payload is only included for extended commands
+type CommandRequest struct {
+	Command insteon.Command `json:"command"`
+	Payload []byte          `json:"payload,omitempty"`
+}
+
+// CommandResponse is the response body for a command and contains the
+// command bytes from the device's ACK
+type CommandResponse struct {
+	Command insteon.Command `json:"command"`
+}
+
+// Linking modes
+const (
+	LinkingModeLink   = "link"
+	LinkingModeUnlink = "unlink"
+	LinkingModeExit   = "exit"
+)
+
+// Linking is the request body used to put a device (or the PLM) into,
+// or take it out of, linking mode
+type Linking struct {
+	Mode  string        `json:"mode"`
+	Group insteon.Group `json:"group,omitempty"`
+}
+
+// LinkUpdate is the request body used to replace one link record with
+// another
+type LinkUpdate struct {
+	Old *insteon.LinkRecord `json:"old"`
+	New *insteon.LinkRecord `json:"new"`
+}
+
+// connect returns the (cached) device for the address.  Devices are cached
+// so that repeated requests do not open new network connections
+func (s *Server) connect(address insteon.Address) (insteon.Device, error) {
+	s.mutex.Lock()
+	device, found := s.devices[address]
+	s.mutex.Unlock()
+
+	if found {
+		return device, nil
+	}
+
+	device, err := s.network.Connect(address)
+	if err == nil {
+		s.mutex.Lock()
+		s.devices[address] = device
+		s.mutex.Unlock()
+	}
+	return device, err
+}
+
+func (s *Server) devicesHandler(r *http.Request, path []string) (interface{}, error) {
+	if len(path) == 0 || path[0] == "" {
+		if r.Method != http.MethodGet {
+			return nil, ErrMethodNotAllowed
+		}
+		return s.db.Devices(), nil
+	}
+
+	var address insteon.Address
+	err := address.UnmarshalText([]byte(path[0]))
+	if err != nil {
+		return nil, &requestError{err}
+	}
+
+	// link quality is available even for devices that are not responding
+	if len(path) == 2 && path[1] == "quality" {
+		return s.qualityHandler(r, address)
+	}
+
+	device, err := s.connect(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(path) == 1 {
+		if r.Method != http.MethodGet {
+			return nil, ErrMethodNotAllowed
+		}
+		info, _ := s.db.Find(address)
+		return &info, nil
+	} else if len(path) == 2 {
+		switch path[1] {
+		case "state":
+			return stateHandler(r, device)
+		case "commands":
+			return commandHandler(r, device)
+		case "config", "dimmerconfig":
+			return deviceConfigHandler(r, device, path[1])
+		case "links", "linking":
+			linkable, ok := device.(insteon.LinkableDevice)
+			if !ok {
+				return nil, ErrNotLinkable
+			} else if path[1] == "links" {
+				return linksHandler(r, linkable)
+			}
+			return linkingHandler(r, linkable)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Server) qualityHandler(r *http.Request, address insteon.Address) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, ErrMethodNotAllowed
+	}
+
+	reporter, ok := s.network.(QualityReporter)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	quality, _ := reporter.LinkQuality(address)
+	return &quality, nil
+}
+
+func stateHandler(r *http.Request, device insteon.Device) (interface{}, error) {
+	sw, ok := device.(insteon.Switch)
+	if !ok {
+		return nil, insteon.ErrNotSwitch
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		level, err := sw.Status()
+		return &State{Level: level}, err
+	case http.MethodPut:
+		state := &State{}
+		err := readJSON(r, state)
+		if err == nil {
+			err = setLevel(sw, state.Level)
+		}
+		return nil, err
+	}
+	return nil, ErrMethodNotAllowed
+}
+
+func commandHandler(r *http.Request, device insteon.Device) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, ErrMethodNotAllowed
+	}
+
+	request := &CommandRequest{}
+	err := readJSON(r, request)
+	if err == nil {
+		response := &CommandResponse{}
+		response.Command, err = device.SendCommand(request.Command, request.Payload)
+		return response, err
+	}
+	return nil, err
+}
+
+func deviceConfigHandler(r *http.Request, device insteon.Device, config string) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, ErrMethodNotAllowed
+	}
+
+	if config == "dimmerconfig" {
+		dimmer, ok := device.(insteon.Dimmer)
+		if !ok {
+			return nil, ErrNotDimmable
+		}
+		return dimmer.DimmerConfig()
+	}
+
+	sw, ok := device.(insteon.Switch)
+	if !ok {
+		return nil, insteon.ErrNotSwitch
+	}
+	return sw.SwitchConfig()
+}
+
+func setLevel(sw insteon.Switch, level int) error {
+	if level < 0 || level > 255 {
+		return insteon.ErrIllegalValue
+	}
+
+	if level == 0 {
+		return sw.Off()
+	}
+
+	if dimmer, ok := sw.(insteon.Dimmer); ok {
+		return dimmer.OnLevel(level)
+	}
+
+	if level == 255 {
+		return sw.On()
+	}
+	return ErrNotDimmable
+}
+
+func linksHandler(r *http.Request, linkable insteon.LinkableDevice) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		links, err := linkable.Links()
+		if links == nil {
+			links = []*insteon.LinkRecord{}
+		}
+		return links, err
+	case http.MethodPost:
+		link := &insteon.LinkRecord{}
+		err := readJSON(r, link)
+		if err == nil {
+			err = linkable.AddLink(link)
+		}
+		return nil, err
+	case http.MethodPut:
+		update := &LinkUpdate{}
+		err := readJSON(r, update)
+		if err == nil {
+			if update.Old == nil || update.New == nil {
+				err = &requestError{ErrMissingLink}
+			} else {
+				err = updateLink(linkable, update.Old, update.New)
+			}
+		}
+		return nil, err
+	case http.MethodDelete:
+		links := []*insteon.LinkRecord{}
+		err := readJSON(r, &links)
+		if err == nil {
+			err = linkable.RemoveLinks(links...)
+		}
+		return nil, err
+	}
+	return nil, ErrMethodNotAllowed
+}
+
+// updateLink replaces oldLink with newLink.  The records sent by a client
+// don't include their location in the database, so unless the device
+// updates records itself the first stored record with the same contents
+// is the one that gets replaced
+func updateLink(linkable insteon.LinkableDevice, oldLink, newLink *insteon.LinkRecord) error {
+	if _, ok := linkable.(insteon.LinkUpdater); ok {
+		return insteon.UpdateLink(linkable, oldLink, newLink)
+	}
+
+	links, err := linkable.Links()
+	if err != nil {
+		return err
+	}
+
+	// records are compared by their text form since that is all that
+	// the client sends
+	want, _ := oldLink.MarshalText()
+	for _, link := range links {
+		if text, _ := link.MarshalText(); bytes.Equal(text, want) {
+			return insteon.UpdateLink(linkable, link, newLink)
+		}
+	}
+	return ErrLinkNotFound
+}
+
+func linkingHandler(r *http.Request, linkable insteon.LinkableDevice) (interface{}, error) {
+	if r.Method != http.MethodPut {
+		return nil, ErrMethodNotAllowed
+	}
+
+	linking := &Linking{}
+	err := readJSON(r, linking)
+	if err == nil {
+		switch linking.Mode {
+		case LinkingModeLink:
+			err = linkable.EnterLinkingMode(linking.Group)
+		case LinkingModeUnlink:
+			err = linkable.EnterUnlinkingMode(linking.Group)
+		case LinkingModeExit:
+			err = linkable.ExitLinkingMode()
+		default:
+			err = &requestError{fmt.Errorf("unknown linking mode %q", linking.Mode)}
+		}
+	}
+	return nil, err
+}
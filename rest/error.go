@@ -0,0 +1,122 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+var (
+	// ErrNotFound is returned when the requested resource does not exist
+	ErrNotFound = errors.New("Resource not found")
+
+	// ErrMethodNotAllowed is returned when a resource does not support the
+	// request method
+	ErrMethodNotAllowed = errors.New("Method not allowed")
+
+	// ErrNotLinkable is returned when link database operations are requested
+	// for a device that does not have a link database
+	ErrNotLinkable = errors.New("Device does not have an All-Link database")
+
+	// ErrNotDimmable is returned when a level other than fully on or off is
+	// requested for a device that is not a dimmer
+	ErrNotDimmable = errors.New("Device only supports levels 0 and 255")
+
+	// ErrLinkNotFound is returned when a link record that is to be updated
+	// is not in the device's All-Link database
+	ErrLinkNotFound = errors.New("Link record not found")
+
+	// ErrMissingLink is returned when a link update does not include both
+	// the old and the new record
+	ErrMissingLink = errors.New("Both the old and new link records are required")
+)
+
+// knownErrors are the errors that a Client can recreate from the message
+// in an ErrorResponse
+var knownErrors = []error{
+	ErrNotFound,
+	ErrMethodNotAllowed,
+	ErrNotLinkable,
+	ErrNotDimmable,
+	ErrLinkNotFound,
+	ErrMissingLink,
+	insteon.ErrNotSwitch,
+	insteon.ErrAddrFormat,
+	insteon.ErrIllegalValue,
+	insteon.ErrNotLinked,
+	insteon.ErrNoSceneGroup,
+	insteon.ErrNoFreeGroup,
+	insteon.ErrAlreadyLinked,
+	insteon.ErrReadTimeout,
+	insteon.ErrNak,
+	insteon.ErrUnexpectedResponse,
+	insteon.ErrIncorrectChecksum,
+	insteon.ErrNoLoadDetected,
+	insteon.ErrUnknownCommand,
+	insteon.ErrUnknown,
+	insteon.ErrPreNak,
+	insteon.ErrNotImplemented,
+	insteon.ErrVersion,
+	insteon.ErrLinkDBFull,
+	plm.ErrReadTimeout,
+	plm.ErrAckTimeout,
+	plm.ErrRetryCountExceeded,
+	plm.ErrNak,
+	plm.ErrNotImplemented,
+	plm.ErrLinkNotFound,
+	plm.ErrLinkConflict,
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// requestError indicates the request itself was malformed
+type requestError struct {
+	cause error
+}
+
+func (re *requestError) Error() string { return re.cause.Error() }
+
+// StatusCode maps errors returned by the insteon and plm packages
+// to an HTTP status code.  Wrapped errors are mapped by their cause
+func StatusCode(err error) int {
+	if _, ok := err.(*requestError); ok {
+		return http.StatusBadRequest
+	}
+
+	switch insteon.Cause(err) {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case ErrNotLinkable, ErrNotDimmable, insteon.ErrNotSwitch, insteon.ErrAddrFormat, insteon.ErrIllegalValue:
+		return http.StatusBadRequest
+	case insteon.ErrNotLinked, insteon.ErrNoSceneGroup, insteon.ErrNoFreeGroup, insteon.ErrAlreadyLinked, insteon.ErrLinkDBFull, ErrLinkNotFound, plm.ErrLinkNotFound, plm.ErrLinkConflict:
+		return http.StatusConflict
+	case insteon.ErrReadTimeout, plm.ErrReadTimeout, plm.ErrAckTimeout, plm.ErrRetryCountExceeded:
+		return http.StatusGatewayTimeout
+	case insteon.ErrNak, plm.ErrNak, insteon.ErrUnexpectedResponse, insteon.ErrIncorrectChecksum, insteon.ErrNoLoadDetected, insteon.ErrUnknownCommand, insteon.ErrUnknown, insteon.ErrPreNak:
+		return http.StatusBadGateway
+	case insteon.ErrNotImplemented, plm.ErrNotImplemented, insteon.ErrVersion:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"net/http"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+// AllLinkRequest is the request body used to send a command to every
+// responder in one of the PLM's groups
+type AllLinkRequest struct {
+	Group   insteon.Group   `json:"group"`
+	Command insteon.Command `json:"command"`
+}
+
+func (s *Server) plmHandler(r *http.Request, path []string) (interface{}, error) {
+	if len(path) == 0 || path[0] == "" {
+		if r.Method != http.MethodGet {
+			return nil, ErrMethodNotAllowed
+		}
+		return s.modem.Info()
+	}
+
+	if len(path) == 1 {
+		switch path[0] {
+		case "config":
+			return s.configHandler(r)
+		case "links":
+			return linksHandler(r, s.modem)
+		case "linking":
+			return linkingHandler(r, s.modem)
+		case "alllink":
+			if r.Method != http.MethodPost {
+				return nil, ErrMethodNotAllowed
+			}
+
+			request := &AllLinkRequest{}
+			err := readJSON(r, request)
+			if err == nil {
+				err = s.modem.SendAllLinkCommand(request.Group, request.Command)
+			}
+			return nil, err
+		case "reset":
+			if r.Method != http.MethodPost {
+				return nil, ErrMethodNotAllowed
+			}
+			return nil, s.modem.Reset()
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Server) configHandler(r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		return s.modem.Config()
+	case http.MethodPut:
+		config := plm.Config(0)
+		err := readJSON(r, &config)
+		if err == nil {
+			err = s.modem.SetConfig(&config)
+		}
+		return nil, err
+	}
+	return nil, ErrMethodNotAllowed
+}
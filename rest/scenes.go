@@ -0,0 +1,194 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"net/http"
+
+	"github.com/abates/insteon"
+)
+
+// SetScenes replaces the scenes known to the server.  This is usually
+// called once at startup with scenes loaded from disk
+func (s *Server) SetScenes(scenes []*insteon.Scene) {
+	s.mutex.Lock()
+	s.scenes = make([]*insteon.Scene, len(scenes))
+	for i, scene := range scenes {
+		s.scenes[i] = copyScene(scene)
+	}
+	s.mutex.Unlock()
+}
+
+// Scenes returns copies of the scenes known to the server
+func (s *Server) Scenes() []*insteon.Scene {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	scenes := make([]*insteon.Scene, len(s.scenes))
+	for i, scene := range s.scenes {
+		scenes[i] = copyScene(scene)
+	}
+	return scenes
+}
+
+// copyScene returns a copy of the scene that does not share its members
+func copyScene(scene *insteon.Scene) *insteon.Scene {
+	c := *scene
+	c.Members = append([]insteon.SceneMember(nil), scene.Members...)
+	return &c
+}
+
+// findScene returns a copy of the named scene, or nil if there is no
+// such scene
+func (s *Server) findScene(name string) *insteon.Scene {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, scene := range s.scenes {
+		if scene.Name == name {
+			return copyScene(scene)
+		}
+	}
+	return nil
+}
+
+// storeScene stores a copy of the scene in place of the scene with the
+// same name, or appends it if there is no such scene
+func (s *Server) storeScene(scene *insteon.Scene) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, candidate := range s.scenes {
+		if candidate.Name == scene.Name {
+			s.scenes[i] = copyScene(scene)
+			return
+		}
+	}
+	s.scenes = append(s.scenes, copyScene(scene))
+}
+
+// removeScene removes the named scene
+func (s *Server) removeScene(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, candidate := range s.scenes {
+		if candidate.Name == name {
+			s.scenes = append(s.scenes[0:i], s.scenes[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Server) saveScenes() error {
+	if s.SaveScenes == nil {
+		return nil
+	}
+	return s.SaveScenes(s.Scenes())
+}
+
+// connectLinkable is used as the connect function for scene operations
+func (s *Server) connectLinkable(address insteon.Address) (insteon.LinkableDevice, error) {
+	device, err := s.connect(address)
+	if err == nil {
+		if linkable, ok := device.(insteon.LinkableDevice); ok {
+			return linkable, nil
+		}
+		err = ErrNotLinkable
+	}
+	return nil, err
+}
+
+func (s *Server) scenesHandler(r *http.Request, path []string) (interface{}, error) {
+	if len(path) == 0 || path[0] == "" {
+		if r.Method != http.MethodGet {
+			return nil, ErrMethodNotAllowed
+		}
+		return s.Scenes(), nil
+	}
+
+	if len(path) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			scene := s.findScene(path[0])
+			if scene == nil {
+				return nil, ErrNotFound
+			}
+			return scene, nil
+		case http.MethodPut:
+			return nil, s.putScene(r, path[0])
+		case http.MethodDelete:
+			return nil, s.deleteScene(path[0])
+		}
+		return nil, ErrMethodNotAllowed
+	}
+
+	if len(path) == 2 && (path[1] == "on" || path[1] == "off") {
+		if r.Method != http.MethodPost {
+			return nil, ErrMethodNotAllowed
+		}
+
+		scene := s.findScene(path[0])
+		if scene == nil {
+			return nil, ErrNotFound
+		}
+
+		cmd := insteon.CmdLightOn
+		if path[1] == "off" {
+			cmd = insteon.CmdLightOff
+		}
+		return nil, insteon.TriggerScene(scene, s.modem, cmd)
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Server) putScene(r *http.Request, name string) error {
+	scene := &insteon.Scene{}
+	err := readJSON(r, scene)
+	if err != nil {
+		return err
+	}
+
+	s.sceneMutex.Lock()
+	defer s.sceneMutex.Unlock()
+
+	scene.Name = name
+	if existing := s.findScene(name); existing == nil {
+		scene.Group = 0
+		err = insteon.CreateScene(scene, s.modem, s.connectLinkable)
+	} else {
+		scene.Group = existing.Group
+		err = insteon.EditScene(scene, s.modem, s.connectLinkable)
+	}
+
+	if err == nil {
+		s.storeScene(scene)
+		err = s.saveScenes()
+	}
+	return err
+}
+
+func (s *Server) deleteScene(name string) error {
+	s.sceneMutex.Lock()
+	defer s.sceneMutex.Unlock()
+
+	scene := s.findScene(name)
+	if scene == nil {
+		return ErrNotFound
+	}
+
+	err := insteon.DeleteScene(scene, s.modem, s.connectLinkable)
+	if err == nil {
+		s.removeScene(name)
+		err = s.saveScenes()
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rest provides an HTTP/JSON interface to an Insteon network.  The
+// Server owns the connection to the modem so that multiple clients can
+// share a single PLM.  The following resources are provided:
+//
+//	GET    /devices                    list the devices in the product database
+//	GET    /devices/<address>          device information
+//	GET    /devices/<address>/state    current level {"level": 255}
+//	PUT    /devices/<address>/state    change the level {"level": 128}
+//	POST   /devices/<address>/commands send a command {"command": [0, 17, 255]}
+//	GET    /devices/<address>/links    read the All-Link database
+//	POST   /devices/<address>/links    add a link record
+//	PUT    /devices/<address>/links    replace a link record {"old": "UR 1 01.02.03 00 1c 01", "new": "UR 2 01.02.03 00 1c 01"}
+//	DELETE /devices/<address>/links    remove one or more link records
+//	PUT    /devices/<address>/linking  change linking mode {"mode": "link", "group": 1}
+//	GET    /devices/<address>/quality  hops, retries and latency of messages to the device
+//	GET    /devices/<address>/config   X10 address of a switch or dimmer
+//	GET    /devices/<address>/dimmerconfig X10 address, default ramp and on level of a dimmer
+//	GET    /plm                        PLM information
+//	GET    /plm/config                 PLM configuration flags
+//	PUT    /plm/config                 set the PLM configuration flags
+//	GET    /plm/links                  read the PLM All-Link database
+//	POST   /plm/links                  add a link record to the PLM
+//	PUT    /plm/links                  replace a link record in the PLM
+//	DELETE /plm/links                  remove link records from the PLM
+//	PUT    /plm/linking                change the PLM linking mode
+//	POST   /plm/alllink                send a group command {"group": 1, "command": [0, 17, 255]}
+//	POST   /plm/reset                  factory reset the PLM
+//	GET    /scenes                     list scenes
+//	GET    /scenes/<name>              scene definition
+//	PUT    /scenes/<name>              create or update a scene
+//	DELETE /scenes/<name>              delete a scene
+//	POST   /scenes/<name>/on           turn a scene on
+//	POST   /scenes/<name>/off          turn a scene off
+//
+// Link records are represented using the LinkRecord text format, for
+// instance "UR 1 01.02.03 00 1c 01".  Errors are returned with an
+// appropriate HTTP status code and a body of the form {"error": "message"}
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+// Modem is the local Insteon interface (usually a *plm.PLM) that the
+// server uses to manage the network
+type Modem interface {
+	insteon.LinkableDevice
+	insteon.AllLinkCommander
+
+	// Info returns the modem's address, category and firmware version
+	Info() (*plm.Info, error)
+
+	// Config returns the modem's configuration flags
+	Config() (*plm.Config, error)
+
+	// SetConfig changes the modem's configuration flags
+	SetConfig(config *plm.Config) error
+
+	// Reset erases the modem's link database and restores the
+	// factory defaults
+	Reset() error
+}
+
+// Network is used to connect to devices.  *insteon.Network satisfies
+// this interface
+type Network interface {
+	Connect(address insteon.Address) (insteon.Device, error)
+}
+
+// QualityReporter is implemented by networks that collect link quality
+// for devices.  *insteon.Network satisfies this interface
+type QualityReporter interface {
+	LinkQuality(address insteon.Address) (insteon.LinkQuality, bool)
+}
+
+// Server is an http.Handler that exposes an Insteon network
+type Server struct {
+	modem   Modem
+	network Network
+	db      insteon.ProductDatabase
+
+	mutex   sync.Mutex
+	devices map[insteon.Address]insteon.Device
+	scenes  []*insteon.Scene
+
+	// sceneMutex serializes changes to the scenes, which program the
+	// devices and must not be interleaved
+	sceneMutex sync.Mutex
+
+	// SaveScenes, if set, is called with the complete list of scenes
+	// every time a scene is created, updated or deleted
+	SaveScenes func(scenes []*insteon.Scene) error
+}
+
+// New creates a server for the given modem and network.  The product
+// database is used to list the known devices
+func New(modem Modem, network Network, db insteon.ProductDatabase) *Server {
+	return &Server{
+		modem:   modem,
+		network: network,
+		db:      db,
+		devices: make(map[insteon.Address]insteon.Device),
+	}
+}
+
+// handlerFunc is called with the remaining path elements once the
+// resource has been determined.  The returned value is encoded as
+// the JSON response body
+type handlerFunc func(r *http.Request, path []string) (interface{}, error)
+
+// ServeHTTP routes the request to the appropriate resource
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var handler handlerFunc
+	switch path[0] {
+	case "devices":
+		handler = s.devicesHandler
+	case "plm":
+		handler = s.plmHandler
+	case "scenes":
+		handler = s.scenesHandler
+	default:
+		handler = func(*http.Request, []string) (interface{}, error) { return nil, ErrNotFound }
+	}
+
+	resp, err := handler(r, path[1:])
+	if err == nil {
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			writeJSON(w, http.StatusOK, resp)
+		}
+	} else {
+		insteon.Log.Debugf("%s %s failed: %v", r.Method, r.URL.Path, err)
+		writeJSON(w, StatusCode(err), &ErrorResponse{Error: err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		err = &requestError{err}
+	}
+	return err
+}
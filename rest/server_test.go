@@ -0,0 +1,341 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+// testLinkable is an in-memory link database
+type testLinkable struct {
+	address insteon.Address
+	links   []*insteon.LinkRecord
+}
+
+func (tl *testLinkable) Address() insteon.Address                 { return tl.address }
+func (tl *testLinkable) EnterLinkingMode(insteon.Group) error     { return nil }
+func (tl *testLinkable) EnterUnlinkingMode(insteon.Group) error   { return nil }
+func (tl *testLinkable) ExitLinkingMode() error                   { return nil }
+func (tl *testLinkable) Links() ([]*insteon.LinkRecord, error)    { return tl.links, nil }
+func (tl *testLinkable) WriteLink(link *insteon.LinkRecord) error { return nil }
+func (tl *testLinkable) AddLink(newLink *insteon.LinkRecord) error {
+	tl.links = append(tl.links, newLink)
+	return nil
+}
+func (tl *testLinkable) RemoveLinks(oldLinks ...*insteon.LinkRecord) error {
+	links := []*insteon.LinkRecord{}
+	for _, link := range tl.links {
+		remove := false
+		for _, oldLink := range oldLinks {
+			remove = remove || oldLink.Equal(link)
+		}
+
+		if !remove {
+			links = append(links, link)
+		}
+	}
+	tl.links = links
+	return nil
+}
+
+type testModem struct {
+	testLinkable
+	config plm.Config
+	group  insteon.Group
+	cmd    insteon.Command
+	reset  bool
+}
+
+func (tm *testModem) Reset() error {
+	tm.reset = true
+	return nil
+}
+
+func (tm *testModem) Info() (*plm.Info, error) {
+	return &plm.Info{Address: tm.address, DevCat: insteon.DevCat{0x03, 0x15}, Firmware: 0x9e}, nil
+}
+
+func (tm *testModem) Config() (*plm.Config, error) { return &tm.config, nil }
+
+func (tm *testModem) SetConfig(config *plm.Config) error {
+	tm.config = *config
+	return nil
+}
+
+func (tm *testModem) SendAllLinkCommand(group insteon.Group, cmd insteon.Command) error {
+	tm.group = group
+	tm.cmd = cmd
+	return nil
+}
+
+// testSwitch is a Switch and LinkableDevice.  Only the On, Off and Status
+// Switch methods are implemented
+type testSwitch struct {
+	insteon.Switch
+	testLinkable
+	level int
+	cmds  []insteon.Command
+	err   error
+}
+
+func (ts *testSwitch) SendCommand(cmd insteon.Command, payload []byte) (insteon.Command, error) {
+	ts.cmds = append(ts.cmds, cmd)
+	return cmd, ts.err
+}
+
+func (ts *testSwitch) SendCommandAndListen(insteon.Command, []byte) (<-chan *insteon.CommandResponse, error) {
+	return nil, insteon.ErrNotImplemented
+}
+
+func (ts *testSwitch) On() error                { ts.level = 255; return ts.err }
+func (ts *testSwitch) Off() error               { ts.level = 0; return ts.err }
+func (ts *testSwitch) Status() (int, error)     { return ts.level, ts.err }
+func (ts *testSwitch) Address() insteon.Address { return ts.address }
+func (ts *testSwitch) SwitchConfig() (insteon.SwitchConfig, error) {
+	return insteon.SwitchConfig{HouseCode: 0x0a, UnitCode: 0x0b}, ts.err
+}
+
+type testDimmer struct {
+	insteon.Dimmer
+	*testSwitch
+}
+
+func (td *testDimmer) On() error                { return td.testSwitch.On() }
+func (td *testDimmer) Off() error               { return td.testSwitch.Off() }
+func (td *testDimmer) Status() (int, error)     { return td.testSwitch.Status() }
+func (td *testDimmer) OnLevel(level int) error  { td.level = level; return td.err }
+func (td *testDimmer) Address() insteon.Address { return td.address }
+func (td *testDimmer) SwitchConfig() (insteon.SwitchConfig, error) {
+	return td.testSwitch.SwitchConfig()
+}
+func (td *testDimmer) DimmerConfig() (insteon.DimmerConfig, error) {
+	return insteon.DimmerConfig{HouseCode: 0x0a, UnitCode: 0x0b, Ramp: 0x1c, OnLevel: 0xff}, td.err
+}
+
+type testNetwork map[insteon.Address]insteon.Device
+
+func (tn testNetwork) Connect(address insteon.Address) (insteon.Device, error) {
+	if device, found := tn[address]; found {
+		return device, nil
+	}
+	return nil, insteon.ErrReadTimeout
+}
+
+// LinkQuality reports three ACKs for every device in the network
+func (tn testNetwork) LinkQuality(address insteon.Address) (insteon.LinkQuality, bool) {
+	if _, found := tn[address]; found {
+		return insteon.LinkQuality{Address: address, Acks: 3, Hops: 1, MaxHops: 2}, true
+	}
+	return insteon.LinkQuality{Address: address}, false
+}
+
+func newTestServer() (*Server, *testModem, testNetwork) {
+	modem := &testModem{testLinkable: testLinkable{address: insteon.Address{0xaa, 0xbb, 0xcc}}}
+	sw := &testSwitch{testLinkable: testLinkable{address: insteon.Address{1, 2, 3}}}
+	dimmer := &testDimmer{testSwitch: &testSwitch{testLinkable: testLinkable{address: insteon.Address{4, 5, 6}}}}
+	failing := &testSwitch{testLinkable: testLinkable{address: insteon.Address{7, 8, 9}}, err: insteon.ErrNak}
+
+	network := testNetwork{
+		sw.address:      sw,
+		dimmer.address:  dimmer,
+		failing.address: failing,
+	}
+
+	db := insteon.NewProductDB()
+	db.UpdateEngineVersion(sw.address, insteon.VerI2)
+	db.UpdateDevCat(sw.address, insteon.DevCat{0x02, 0x2a})
+	db.UpdateDevCat(dimmer.address, insteon.DevCat{0x01, 0x20})
+	return New(modem, network, db), modem, network
+}
+
+func request(server *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		method       string
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"GET", "/devices", "", 200, `[{"address":"01.02.03","devCat":"02.2a","firmwareVersion":0,"engineVersion":1},{"address":"04.05.06","devCat":"01.20","firmwareVersion":0,"engineVersion":0}]`},
+		{"POST", "/devices", "", 405, `{"error":"Method not allowed"}`},
+		{"GET", "/devices/01.02.03", "", 200, `{"address":"01.02.03","devCat":"02.2a","firmwareVersion":0,"engineVersion":1}`},
+		{"GET", "/devices/01.02", "", 400, `{"error":"address format is xx.xx.xx (digits in hex)"}`},
+		{"GET", "/devices/0a.0b.0c", "", 504, `{"error":"Read Timeout"}`},
+		{"GET", "/devices/01.02.03/foo", "", 404, `{"error":"Resource not found"}`},
+		{"PUT", "/devices/01.02.03/state", `{"level":255}`, 204, ``},
+		{"GET", "/devices/01.02.03/state", "", 200, `{"level":255}`},
+		{"PUT", "/devices/01.02.03/state", `{"level":128}`, 400, `{"error":"Device only supports levels 0 and 255"}`},
+		{"PUT", "/devices/01.02.03/state", `{"level":`, 400, `{"error":"unexpected EOF"}`},
+		{"PUT", "/devices/04.05.06/state", `{"level":128}`, 204, ``},
+		{"GET", "/devices/04.05.06/state", "", 200, `{"level":128}`},
+		{"PUT", "/devices/04.05.06/state", `{"level":0}`, 204, ``},
+		{"GET", "/devices/04.05.06/state", "", 200, `{"level":0}`},
+		{"GET", "/devices/07.08.09/state", "", 502, `{"error":"NAK received"}`},
+		{"GET", "/devices/01.02.03/links", "", 200, `[]`},
+		{"POST", "/devices/01.02.03/links", `"UR 1 aa.bb.cc 00 1c 01"`, 204, ``},
+		{"GET", "/devices/01.02.03/links", "", 200, `["UR        1 aa.bb.cc   00 1c 01"]`},
+		{"PUT", "/devices/01.02.03/links", `{"old":"UR 1 aa.bb.cc 00 1c 01","new":"UR 1 aa.bb.cc 00 1c 02"}`, 204, ``},
+		{"GET", "/devices/01.02.03/links", "", 200, `["UR        1 aa.bb.cc   00 1c 02"]`},
+		{"PUT", "/devices/01.02.03/links", `{"old":"UR 2 aa.bb.cc 00 1c 02","new":"UR 3 aa.bb.cc 00 1c 02"}`, 409, `{"error":"Link record not found"}`},
+		{"PUT", "/devices/01.02.03/links", `{"new":"UR 3 aa.bb.cc 00 1c 02"}`, 400, `{"error":"Both the old and new link records are required"}`},
+		{"DELETE", "/devices/01.02.03/links", `["UR 1 aa.bb.cc 00 00 00"]`, 204, ``},
+		{"GET", "/devices/01.02.03/links", "", 200, `[]`},
+		{"GET", "/devices/01.02.03/config", "", 200, `{"houseCode":10,"unitCode":11}`},
+		{"GET", "/devices/04.05.06/config", "", 200, `{"houseCode":10,"unitCode":11}`},
+		{"GET", "/devices/04.05.06/dimmerconfig", "", 200, `{"houseCode":10,"unitCode":11,"ramp":28,"onLevel":255,"snt":0}`},
+		{"GET", "/devices/01.02.03/dimmerconfig", "", 400, `{"error":"Device only supports levels 0 and 255"}`},
+		{"PUT", "/devices/01.02.03/config", "", 405, `{"error":"Method not allowed"}`},
+		{"GET", "/plm", "", 200, `{"address":"aa.bb.cc","devCat":"03.15","firmware":158}`},
+		{"GET", "/plm/config", "", 200, `{"automaticLinking":false,"monitorMode":false,"automaticLED":false,"deadmanMode":false}`},
+		{"PUT", "/plm/config", `{"monitorMode":true}`, 204, ``},
+		{"GET", "/plm/config", "", 200, `{"automaticLinking":false,"monitorMode":true,"automaticLED":false,"deadmanMode":false}`},
+		{"GET", "/plm/links", "", 200, `[]`},
+		{"POST", "/devices/01.02.03/commands", `{"command":[0,15,0]}`, 200, `{"command":[0,15,0]}`},
+		{"PUT", "/devices/01.02.03/linking", `{"mode":"link","group":1}`, 204, ``},
+		{"PUT", "/devices/01.02.03/linking", `{"mode":"foo"}`, 400, `{"error":"unknown linking mode \"foo\""}`},
+		{"PUT", "/plm/linking", `{"mode":"exit"}`, 204, ``},
+		{"POST", "/plm/alllink", `{"group":3,"command":[0,19,0]}`, 204, ``},
+		{"GET", "/plm/reset", "", 405, `{"error":"Method not allowed"}`},
+		{"POST", "/plm/reset", "", 204, ``},
+		{"GET", "/foo", "", 404, `{"error":"Resource not found"}`},
+	}
+
+	server, _, _ := newTestServer()
+	for i, test := range tests {
+		w := request(server, test.method, test.path, test.body)
+		if w.Code != test.expectedCode {
+			t.Errorf("tests[%d] %s %s expected status %d got %d", i, test.method, test.path, test.expectedCode, w.Code)
+		}
+
+		body := strings.TrimSpace(w.Body.String())
+		if body != test.expectedBody {
+			t.Errorf("tests[%d] %s %s expected body %s got %s", i, test.method, test.path, test.expectedBody, body)
+		}
+	}
+}
+
+func TestServerScenes(t *testing.T) {
+	server, modem, network := newTestServer()
+	saved := []*insteon.Scene{}
+	server.SaveScenes = func(scenes []*insteon.Scene) error {
+		saved = scenes
+		return nil
+	}
+
+	w := request(server, "PUT", "/scenes/movie", `{"members":[{"address":"01.02.03","level":128}]}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	if len(saved) != 1 || saved[0].Name != "movie" || saved[0].Group != 1 {
+		t.Errorf("expected saved scene movie with group 1 got %v", saved)
+	}
+
+	sw := network[insteon.Address{1, 2, 3}].(*testSwitch)
+	if len(modem.links) != 1 || len(sw.links) != 1 || sw.links[0].Data[0] != 128 {
+		t.Errorf("expected scene links to be created, got %v and %v", modem.links, sw.links)
+	}
+
+	w = request(server, "GET", "/scenes/movie", "")
+	expected := `{"name":"movie","group":1,"controller":"aa.bb.cc","members":[{"address":"01.02.03","level":128}]}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("expected %s got %s", expected, body)
+	}
+
+	w = request(server, "POST", "/scenes/movie/off", "")
+	if w.Code != http.StatusNoContent || modem.group != 1 || modem.cmd != insteon.CmdLightOff {
+		t.Errorf("expected scene to be triggered, got status %d group %v cmd %v", w.Code, modem.group, modem.cmd)
+	}
+
+	w = request(server, "PUT", "/scenes/movie", `{"members":[{"address":"04.05.06","level":255}]}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	if len(sw.links) != 0 || saved[0].Members[0].Address != (insteon.Address{4, 5, 6}) {
+		t.Errorf("expected scene to be updated, got %v", saved[0])
+	}
+
+	w = request(server, "DELETE", "/scenes/movie", "")
+	if w.Code != http.StatusNoContent || len(saved) != 0 || len(modem.links) != 0 {
+		t.Errorf("expected scene to be deleted, got status %d scenes %v links %v", w.Code, saved, modem.links)
+	}
+
+	w = request(server, "POST", "/scenes/movie/on", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestServerConcurrentScenes(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			request(server, "PUT", "/scenes/movie", `{"members":[{"address":"01.02.03","level":128}]}`)
+		}()
+		go func() {
+			defer wg.Done()
+			request(server, "GET", "/scenes/movie", "")
+		}()
+	}
+	wg.Wait()
+
+	if scenes := server.Scenes(); len(scenes) != 1 {
+		t.Errorf("expected 1 scene got %d", len(scenes))
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		input    error
+		expected int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{&requestError{errors.New("bad")}, http.StatusBadRequest},
+		{insteon.ErrNotLinked, http.StatusConflict},
+		{insteon.ErrReadTimeout, http.StatusGatewayTimeout},
+		{plm.ErrAckTimeout, http.StatusGatewayTimeout},
+		{insteon.ErrNak, http.StatusBadGateway},
+		{insteon.ErrNotImplemented, http.StatusNotImplemented},
+		{errors.New("other"), http.StatusInternalServerError},
+		{&insteon.BufError{Cause: insteon.ErrUnexpectedResponse}, http.StatusBadGateway},
+		{&insteon.BufError{Cause: insteon.ErrReadTimeout}, http.StatusGatewayTimeout},
+	}
+
+	for i, test := range tests {
+		if code := StatusCode(test.input); code != test.expected {
+			t.Errorf("tests[%d] expected %d got %d", i, test.expected, code)
+		}
+	}
+}
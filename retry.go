@@ -0,0 +1,56 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+// RetryPolicy controls how direct messages are resent when the device
+// does not acknowledge them
+type RetryPolicy struct {
+	// Retries is the number of times a message is resent after the
+	// first attempt fails with a retriable error
+	Retries int
+
+	// MaxHops is the upper limit for the number of hops used by resent
+	// messages.  Every retry uses one more hop than the previous attempt
+	// until MaxHops is reached
+	MaxHops int
+}
+
+// DefaultRetryPolicy is the retry policy used by new networks
+var DefaultRetryPolicy = RetryPolicy{Retries: 2, MaxHops: 3}
+
+// hops returns the flags to use for the next attempt to send a message
+// that was previously sent with flags
+func (rp RetryPolicy) hops(flags Flags) Flags {
+	hops := flags.MaxTTL() + 1
+	if hops > rp.MaxHops {
+		hops = rp.MaxHops
+	}
+
+	if hops < flags.MaxTTL() {
+		return flags
+	}
+	return flags.setHops(hops)
+}
+
+// Retriable returns true if err indicates that a message was lost or
+// temporarily rejected and may succeed if it is resent.  Errors such as
+// ErrNotLinked and ErrUnknownCommand are permanent and will not be retried
+func Retriable(err error) bool {
+	switch Cause(err) {
+	case ErrReadTimeout, ErrPreNak, ErrIncorrectChecksum:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyHops(t *testing.T) {
+	tests := []struct {
+		policy   RetryPolicy
+		input    Flags
+		expected Flags
+	}{
+		{RetryPolicy{MaxHops: 3}, StandardDirectMessage, Flags(0x0f)},
+		{RetryPolicy{MaxHops: 3}, Flags(0x0f), Flags(0x0f)},
+		{RetryPolicy{MaxHops: 3}, Flags(0x1a), Flags(0x1f)},
+		{RetryPolicy{MaxHops: 1}, StandardDirectMessage, StandardDirectMessage},
+		{RetryPolicy{MaxHops: 2}, Flags(0x00), Flags(0x05)},
+	}
+
+	for i, test := range tests {
+		if got := test.policy.hops(test.input); got != test.expected {
+			t.Errorf("tests[%d] expected %02x got %02x", i, byte(test.expected), byte(got))
+		}
+	}
+}
+
+func TestRetriable(t *testing.T) {
+	tests := []struct {
+		input    error
+		expected bool
+	}{
+		{ErrReadTimeout, true},
+		{ErrPreNak, true},
+		{ErrIncorrectChecksum, true},
+		{ErrNotLinked, false},
+		{ErrUnknownCommand, false},
+		{ErrNoLoadDetected, false},
+		{newTraceError(ErrUnexpectedResponse), false},
+	}
+
+	for _, test := range tests {
+		if got := Retriable(test.input); got != test.expected {
+			t.Errorf("%v: expected %v got %v", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestConnectionRetry(t *testing.T) {
+	ack := &Message{Src: testDstAddr, Flags: StandardDirectAck, Command: Command{0x00, 0x00, 0x00}}
+	tests := []struct {
+		desc             string
+		retries          int
+		responses        []*Message // nil is a lost ACK
+		expectedErr      error
+		expectedAttempts int
+		expectedFlags    Flags
+	}{
+		{"ack", 2, []*Message{ack}, nil, 1, StandardDirectMessage},
+		{"timeout then ack", 2, []*Message{nil, ack}, nil, 2, Flags(0x0f)},
+		{"pre nak then ack", 2, []*Message{TestMessagePreNak, ack}, nil, 2, Flags(0x0f)},
+		{"not linked", 2, []*Message{TestMessageNotLinkedI2Cs}, ErrNotLinked, 1, StandardDirectMessage},
+		{"retries exceeded", 1, []*Message{nil, nil}, ErrReadTimeout, 2, Flags(0x0f)},
+		{"no retries", 0, []*Message{TestMessagePreNak}, ErrPreNak, 1, StandardDirectMessage},
+	}
+
+	for _, test := range tests {
+		observer := &testObserver{}
+		SetObserver(observer)
+
+		upstreamSendCh := make(chan *MessageRequest, 1)
+		upstreamRecvCh := make(chan *Message, 1)
+		conn := newConnection(upstreamSendCh, upstreamRecvCh, testDstAddr, VerI2Cs, 10*time.Millisecond, RetryPolicy{Retries: test.retries, MaxHops: 3}, nil)
+
+		doneCh := make(chan *MessageRequest, 1)
+		request := &MessageRequest{Message: &Message{Flags: StandardDirectMessage, Command: Command{0x00, 0x00, 0x00}}, DoneCh: doneCh}
+		conn.sendCh <- request
+
+		for _, response := range test.responses {
+			upstreamRequest := <-upstreamSendCh
+			upstreamRequest.DoneCh <- upstreamRequest
+			if response != nil {
+				upstreamRecvCh <- response
+			}
+		}
+
+		select {
+		case <-doneCh:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timed out waiting for request to complete", test.desc)
+		}
+
+		if !isError(request.Err, test.expectedErr) {
+			t.Errorf("%s: expected %v got %v", test.desc, test.expectedErr, request.Err)
+		}
+
+		if request.Attempts != test.expectedAttempts {
+			t.Errorf("%s: expected %d attempts got %d", test.desc, test.expectedAttempts, request.Attempts)
+		}
+
+		if request.Message.Flags != test.expectedFlags {
+			t.Errorf("%s: expected flags %v got %v", test.desc, test.expectedFlags, request.Message.Flags)
+		}
+
+		if len(observer.retried) != test.expectedAttempts-1 {
+			t.Errorf("%s: expected %d retries to be observed got %d", test.desc, test.expectedAttempts-1, len(observer.retried))
+		}
+		close(conn.sendCh)
+	}
+	SetObserver(nil)
+}
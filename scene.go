@@ -0,0 +1,266 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNoFreeGroup is returned when a scene needs a new group number but
+	// every group on the controller is already in use
+	ErrNoFreeGroup = errors.New("No free All-Link groups available")
+
+	// ErrNoSceneGroup is returned when a scene operation requires that the
+	// scene has already been assigned a group number
+	ErrNoSceneGroup = errors.New("Scene has not been assigned an All-Link group")
+)
+
+const (
+	// DefaultSceneRamp is the ramp rate used for scene members that do not
+	// specify one.  0x1c is the ramp rate (roughly half a second) that most
+	// devices use by default
+	DefaultSceneRamp = 0x1c
+)
+
+// AllLinkCommander is any device that can send a command to all the members
+// of an All-Link group.  The PLM is the most common AllLinkCommander
+type AllLinkCommander interface {
+	// SendAllLinkCommand sends the command to every responder in the group
+	SendAllLinkCommand(group Group, cmd Command) error
+}
+
+// SceneMember is a responder in a scene.  The OnLevel and Ramp are stored
+// in the Data field of the responder's link record and are recalled by the
+// responder when the scene is turned on
+type SceneMember struct {
+	// Address of the responding device
+	Address Address `json:"address"`
+
+	// OnLevel is the level (0-255) the responder goes to when the scene is turned on
+	OnLevel int `json:"level"`
+
+	// Ramp is the ramp rate the responder uses when the scene is triggered.  If
+	// zero, the DefaultSceneRamp is used
+	Ramp int `json:"ramp,omitempty"`
+
+	// Button is the button (or load) on the responder that the scene controls.
+	// Single load devices use button 1, which is also the default
+	Button int `json:"button,omitempty"`
+}
+
+// Data returns the three data bytes that are written to the responder's link
+// record for this member
+func (sm SceneMember) Data() [3]byte {
+	ramp := sm.Ramp
+	if ramp == 0 {
+		ramp = DefaultSceneRamp
+	}
+
+	button := sm.Button
+	if button == 0 {
+		button = 1
+	}
+	return [3]byte{byte(sm.OnLevel), byte(ramp), byte(button)}
+}
+
+// Scene is a named collection of responders that are all controlled by a
+// single controller (usually the PLM) using a dedicated All-Link group
+type Scene struct {
+	// Name is a human readable name for the scene
+	Name string `json:"name"`
+
+	// Group is the All-Link group number used by the controller to trigger
+	// the scene.  If the group is zero when the scene is created, the
+	// next free group on the controller is allocated
+	Group Group `json:"group,omitempty"`
+
+	// Controller is the address of the controlling device
+	Controller Address `json:"controller"`
+
+	// Members is the list of responders in the scene
+	Members []SceneMember `json:"members"`
+}
+
+// Member will return the scene member with the given address
+func (scene *Scene) Member(address Address) (SceneMember, bool) {
+	for _, member := range scene.Members {
+		if member.Address == address {
+			return member, true
+		}
+	}
+	return SceneMember{}, false
+}
+
+// ControllerLink returns the link record that should be present in the
+// controller's All-Link database for the given member
+func (scene *Scene) ControllerLink(member SceneMember) *LinkRecord {
+	link := &LinkRecord{Group: scene.Group, Address: member.Address}
+	link.Flags.setInUse()
+	link.Flags.setController()
+	return link
+}
+
+// ResponderLink returns the link record that should be present in the
+// member's All-Link database
+func (scene *Scene) ResponderLink(member SceneMember) *LinkRecord {
+	link := &LinkRecord{Group: scene.Group, Address: scene.Controller, Data: member.Data()}
+	link.Flags.setInUse()
+	link.Flags.setResponder()
+	return link
+}
+
+// FreeGroup returns the lowest group number that is not referenced by any
+// in-use record in the device's All-Link database.  Groups 0 and 255 are
+// reserved and never returned
+func FreeGroup(linkable LinkableDevice) (Group, error) {
+	links, err := linkable.Links()
+	if err == nil {
+		used := make(map[Group]bool)
+		for _, link := range links {
+			if link.Flags.InUse() {
+				used[link.Group] = true
+			}
+		}
+
+		for group := Group(1); group < 255; group++ {
+			if !used[group] {
+				return group, nil
+			}
+		}
+		err = ErrNoFreeGroup
+	}
+	return 0, err
+}
+
+// CreateScene will allocate a free group on the controller (if the scene
+// does not already have one) and then write the controller and responder
+// link records for every member of the scene.  The connect function is
+// used to get a LinkableDevice for each of the scene members
+func CreateScene(scene *Scene, controller LinkableDevice, connect func(Address) (LinkableDevice, error)) (err error) {
+	if scene.Group == 0 {
+		scene.Group, err = FreeGroup(controller)
+	}
+
+	if err == nil {
+		scene.Controller = controller.Address()
+		err = programScene(scene, controller, connect)
+	}
+	return err
+}
+
+// EditScene will update the controller and responder databases to match
+// the scene definition.  Responders that are still linked to the scene's
+// group, but are no longer members of the scene, are removed from the group.
+// Members whose on level or ramp rate have changed get their responder
+// records re-written
+func EditScene(scene *Scene, controller LinkableDevice, connect func(Address) (LinkableDevice, error)) error {
+	if scene.Group == 0 {
+		return ErrNoSceneGroup
+	}
+
+	scene.Controller = controller.Address()
+	links, err := controller.Links()
+	for _, link := range links {
+		if err != nil {
+			break
+		}
+
+		if link.Flags.InUse() && link.Flags.Controller() && link.Group == scene.Group {
+			if _, found := scene.Member(link.Address); !found {
+				Log.Debugf("Removing %s from scene %q", link.Address, scene.Name)
+				err = removeSceneMember(scene, SceneMember{Address: link.Address}, controller, connect)
+			}
+		}
+	}
+
+	if err == nil {
+		err = programScene(scene, controller, connect)
+	}
+	return err
+}
+
+// DeleteScene will remove the scene's controller records from the controller
+// and the corresponding responder records from each member
+func DeleteScene(scene *Scene, controller LinkableDevice, connect func(Address) (LinkableDevice, error)) (err error) {
+	if scene.Group == 0 {
+		return ErrNoSceneGroup
+	}
+
+	scene.Controller = controller.Address()
+	for _, member := range scene.Members {
+		err = removeSceneMember(scene, member, controller, connect)
+		if err != nil {
+			break
+		}
+	}
+	return err
+}
+
+// TriggerScene will send the command (usually CmdLightOn or CmdLightOff) to
+// the scene's group.  Each responder will respond according to the data
+// stored in its link record
+func TriggerScene(scene *Scene, commander AllLinkCommander, cmd Command) error {
+	if scene.Group == 0 {
+		return ErrNoSceneGroup
+	}
+	return commander.SendAllLinkCommand(scene.Group, cmd)
+}
+
+func programScene(scene *Scene, controller LinkableDevice, connect func(Address) (LinkableDevice, error)) error {
+	for _, member := range scene.Members {
+		link, err := FindLinkRecord(controller, true, member.Address, scene.Group)
+		if err == nil && link == nil {
+			Log.Debugf("Adding controller link for %s to scene %q", member.Address, scene.Name)
+			err = controller.AddLink(scene.ControllerLink(member))
+		}
+
+		var responder LinkableDevice
+		if err == nil {
+			responder, err = connect(member.Address)
+		}
+
+		if err == nil {
+			link, err = FindLinkRecord(responder, false, scene.Controller, scene.Group)
+			if err == nil {
+				if link == nil {
+					Log.Debugf("Adding responder link to %s for scene %q", member.Address, scene.Name)
+					err = responder.AddLink(scene.ResponderLink(member))
+				} else if link.Data != member.Data() {
+					Log.Debugf("Updating responder link on %s for scene %q", member.Address, scene.Name)
+					link.Data = member.Data()
+					err = responder.WriteLink(link)
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeSceneMember(scene *Scene, member SceneMember, controller LinkableDevice, connect func(Address) (LinkableDevice, error)) error {
+	responder, err := connect(member.Address)
+	if err == nil {
+		err = responder.RemoveLinks(scene.ResponderLink(member))
+	}
+
+	if err == nil {
+		err = controller.RemoveLinks(scene.ControllerLink(member))
+	}
+	return err
+}
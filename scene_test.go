@@ -0,0 +1,255 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// testLinkable is an in-memory LinkableDevice
+type testLinkable struct {
+	address Address
+	links   []*LinkRecord
+}
+
+func (tl *testLinkable) Address() Address                 { return tl.address }
+func (tl *testLinkable) EnterLinkingMode(Group) error     { return nil }
+func (tl *testLinkable) EnterUnlinkingMode(Group) error   { return nil }
+func (tl *testLinkable) ExitLinkingMode() error           { return nil }
+func (tl *testLinkable) Links() ([]*LinkRecord, error)    { return tl.links, nil }
+func (tl *testLinkable) WriteLink(link *LinkRecord) error { return nil }
+
+func (tl *testLinkable) AddLink(newLink *LinkRecord) error {
+	link := *newLink
+	tl.links = append(tl.links, &link)
+	return nil
+}
+
+func (tl *testLinkable) RemoveLinks(oldLinks ...*LinkRecord) error {
+	for _, oldLink := range oldLinks {
+		for _, link := range tl.links {
+			if link.Flags.InUse() && oldLink.Equal(link) {
+				link.Flags.setAvailable()
+			}
+		}
+	}
+	return nil
+}
+
+func (tl *testLinkable) inUse() (links []*LinkRecord) {
+	for _, link := range tl.links {
+		if link.Flags.InUse() {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func newTestHouse(addresses ...Address) (*testLinkable, map[Address]*testLinkable, func(Address) (LinkableDevice, error)) {
+	controller := &testLinkable{address: Address{0xaa, 0xbb, 0xcc}}
+	devices := make(map[Address]*testLinkable)
+	for _, address := range addresses {
+		devices[address] = &testLinkable{address: address}
+	}
+	return controller, devices, func(address Address) (LinkableDevice, error) {
+		if device, found := devices[address]; found {
+			return device, nil
+		}
+		return nil, ErrReadTimeout
+	}
+}
+
+func TestSceneMemberData(t *testing.T) {
+	tests := []struct {
+		input    SceneMember
+		expected [3]byte
+	}{
+		{SceneMember{OnLevel: 255}, [3]byte{0xff, DefaultSceneRamp, 0x01}},
+		{SceneMember{OnLevel: 128, Ramp: 0x1f, Button: 3}, [3]byte{0x80, 0x1f, 0x03}},
+	}
+
+	for i, test := range tests {
+		if test.input.Data() != test.expected {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expected, test.input.Data())
+		}
+	}
+}
+
+func TestSceneJSON(t *testing.T) {
+	input := `{"name":"movie","group":20,"controller":"aa.bb.cc","members":[{"address":"01.02.03","level":128}]}`
+	scene := &Scene{}
+	err := json.Unmarshal([]byte(input), scene)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if scene.Name != "movie" || scene.Group != 20 || scene.Controller != (Address{0xaa, 0xbb, 0xcc}) {
+		t.Errorf("unexpected scene %+v", scene)
+	}
+
+	if member, found := scene.Member(Address{1, 2, 3}); !found || member.OnLevel != 128 {
+		t.Errorf("expected member 01.02.03 with level 128 got %+v", member)
+	}
+}
+
+func TestFreeGroup(t *testing.T) {
+	tests := []struct {
+		links       []*LinkRecord
+		expected    Group
+		expectedErr error
+	}{
+		{nil, 1, nil},
+		{[]*LinkRecord{{Flags: 0xc0, Group: 1}, {Flags: 0x80, Group: 2}}, 3, nil},
+		{[]*LinkRecord{{Flags: 0xc0, Group: 1}, {Flags: 0x40, Group: 2}}, 2, nil},
+	}
+
+	full := []*LinkRecord{}
+	for group := 1; group < 255; group++ {
+		full = append(full, &LinkRecord{Flags: 0xc0, Group: Group(group)})
+	}
+	tests = append(tests, struct {
+		links       []*LinkRecord
+		expected    Group
+		expectedErr error
+	}{full, 0, ErrNoFreeGroup})
+
+	for i, test := range tests {
+		group, err := FreeGroup(&testLinkable{links: test.links})
+		if err != test.expectedErr {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expectedErr, err)
+		} else if group != test.expected {
+			t.Errorf("tests[%d] expected %v got %v", i, test.expected, group)
+		}
+	}
+}
+
+func TestCreateEditDeleteScene(t *testing.T) {
+	member1 := Address{1, 2, 3}
+	member2 := Address{4, 5, 6}
+	controller, devices, connect := newTestHouse(member1, member2)
+	controller.links = []*LinkRecord{{Flags: 0xc0, Group: 1, Address: member1}}
+
+	scene := &Scene{Name: "movie", Members: []SceneMember{{Address: member1, OnLevel: 128}, {Address: member2, OnLevel: 255}}}
+	err := CreateScene(scene, controller, connect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if scene.Group != 2 {
+		t.Errorf("expected group 2 got %v", scene.Group)
+	}
+
+	if len(controller.inUse()) != 3 {
+		t.Errorf("expected 3 controller links got %d", len(controller.inUse()))
+	}
+
+	for _, member := range scene.Members {
+		links := devices[member.Address].inUse()
+		if len(links) != 1 {
+			t.Errorf("expected 1 responder link on %v got %d", member.Address, len(links))
+		} else if links[0].Data != member.Data() || links[0].Address != controller.address || links[0].Flags.Controller() {
+			t.Errorf("unexpected responder link %v on %v", links[0], member.Address)
+		}
+	}
+
+	// remove member2 and change the level on member1
+	scene.Members = []SceneMember{{Address: member1, OnLevel: 42}}
+	err = EditScene(scene, controller, connect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(devices[member2].inUse()) != 0 {
+		t.Errorf("expected member2 to be removed from the scene")
+	}
+
+	if link, _ := FindLinkRecord(devices[member1], false, controller.address, scene.Group); link == nil || link.Data[0] != 42 {
+		t.Errorf("expected member1 on level to be updated, got %v", link)
+	}
+
+	err = DeleteScene(scene, controller, connect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(controller.inUse()) != 1 {
+		t.Errorf("expected 1 controller link got %d", len(controller.inUse()))
+	}
+
+	if len(devices[member1].inUse()) != 0 {
+		t.Errorf("expected member1 to be removed from the scene")
+	}
+}
+
+func TestSceneReaddMember(t *testing.T) {
+	member1 := Address{1, 2, 3}
+	member2 := Address{4, 5, 6}
+	controller, devices, connect := newTestHouse(member1, member2)
+
+	scene := &Scene{Name: "movie", Members: []SceneMember{{Address: member1, OnLevel: 128}, {Address: member2, OnLevel: 255}}}
+	if err := CreateScene(scene, controller, connect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	scene.Members = []SceneMember{{Address: member1, OnLevel: 128}}
+	if err := EditScene(scene, controller, connect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the removed records are only marked available, so adding member2
+	// back must add new records rather than finding the deleted ones
+	scene.Members = append(scene.Members, SceneMember{Address: member2, OnLevel: 64})
+	if err := EditScene(scene, controller, connect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if link, _ := FindLinkRecord(controller, true, member2, scene.Group); link == nil {
+		t.Errorf("expected controller link for member2")
+	}
+
+	links := devices[member2].inUse()
+	if len(links) != 1 {
+		t.Errorf("expected 1 responder link on member2 got %d", len(links))
+	} else if links[0].Data[0] != 64 {
+		t.Errorf("expected member2 on level 64 got %d", links[0].Data[0])
+	}
+}
+
+type testCommander struct {
+	group Group
+	cmd   Command
+}
+
+func (tc *testCommander) SendAllLinkCommand(group Group, cmd Command) error {
+	tc.group = group
+	tc.cmd = cmd
+	return nil
+}
+
+func TestTriggerScene(t *testing.T) {
+	commander := &testCommander{}
+	err := TriggerScene(&Scene{}, commander, CmdLightOn)
+	if err != ErrNoSceneGroup {
+		t.Errorf("expected %v got %v", ErrNoSceneGroup, err)
+	}
+
+	err = TriggerScene(&Scene{Group: 5}, commander, CmdLightOff)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if commander.group != 5 || commander.cmd != CmdLightOff {
+		t.Errorf("expected group 5 %v got %v %v", CmdLightOff, commander.group, commander.cmd)
+	}
+}
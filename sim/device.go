@@ -0,0 +1,460 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/abates/insteon"
+)
+
+// NAK codes returned in cmd2 of a direct NAK
+const (
+	nakIllegalValue      = 0xfb
+	nakIncorrectChecksum = 0xfd
+	nakUnknownCommand    = 0xfd
+	nakNotLinked         = 0xff
+)
+
+// baseLinkAddress is the memory address of the first ALDB record.  Each
+// following record is 8 bytes lower
+const baseLinkAddress = 0x0fff
+
+// Faults are failures injected into a simulated device's responses
+// to direct messages.  Drops and NAKs are counted down as they are used
+type Faults struct {
+	// Drop is the number of direct messages to silently ignore
+	Drop int
+
+	// Nak is the number of direct messages to reject with NakCode
+	Nak int
+
+	// NakCode is the cmd2 value sent in injected NAKs
+	NakCode byte
+
+	// Delay is the time to wait before responding to each message.
+	// Since the simulated network delivers one message at a time this
+	// also delays every other device, much like a congested network
+	Delay time.Duration
+}
+
+// LightingDevice is a simulated switch or dimmer.  It responds to the
+// common device commands (engine version, ID request, ping, operating
+// flags and extended get/set), reads and writes its All-Link database
+// with Read/Write ALDB and keeps the state changed by lighting commands
+// and All-Link broadcasts from its controllers.  The engine version
+// determines the protocol details:
+//
+//	VerI1   extended messages are ignored, so the ALDB is not readable
+//	VerI2   every message is accepted
+//	VerI2Cs extended messages must have a valid checksum and messages
+//	        from senders not in the ALDB are rejected with ErrNotLinked
+type LightingDevice struct {
+	info insteon.DeviceInfo
+
+	mutex     sync.Mutex
+	network   Network
+	level     int
+	flags     byte
+	data      [14]byte
+	links     []*insteon.LinkRecord
+	aldbDelta byte
+	faults    Faults
+}
+
+// NewLightingDevice returns a simulated device.  The device category
+// (0x01 dimmer or 0x02 switch), firmware and engine version are taken
+// from info
+func NewLightingDevice(info insteon.DeviceInfo) *LightingDevice {
+	return &LightingDevice{info: info}
+}
+
+// Address returns the Insteon address of the device
+func (ld *LightingDevice) Address() insteon.Address {
+	return ld.info.Address
+}
+
+// Attach connects the device to the network
+func (ld *LightingDevice) Attach(network Network) {
+	ld.mutex.Lock()
+	ld.network = network
+	ld.mutex.Unlock()
+}
+
+// Level returns the current level of the load, 0 (off) to 255 (on)
+func (ld *LightingDevice) Level() int {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+	return ld.level
+}
+
+// Links returns a copy of the device's All-Link database
+func (ld *LightingDevice) Links() []*insteon.LinkRecord {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+	links := make([]*insteon.LinkRecord, len(ld.links))
+	for i, link := range ld.links {
+		l := *link
+		links[i] = &l
+	}
+	return links
+}
+
+// AddLink appends a copy of the link to the device's All-Link database
+func (ld *LightingDevice) AddLink(link *insteon.LinkRecord) {
+	l := *link
+	ld.mutex.Lock()
+	ld.links = append(ld.links, &l)
+	ld.aldbDelta++
+	ld.mutex.Unlock()
+}
+
+// Inject sets the faults for the device's subsequent responses
+func (ld *LightingDevice) Inject(faults Faults) {
+	ld.mutex.Lock()
+	ld.faults = faults
+	ld.mutex.Unlock()
+}
+
+// Press simulates operating the device locally.  The load is turned on
+// (to the default on level) or off and an All-Link broadcast is sent to
+// group 1's responders
+func (ld *LightingDevice) Press(on bool) {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+
+	cmd1 := byte(0x13)
+	ld.level = 0
+	if on {
+		cmd1 = 0x11
+		ld.setLevel(ld.onLevel())
+	}
+	ld.send(insteon.Address{0, 0, 1}, insteon.StandardAllLinkBroadcast, cmd1, 0x00, nil)
+}
+
+// SetButton simulates pressing the set button, which sends a Set-button
+// Pressed broadcast containing the device category and firmware version
+func (ld *LightingDevice) SetButton() {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+	ld.sendID()
+}
+
+func (ld *LightingDevice) sendID() {
+	dst := insteon.Address{ld.info.DevCat[0], ld.info.DevCat[1], byte(ld.info.FirmwareVersion)}
+	ld.send(dst, insteon.StandardBroadcast, insteon.CmdSetButtonPressedController[1], 0x00, nil)
+}
+
+// send delivers a message to the network.  Extended messages from I2CS
+// devices include a checksum.  The mutex must be held
+func (ld *LightingDevice) send(dst insteon.Address, flags insteon.Flags, cmd1, cmd2 byte, payload []byte) {
+	msg := &insteon.Message{
+		Src:     ld.info.Address,
+		Dst:     dst,
+		Flags:   flags,
+		Command: insteon.Command{byte(flags) >> 4, cmd1, cmd2},
+	}
+
+	if flags.Extended() {
+		msg.Payload = make([]byte, 14)
+		copy(msg.Payload, payload)
+		if ld.info.EngineVersion == insteon.VerI2Cs {
+			msg.Payload[13] = checksum(cmd1, cmd2, msg.Payload[0:13])
+		}
+	}
+
+	if ld.network != nil {
+		ld.network.Send(msg)
+	}
+}
+
+func (ld *LightingDevice) ack(msg *insteon.Message, cmd2 byte) {
+	ld.send(msg.Src, insteon.StandardDirectAck, msg.Command[1], cmd2, nil)
+}
+
+func (ld *LightingDevice) nak(msg *insteon.Message, code byte) {
+	ld.send(msg.Src, insteon.StandardDirectNak, msg.Command[1], code, nil)
+}
+
+func checksum(cmd1, cmd2 byte, data []byte) byte {
+	sum := cmd1 + cmd2
+	for _, b := range data {
+		sum += b
+	}
+	return ^sum + 1
+}
+
+func (ld *LightingDevice) dimmable() bool {
+	return ld.info.DevCat.Category() == insteon.Category(0x01)
+}
+
+// setLevel changes the level of the load.  Switches are either fully on
+// or off.  The mutex must be held
+func (ld *LightingDevice) setLevel(level int) {
+	if level < 0 {
+		level = 0
+	} else if level > 0xff || (level > 0 && !ld.dimmable()) {
+		level = 0xff
+	}
+	ld.level = level
+}
+
+// onLevel is the level used when the device is turned on without a level
+func (ld *LightingDevice) onLevel() int {
+	if ld.data[7] == 0 {
+		return 0xff
+	}
+	return int(ld.data[7])
+}
+
+// linked indicates whether the address is in the device's ALDB
+func (ld *LightingDevice) linked(address insteon.Address) bool {
+	for _, link := range ld.links {
+		if link.Flags.InUse() && link.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Receive processes a message delivered by the network
+func (ld *LightingDevice) Receive(msg *insteon.Message) {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+
+	if msg.Flags.Type() == insteon.MsgTypeAllLinkBroadcast {
+		ld.receiveAllLink(msg.Src, insteon.Group(msg.Dst[2]), msg.Command[1])
+		return
+	} else if msg.Broadcast() || msg.Ack() || msg.Nak() || msg.Dst != ld.info.Address {
+		return
+	}
+
+	if msg.Flags.Extended() && ld.info.EngineVersion == insteon.VerI1 {
+		insteon.Log.Debugf("Simulated I1 device %s ignoring extended message", ld.info.Address)
+		return
+	}
+
+	if ld.faults.Delay > 0 {
+		time.Sleep(ld.faults.Delay)
+	}
+
+	if ld.faults.Drop > 0 {
+		ld.faults.Drop--
+		return
+	} else if ld.faults.Nak > 0 {
+		ld.faults.Nak--
+		ld.nak(msg, ld.faults.NakCode)
+		return
+	}
+
+	if msg.Flags.Type() == insteon.MsgTypeAllLinkCleanup {
+		ld.receiveAllLink(msg.Src, insteon.Group(msg.Command[2]), msg.Command[1])
+		ld.send(msg.Src, insteon.Flags(0x6a), msg.Command[1], msg.Command[2], nil)
+		return
+	}
+
+	if ld.info.EngineVersion == insteon.VerI2Cs {
+		if msg.Flags.Extended() && checksum(msg.Command[1], msg.Command[2], msg.Payload[0:13]) != msg.Payload[13] {
+			ld.nak(msg, nakIncorrectChecksum)
+			return
+		} else if !ld.linked(msg.Src) {
+			ld.nak(msg, nakNotLinked)
+			return
+		}
+	}
+
+	ld.receiveDirect(msg)
+}
+
+// receiveAllLink applies a command sent by a controller to one of its
+// groups.  Only controllers in the device's ALDB are obeyed
+func (ld *LightingDevice) receiveAllLink(controller insteon.Address, group insteon.Group, cmd1 byte) {
+	for _, link := range ld.links {
+		if link.Flags.InUse() && link.Flags.Responder() && link.Address == controller && link.Group == group {
+			switch cmd1 {
+			case 0x11:
+				ld.setLevel(int(link.Data[0]))
+			case 0x12:
+				ld.setLevel(0xff)
+			case 0x13, 0x14:
+				ld.setLevel(0)
+			}
+			return
+		}
+	}
+}
+
+func (ld *LightingDevice) receiveDirect(msg *insteon.Message) {
+	cmd1, cmd2 := msg.Command[1], msg.Command[2]
+	if msg.Flags.Extended() {
+		switch cmd1 {
+		case 0x2e:
+			ld.extendedGetSet(msg)
+		case 0x2f:
+			ld.readWriteALDB(msg)
+		default:
+			ld.nak(msg, nakUnknownCommand)
+		}
+		return
+	}
+
+	switch cmd1 {
+	case 0x0d: // engine version
+		ld.ack(msg, byte(ld.info.EngineVersion))
+	case 0x0f: // ping
+		ld.ack(msg, cmd2)
+	case 0x10: // ID request
+		ld.ack(msg, cmd2)
+		ld.sendID()
+	case 0x1f: // get operating flags
+		switch cmd2 {
+		case 0x00:
+			ld.ack(msg, ld.flags)
+		case 0x01:
+			ld.ack(msg, ld.aldbDelta)
+		default:
+			ld.ack(msg, 0x00)
+		}
+	case 0x20: // set operating flags, even values set a flag and odd values clear it
+		if cmd2 < 0x10 {
+			if cmd2%2 == 0 {
+				ld.flags |= 1 << (cmd2 / 2)
+			} else {
+				ld.flags &^= 1 << (cmd2 / 2)
+			}
+		}
+		ld.ack(msg, cmd2)
+	case 0x11, 0x12, 0x21, 0x27: // on, fast on, instant change, set status
+		level := int(cmd2)
+		if cmd1 == 0x12 && level == 0 {
+			level = 0xff
+		}
+		ld.setLevel(level)
+		ld.ack(msg, cmd2)
+	case 0x13, 0x14, 0x2f, 0x35: // off, fast off, off at ramp
+		ld.setLevel(0)
+		ld.ack(msg, cmd2)
+	case 0x2e, 0x34: // on at ramp, the level is in the upper nibble
+		ld.setLevel(int(cmd2>>4) * 0x11)
+		ld.ack(msg, cmd2)
+	case 0x15: // brighten one step
+		ld.setLevel(ld.level + 8)
+		ld.ack(msg, cmd2)
+	case 0x16: // dim one step
+		ld.setLevel(ld.level - 8)
+		ld.ack(msg, cmd2)
+	case 0x17, 0x18: // start/stop manual change
+		ld.ack(msg, cmd2)
+	case 0x19: // status request
+		ld.send(msg.Src, insteon.StandardDirectAck, ld.aldbDelta, byte(ld.level), nil)
+	default:
+		ld.nak(msg, nakUnknownCommand)
+	}
+}
+
+// extendedGetSet responds to data requests with the device configuration
+// (X10 address, ramp rate and on level) and stores the values from set
+// requests
+func (ld *LightingDevice) extendedGetSet(msg *insteon.Message) {
+	payload := msg.Payload
+	switch payload[1] {
+	case 0x00:
+		ld.ack(msg, 0x00)
+		data := ld.data
+		data[0] = payload[0]
+		data[1] = 0x01
+		ld.send(msg.Src, insteon.ExtendedDirectMessage, 0x2e, 0x00, data[:])
+	case 0x04:
+		ld.data[4], ld.data[5] = payload[2], payload[3]
+		ld.ack(msg, 0x00)
+	case 0x05:
+		ld.data[6] = payload[2]
+		ld.ack(msg, 0x00)
+	case 0x06:
+		ld.data[7] = payload[2]
+		ld.ack(msg, 0x00)
+	default:
+		ld.nak(msg, nakIllegalValue)
+	}
+}
+
+// readWriteALDB reads or writes the records in the All-Link database.
+// Records are stored from baseLinkAddress downward, 8 bytes each
+func (ld *LightingDevice) readWriteALDB(msg *insteon.Message) {
+	payload := msg.Payload
+	memAddress := int(payload[2])<<8 | int(payload[3])
+	link := &insteon.LinkRecord{}
+	if payload[1] == 0x02 {
+		link.UnmarshalBinary(payload[5:13])
+	}
+
+	index := 0
+	if memAddress != 0 {
+		index = (baseLinkAddress - memAddress) / 8
+		// the record after the end of database marker may only be
+		// written with another marker
+		last := len(ld.links)
+		if payload[1] == 0x02 && link.Flags == 0x00 {
+			last++
+		}
+
+		if memAddress > baseLinkAddress || (baseLinkAddress-memAddress)%8 != 0 || index > last {
+			ld.nak(msg, nakIllegalValue)
+			return
+		}
+	}
+
+	switch payload[1] {
+	case 0x00:
+		ld.ack(msg, 0x00)
+		end := len(ld.links)
+		if payload[4] > 0 && index+int(payload[4]) < end {
+			end = index + int(payload[4])
+		}
+
+		for i := index; i < end; i++ {
+			ld.sendRecord(msg.Src, i, ld.links[i])
+		}
+
+		if end == len(ld.links) {
+			// the end of the database is marked by an empty record
+			ld.sendRecord(msg.Src, end, &insteon.LinkRecord{})
+		}
+	case 0x02:
+		if link.Flags == 0x00 {
+			// writing the end of database marker truncates the database,
+			// a marker after the current one leaves it unchanged
+			if index < len(ld.links) {
+				ld.links = ld.links[0:index]
+			}
+		} else if index == len(ld.links) {
+			ld.links = append(ld.links, link)
+		} else {
+			ld.links[index] = link
+		}
+		ld.aldbDelta++
+		ld.ack(msg, 0x00)
+	default:
+		ld.nak(msg, nakIllegalValue)
+	}
+}
+
+func (ld *LightingDevice) sendRecord(dst insteon.Address, index int, link *insteon.LinkRecord) {
+	memAddress := baseLinkAddress - 8*index
+	buf, _ := link.MarshalBinary()
+	payload := append([]byte{0x00, 0x01, byte(memAddress >> 8), byte(memAddress), 0x00}, buf...)
+	ld.send(dst, insteon.ExtendedDirectMessage, 0x2f, 0x00, payload)
+}
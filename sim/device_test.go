@@ -0,0 +1,283 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+// testNetwork records the messages sent by a device
+type testNetwork struct {
+	sent []*insteon.Message
+}
+
+func (tn *testNetwork) Send(msg *insteon.Message) { tn.sent = append(tn.sent, msg) }
+
+var (
+	testPLMAddress = insteon.Address{1, 2, 3}
+	testPLMLink    = &insteon.LinkRecord{Flags: 0xa2, Group: 1, Address: testPLMAddress, Data: [3]byte{0x80, 0x1c, 0x01}}
+)
+
+func newTestDevice(version insteon.EngineVersion, devCat insteon.DevCat, links ...*insteon.LinkRecord) (*LightingDevice, *testNetwork) {
+	device := NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{4, 5, 6}, DevCat: devCat, FirmwareVersion: 0x41, EngineVersion: version})
+	for _, link := range links {
+		device.AddLink(link)
+	}
+	network := &testNetwork{}
+	device.Attach(network)
+	return device, network
+}
+
+func directMessage(cmd1, cmd2 byte, payload ...byte) *insteon.Message {
+	msg := &insteon.Message{Src: testPLMAddress, Dst: insteon.Address{4, 5, 6}, Flags: insteon.StandardDirectMessage, Command: insteon.Command{0x00, cmd1, cmd2}}
+	if len(payload) > 0 {
+		msg.Flags = insteon.ExtendedDirectMessage
+		msg.Command[0] = 0x01
+		msg.Payload = make([]byte, 14)
+		copy(msg.Payload, payload)
+	}
+	return msg
+}
+
+func TestLightingDeviceReceive(t *testing.T) {
+	dimmer := insteon.DevCat{0x01, 0x20}
+	goodChecksum := make([]byte, 14)
+	goodChecksum[13] = checksum(0x2e, 0x00, goodChecksum[0:13])
+
+	tests := []struct {
+		desc          string
+		version       insteon.EngineVersion
+		links         []*insteon.LinkRecord
+		input         *insteon.Message
+		expectedFlags []insteon.Flags
+		expectedCmd   []byte // cmd1, cmd2 of the first response
+		expectedLevel int
+	}{
+		{"I1 engine version", insteon.VerI1, nil, directMessage(0x0d, 0x00), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x0d, 0x00}, 0},
+		{"I2 engine version", insteon.VerI2, nil, directMessage(0x0d, 0x00), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x0d, 0x01}, 0},
+		{"I2CS engine version", insteon.VerI2Cs, []*insteon.LinkRecord{testPLMLink}, directMessage(0x0d, 0x00), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x0d, 0x02}, 0},
+		{"I2CS not linked", insteon.VerI2Cs, nil, directMessage(0x0d, 0x00), []insteon.Flags{insteon.StandardDirectNak}, []byte{0x0d, 0xff}, 0},
+		{"I2CS bad checksum", insteon.VerI2Cs, []*insteon.LinkRecord{testPLMLink}, directMessage(0x2e, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01), []insteon.Flags{insteon.StandardDirectNak}, []byte{0x2e, 0xfd}, 0},
+		{"I2CS good checksum", insteon.VerI2Cs, []*insteon.LinkRecord{testPLMLink}, directMessage(0x2e, 0x00, goodChecksum...), []insteon.Flags{insteon.StandardDirectAck, insteon.ExtendedDirectMessage}, []byte{0x2e, 0x00}, 0},
+		{"I1 ignores extended", insteon.VerI1, nil, directMessage(0x2e, 0x00, 0x01), nil, nil, 0},
+		{"ping", insteon.VerI2, nil, directMessage(0x0f, 0x00), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x0f, 0x00}, 0},
+		{"id request", insteon.VerI2, nil, directMessage(0x10, 0x00), []insteon.Flags{insteon.StandardDirectAck, insteon.StandardBroadcast}, []byte{0x10, 0x00}, 0},
+		{"on", insteon.VerI2, nil, directMessage(0x11, 0x80), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x11, 0x80}, 0x80},
+		{"fast on", insteon.VerI2, nil, directMessage(0x12, 0x00), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x12, 0x00}, 0xff},
+		{"on at ramp", insteon.VerI2, nil, directMessage(0x2e, 0x8f), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x2e, 0x8f}, 0x88},
+		{"status", insteon.VerI2, []*insteon.LinkRecord{testPLMLink}, directMessage(0x19, 0x00), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x01, 0x00}, 0},
+		{"get operating flags", insteon.VerI2, nil, directMessage(0x1f, 0x00), []insteon.Flags{insteon.StandardDirectAck}, []byte{0x1f, 0x00}, 0},
+		{"unknown command", insteon.VerI2, nil, directMessage(0x42, 0x00), []insteon.Flags{insteon.StandardDirectNak}, []byte{0x42, 0xfd}, 0},
+	}
+
+	for _, test := range tests {
+		device, network := newTestDevice(test.version, dimmer, test.links...)
+		device.Receive(test.input)
+
+		if len(network.sent) != len(test.expectedFlags) {
+			t.Errorf("%s: expected %d messages got %d", test.desc, len(test.expectedFlags), len(network.sent))
+			continue
+		}
+
+		for i, msg := range network.sent {
+			if msg.Flags != test.expectedFlags[i] {
+				t.Errorf("%s: message %d expected flags %v got %v", test.desc, i, test.expectedFlags[i], msg.Flags)
+			}
+		}
+
+		if len(network.sent) > 0 && (network.sent[0].Command[1] != test.expectedCmd[0] || network.sent[0].Command[2] != test.expectedCmd[1]) {
+			t.Errorf("%s: expected %02x %02x got %v", test.desc, test.expectedCmd[0], test.expectedCmd[1], network.sent[0].Command)
+		}
+
+		if device.Level() != test.expectedLevel {
+			t.Errorf("%s: expected level %d got %d", test.desc, test.expectedLevel, device.Level())
+		}
+	}
+}
+
+func TestLightingDeviceSwitch(t *testing.T) {
+	device, _ := newTestDevice(insteon.VerI2, insteon.DevCat{0x02, 0x2a})
+	device.Receive(directMessage(0x11, 0x40))
+	if device.Level() != 0xff {
+		t.Errorf("expected switch to be fully on, got %d", device.Level())
+	}
+}
+
+func TestLightingDeviceOperatingFlags(t *testing.T) {
+	device, network := newTestDevice(insteon.VerI2, insteon.DevCat{0x01, 0x20})
+	device.Receive(directMessage(0x20, 0x02))
+	device.Receive(directMessage(0x20, 0x08))
+	device.Receive(directMessage(0x20, 0x09))
+	device.Receive(directMessage(0x1f, 0x00))
+
+	if got := network.sent[3].Command[2]; got != 0x02 {
+		t.Errorf("expected flags 0x02 got 0x%02x", got)
+	}
+}
+
+func TestLightingDeviceFaults(t *testing.T) {
+	device, network := newTestDevice(insteon.VerI2, insteon.DevCat{0x01, 0x20})
+	device.Inject(Faults{Drop: 1, Nak: 1, NakCode: 0xfe, Delay: 10 * time.Millisecond})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		device.Receive(directMessage(0x0f, 0x00))
+	}
+
+	if time.Since(start) < 30*time.Millisecond {
+		t.Errorf("expected each response to be delayed")
+	}
+
+	if len(network.sent) != 2 {
+		t.Fatalf("expected 2 responses got %d", len(network.sent))
+	}
+
+	if !network.sent[0].Nak() || network.sent[0].Command[2] != 0xfe {
+		t.Errorf("expected NAK 0xfe got %v", network.sent[0])
+	}
+
+	if !network.sent[1].Ack() {
+		t.Errorf("expected ACK got %v", network.sent[1])
+	}
+}
+
+func TestLightingDeviceAllLink(t *testing.T) {
+	controller := NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{7, 8, 9}, DevCat: insteon.DevCat{0x02, 0x2a}, EngineVersion: insteon.VerI2Cs})
+	responder, _ := newTestDevice(insteon.VerI2Cs, insteon.DevCat{0x01, 0x20},
+		&insteon.LinkRecord{Flags: 0xa2, Group: 1, Address: insteon.Address{7, 8, 9}, Data: [3]byte{0x80, 0x1c, 0x01}},
+	)
+	modem := NewPLM(testPLMAddress)
+	defer modem.Close()
+	modem.AddDevice(controller)
+	modem.AddDevice(responder)
+
+	controller.Press(true)
+	for i := 0; i < 100 && responder.Level() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if controller.Level() != 0xff || responder.Level() != 0x80 {
+		t.Errorf("expected levels 255 and 128 got %d and %d", controller.Level(), responder.Level())
+	}
+
+	// broadcasts from controllers that are not linked are ignored
+	modem.Write([]byte{0x02, 0x61, 0x01, 0x13, 0x00})
+	time.Sleep(10 * time.Millisecond)
+	if responder.Level() != 0x80 {
+		t.Errorf("expected level 128 got %d", responder.Level())
+	}
+}
+
+func TestLightingDeviceALDB(t *testing.T) {
+	modem := NewPLM(testPLMAddress)
+	device := NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{4, 5, 6}, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x41, EngineVersion: insteon.VerI2Cs})
+	device.AddLink(testPLMLink)
+	modem.AddDevice(device)
+
+	local := plm.New(plm.NewPort(modem, time.Second), time.Second)
+	defer local.Close()
+
+	dev, err := local.Network.Dial(device.Address())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	linkable := dev.(insteon.LinkableDevice)
+	link := &insteon.LinkRecord{Flags: 0xe2, Group: 2, Address: testPLMAddress, Data: [3]byte{0x03, 0x1c, 0x01}}
+	if err = linkable.AddLink(link); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	links, err := linkable.Links()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(links) != 2 || !links[0].Equal(testPLMLink) || !links[1].Equal(link) {
+		t.Errorf("expected %v and %v got %v", testPLMLink, link, links)
+	}
+
+	if simLinks := device.Links(); len(simLinks) != 2 || !simLinks[1].Equal(link) {
+		t.Errorf("expected simulated ALDB to contain %v got %v", link, simLinks)
+	}
+}
+
+func TestLightingDeviceRetry(t *testing.T) {
+	modem := NewPLM(testPLMAddress)
+	device := NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{4, 5, 6}, DevCat: insteon.DevCat{0x01, 0x20}, EngineVersion: insteon.VerI2Cs})
+	device.AddLink(testPLMLink)
+	modem.AddDevice(device)
+
+	local := plm.New(plm.NewPort(modem, time.Second), 200*time.Millisecond)
+	defer local.Close()
+
+	dev, err := local.Network.Dial(device.Address())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the first message is lost and the second is rejected with a
+	// temporary error, so the third attempt succeeds
+	device.Inject(Faults{Drop: 1, Nak: 1, NakCode: 0xfc})
+	if _, err = dev.SendCommand(insteon.CmdPing, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// permanent errors are not retried
+	device.Inject(Faults{Nak: 2, NakCode: 0xfb})
+	if _, err = dev.SendCommand(insteon.CmdPing, nil); err != insteon.ErrIllegalValue {
+		t.Errorf("expected %v got %v", insteon.ErrIllegalValue, err)
+	}
+}
+
+func TestLightingDeviceCleanup(t *testing.T) {
+	modem := NewPLM(testPLMAddress)
+	device := NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{4, 5, 6}, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x41, EngineVersion: insteon.VerI2Cs})
+	link := &insteon.LinkRecord{Flags: 0xe2, Group: 2, Address: insteon.Address{7, 8, 9}, Data: [3]byte{0x03, 0x1c, 0x01}}
+	device.AddLink(testPLMLink)
+	device.AddLink(testPLMLink)
+	device.AddLink(&insteon.LinkRecord{Flags: 0x62, Group: 3, Address: insteon.Address{7, 8, 9}})
+	device.AddLink(&insteon.LinkRecord{Flags: 0xe2, Group: 1, Address: device.Address()})
+	device.AddLink(link)
+	modem.AddDevice(device)
+
+	local := plm.New(plm.NewPort(modem, time.Second), time.Second)
+	defer local.Close()
+
+	dev, err := local.Network.Dial(device.Address())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the duplicate link to the modem must be removed without unlinking
+	// the i2cs device from the modem
+	removed, err := insteon.Cleanup(dev.(insteon.LinkableDevice))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(removed) != 2 {
+		t.Errorf("expected 2 links to be removed got %v", removed)
+	}
+
+	links := device.Links()
+	if len(links) != 2 || !links[0].Equal(testPLMLink) || !links[1].Equal(link) {
+		t.Errorf("expected compacted database with %v and %v got %v", testPLMLink, link, links)
+	}
+}
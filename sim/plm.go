@@ -0,0 +1,464 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sim
+
+import (
+	"io"
+	"sync"
+
+	"github.com/abates/insteon"
+)
+
+// IM serial protocol bytes.  These are defined here, rather than imported
+// from the plm package, so that the simulator is an independent
+// implementation of the protocol and can be used by the plm tests
+const (
+	imStart = 0x02
+	imAck   = 0x06
+	imNak   = 0x15
+
+	cmdStdMsgReceived       = 0x50
+	cmdExtMsgReceived       = 0x51
+	cmdAllLinkRecordResp    = 0x57
+	cmdAllLinkCleanupStatus = 0x58
+	cmdGetInfo              = 0x60
+	cmdSendAllLink          = 0x61
+	cmdSendInsteonMsg       = 0x62
+	cmdSetHostCategory      = 0x66
+	cmdReset                = 0x67
+	cmdGetFirstAllLink      = 0x69
+	cmdGetNextAllLink       = 0x6a
+	cmdSetConfig            = 0x6b
+	cmdGetAllLinkForSender  = 0x6c
+	cmdManageAllLinkRecord  = 0x6f
+	cmdGetConfig            = 0x73
+
+	linkFindFirst    = 0x00
+	linkFindNext     = 0x01
+	linkModFirst     = 0x20
+	linkModFirstCtrl = 0x40
+	linkModFirstResp = 0x41
+	linkDeleteFirst  = 0x80
+)
+
+// txLens is the number of bytes that follow the command byte in frames
+// written to the PLM.  Extended Send INSTEON Msg frames are 14 bytes longer
+var txLens = map[byte]int{
+	0x60: 0,
+	0x61: 3,
+	0x62: 6,
+	0x63: 2,
+	0x64: 2,
+	0x65: 0,
+	0x66: 3,
+	0x67: 0,
+	0x68: 1,
+	0x69: 0,
+	0x6a: 0,
+	0x6b: 1,
+	0x6c: 0,
+	0x6d: 0,
+	0x6e: 0,
+	0x6f: 9,
+	0x70: 1,
+	0x71: 2,
+	0x72: 0,
+	0x73: 0,
+}
+
+// PLM is a simulated PowerLinc Modem.  It implements io.ReadWriteCloser:
+// frames written to the PLM are executed and the responses (along with
+// any messages received from simulated devices) are returned by Read
+type PLM struct {
+	address  insteon.Address
+	devCat   insteon.DevCat
+	firmware byte
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	closed  bool
+	input   []byte
+	output  []byte
+	queue   []*insteon.Message
+	devices map[insteon.Address]Device
+
+	config     byte
+	links      []*insteon.LinkRecord
+	nextLink   int
+	nextSearch int
+	naks       int
+	recordNak  int
+}
+
+// NewPLM returns a simulated PLM with the given address and an empty
+// link database
+func NewPLM(address insteon.Address) *PLM {
+	plm := &PLM{
+		address:  address,
+		devCat:   insteon.DevCat{0x03, 0x15},
+		firmware: 0x9b,
+		devices:  make(map[insteon.Address]Device),
+	}
+	plm.cond = sync.NewCond(&plm.mutex)
+	go plm.deliver()
+	return plm
+}
+
+// Address returns the Insteon address of the simulated PLM
+func (plm *PLM) Address() insteon.Address {
+	return plm.address
+}
+
+// AddDevice attaches the simulated device to the PLM's network
+func (plm *PLM) AddDevice(device Device) {
+	plm.mutex.Lock()
+	plm.devices[device.Address()] = device
+	plm.mutex.Unlock()
+	device.Attach(plm)
+}
+
+// Links returns a copy of the PLM's link database
+func (plm *PLM) Links() []*insteon.LinkRecord {
+	plm.mutex.Lock()
+	defer plm.mutex.Unlock()
+	links := make([]*insteon.LinkRecord, len(plm.links))
+	for i, link := range plm.links {
+		l := *link
+		links[i] = &l
+	}
+	return links
+}
+
+// AddLink appends a copy of the link to the PLM's link database
+func (plm *PLM) AddLink(link *insteon.LinkRecord) {
+	l := *link
+	plm.mutex.Lock()
+	plm.links = append(plm.links, &l)
+	plm.mutex.Unlock()
+}
+
+// NakNext causes the next n commands written to the PLM to be rejected
+// with a NAK, as a busy PLM would do
+func (plm *PLM) NakNext(n int) {
+	plm.mutex.Lock()
+	plm.naks = n
+	plm.mutex.Unlock()
+}
+
+// NakRecord causes the manage all-link record command that follows the
+// next skip such commands to be rejected with a NAK, so that a failure
+// part way through a sequence of link database changes can be simulated
+func (plm *PLM) NakRecord(skip int) {
+	plm.mutex.Lock()
+	plm.recordNak = skip + 1
+	plm.mutex.Unlock()
+}
+
+// Send delivers a message from a simulated device.  Direct messages are
+// delivered to the device (or PLM) they are addressed to and broadcast
+// messages are delivered to every other device as well as the PLM
+func (plm *PLM) Send(msg *insteon.Message) {
+	plm.mutex.Lock()
+	plm.enqueue(copyMessage(msg))
+	plm.mutex.Unlock()
+}
+
+// enqueue adds the message to the delivery queue.  The mutex must be held
+func (plm *PLM) enqueue(msg *insteon.Message) {
+	plm.queue = append(plm.queue, msg)
+	plm.cond.Broadcast()
+}
+
+// deliver routes queued messages until the PLM is closed.  Devices are
+// called without the mutex held so that they can respond with Send
+func (plm *PLM) deliver() {
+	plm.mutex.Lock()
+	defer plm.mutex.Unlock()
+	for {
+		for len(plm.queue) == 0 && !plm.closed {
+			plm.cond.Wait()
+		}
+
+		if plm.closed {
+			return
+		}
+
+		msg := plm.queue[0]
+		plm.queue = plm.queue[1:]
+
+		receivers := []Device{}
+		if msg.Broadcast() {
+			for address, device := range plm.devices {
+				if address != msg.Src {
+					receivers = append(receivers, device)
+				}
+			}
+
+			if msg.Src != plm.address {
+				plm.receive(msg)
+			}
+		} else if msg.Dst == plm.address {
+			plm.receive(msg)
+		} else if device, found := plm.devices[msg.Dst]; found {
+			receivers = append(receivers, device)
+		} else {
+			insteon.Log.Debugf("Simulated PLM dropping %v, no device found", msg)
+		}
+
+		plm.mutex.Unlock()
+		for _, device := range receivers {
+			device.Receive(copyMessage(msg))
+		}
+		plm.mutex.Lock()
+	}
+}
+
+// receive writes the message to the host as a Std or Ext Msg Received
+// frame.  The mutex must be held
+func (plm *PLM) receive(msg *insteon.Message) {
+	buf, _ := msg.MarshalBinary()
+	if msg.Flags.Extended() {
+		plm.write(cmdExtMsgReceived, buf...)
+	} else {
+		plm.write(cmdStdMsgReceived, buf...)
+	}
+}
+
+// write appends a frame to the output.  The mutex must be held
+func (plm *PLM) write(command byte, payload ...byte) {
+	plm.output = append(plm.output, imStart, command)
+	plm.output = append(plm.output, payload...)
+	plm.cond.Broadcast()
+}
+
+// Read returns the next bytes sent by the PLM.  Read blocks until output
+// is available and returns io.EOF once the PLM is closed
+func (plm *PLM) Read(p []byte) (n int, err error) {
+	plm.mutex.Lock()
+	defer plm.mutex.Unlock()
+
+	for len(plm.output) == 0 && !plm.closed {
+		plm.cond.Wait()
+	}
+
+	if len(plm.output) == 0 {
+		return 0, io.EOF
+	}
+
+	n = copy(p, plm.output)
+	plm.output = plm.output[n:]
+	return n, nil
+}
+
+// Write sends bytes to the PLM.  Each complete frame is executed as it is
+// received.  Bytes that do not begin a frame are discarded and unknown
+// commands are answered with a single NAK byte
+func (plm *PLM) Write(p []byte) (n int, err error) {
+	plm.mutex.Lock()
+	defer plm.mutex.Unlock()
+
+	if plm.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	plm.input = append(plm.input, p...)
+	for len(plm.input) > 0 {
+		if plm.input[0] != imStart {
+			plm.input = plm.input[1:]
+			continue
+		}
+
+		if len(plm.input) < 2 {
+			break
+		}
+
+		length, found := txLens[plm.input[1]]
+		if !found {
+			insteon.Log.Debugf("Simulated PLM received unknown command %02x", plm.input[1])
+			plm.output = append(plm.output, imNak)
+			plm.cond.Broadcast()
+			plm.input = plm.input[2:]
+			continue
+		}
+
+		if plm.input[1] == cmdSendInsteonMsg && len(plm.input) > 5 && insteon.Flags(plm.input[5]).Extended() {
+			length += 14
+		}
+
+		if len(plm.input) < length+2 {
+			break
+		}
+
+		frame := plm.input[0 : length+2]
+		plm.input = plm.input[length+2:]
+		plm.execute(frame[1], append([]byte(nil), frame[2:]...))
+	}
+	return len(p), nil
+}
+
+// execute runs the command and writes the response.  The mutex must be held
+func (plm *PLM) execute(command byte, payload []byte) {
+	response := payload
+	switch command {
+	case cmdGetInfo:
+		response = []byte{plm.address[0], plm.address[1], plm.address[2], plm.devCat[0], plm.devCat[1], plm.firmware}
+	case cmdGetConfig:
+		response = []byte{plm.config, 0x00, 0x00}
+	}
+
+	if plm.naks > 0 {
+		plm.naks--
+		plm.write(command, append(response, imNak)...)
+		return
+	}
+
+	ack := byte(imAck)
+	var followUp func()
+	switch command {
+	case cmdSendInsteonMsg:
+		msg := &insteon.Message{}
+		err := msg.UnmarshalBinary(append(plm.address[:], payload...))
+		if err == nil {
+			followUp = func() { plm.enqueue(msg) }
+		} else {
+			ack = imNak
+		}
+	case cmdSendAllLink:
+		msg := &insteon.Message{
+			Src:     plm.address,
+			Dst:     insteon.Address{0, 0, payload[0]},
+			Flags:   insteon.StandardAllLinkBroadcast,
+			Command: insteon.Command{byte(insteon.StandardAllLinkBroadcast) >> 4, payload[1], payload[2]},
+		}
+		followUp = func() {
+			plm.enqueue(msg)
+			plm.write(cmdAllLinkCleanupStatus, imAck)
+		}
+	case cmdSetHostCategory:
+		plm.devCat = insteon.DevCat{payload[0], payload[1]}
+		plm.firmware = payload[2]
+	case cmdReset:
+		plm.config = 0x00
+		plm.links = nil
+	case cmdSetConfig:
+		plm.config = payload[0] & 0xf0
+	case cmdGetFirstAllLink:
+		plm.nextLink = 0
+		followUp, ack = plm.nextRecord()
+	case cmdGetNextAllLink:
+		followUp, ack = plm.nextRecord()
+	case cmdManageAllLinkRecord:
+		if plm.recordNak > 0 {
+			plm.recordNak--
+			if plm.recordNak == 0 {
+				ack = imNak
+				break
+			}
+		}
+		followUp, ack = plm.manageRecord(payload)
+	case cmdGetAllLinkForSender:
+		// Get All-Link Record for Sender is not simulated
+		ack = imNak
+	}
+
+	plm.write(command, append(response, ack)...)
+	if followUp != nil {
+		followUp()
+	}
+}
+
+// writeRecord returns a function that writes the link as an ALL-Link
+// Record Response
+func (plm *PLM) writeRecord(link *insteon.LinkRecord) func() {
+	buf, _ := link.MarshalBinary()
+	return func() { plm.write(cmdAllLinkRecordResp, buf...) }
+}
+
+func (plm *PLM) nextRecord() (func(), byte) {
+	if plm.nextLink < len(plm.links) {
+		link := plm.links[plm.nextLink]
+		plm.nextLink++
+		return plm.writeRecord(link), imAck
+	}
+	return nil, imNak
+}
+
+// find returns the index of the first link at or after start that matches
+// the group and address or -1 if there is no such link.  If controller is
+// not nil then the link's controller flag must also match
+func (plm *PLM) find(start int, group insteon.Group, address insteon.Address, controller *bool) int {
+	for i := start; i < len(plm.links); i++ {
+		link := plm.links[i]
+		if link.Group == group && link.Address == address && (controller == nil || link.Flags.Controller() == *controller) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (plm *PLM) manageRecord(payload []byte) (func(), byte) {
+	link := &insteon.LinkRecord{}
+	// the flags may legitimately be zero (for instance when deleting) so
+	// the end of links error is ignored
+	link.UnmarshalBinary(payload[1:])
+
+	switch payload[0] {
+	case linkFindFirst, linkFindNext:
+		start := 0
+		if payload[0] == linkFindNext {
+			start = plm.nextSearch
+		}
+
+		if i := plm.find(start, link.Group, link.Address, nil); i >= 0 {
+			plm.nextSearch = i + 1
+			return plm.writeRecord(plm.links[i]), imAck
+		}
+	case linkModFirst, linkModFirstCtrl, linkModFirstResp:
+		// modify first found matches any record for the group and
+		// address, the others only match records of their own type
+		var controller *bool
+		if payload[0] == linkModFirstCtrl {
+			link.Flags = insteon.RecordControlFlags(0xe2)
+			controller = new(bool)
+			*controller = true
+		} else if payload[0] == linkModFirstResp {
+			link.Flags = insteon.RecordControlFlags(0xa2)
+			controller = new(bool)
+		}
+
+		if i := plm.find(0, link.Group, link.Address, controller); i >= 0 {
+			plm.links[i] = link
+		} else {
+			plm.links = append(plm.links, link)
+		}
+		return nil, imAck
+	case linkDeleteFirst:
+		if i := plm.find(0, link.Group, link.Address, nil); i >= 0 {
+			plm.links = append(plm.links[0:i], plm.links[i+1:]...)
+			return nil, imAck
+		}
+	}
+	return nil, imNak
+}
+
+// Close stops the simulator.  Pending reads return io.EOF and any further
+// writes fail
+func (plm *PLM) Close() error {
+	plm.mutex.Lock()
+	plm.closed = true
+	plm.cond.Broadcast()
+	plm.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,275 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sim
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abates/insteon"
+	"github.com/abates/insteon/plm"
+)
+
+// testDevice acknowledges every direct message with cmd2 set to 0x02
+type testDevice struct {
+	address insteon.Address
+	network Network
+
+	mutex    sync.Mutex
+	received []*insteon.Message
+}
+
+func (td *testDevice) Address() insteon.Address { return td.address }
+func (td *testDevice) Attach(network Network)   { td.network = network }
+
+func (td *testDevice) Receive(msg *insteon.Message) {
+	td.mutex.Lock()
+	td.received = append(td.received, msg)
+	td.mutex.Unlock()
+
+	if !msg.Broadcast() {
+		td.network.Send(&insteon.Message{Src: td.address, Dst: msg.Src, Flags: insteon.StandardDirectAck, Command: insteon.Command{0x00, msg.Command[1], 0x02}})
+	}
+}
+
+func (td *testDevice) Received() []*insteon.Message {
+	td.mutex.Lock()
+	defer td.mutex.Unlock()
+	return append([]*insteon.Message(nil), td.received...)
+}
+
+func readOutput(t *testing.T, modem *PLM, length int) []byte {
+	buf := make([]byte, length)
+	doneCh := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(modem, buf)
+		doneCh <- err
+	}()
+
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %d bytes", length)
+	}
+	return buf
+}
+
+func TestPLMCommands(t *testing.T) {
+	link := []byte{0xe2, 0x01, 4, 5, 6, 0x01, 0x02, 0x03}
+	tests := []struct {
+		desc     string
+		links    []*insteon.LinkRecord
+		naks     int
+		input    []byte
+		expected []byte
+	}{
+		{"get info", nil, 0, []byte{0x02, 0x60}, []byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06}},
+		{"get info (nak)", nil, 1, []byte{0x02, 0x60}, []byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x15}},
+		{"junk", nil, 0, []byte{0x00, 0xff, 0x02, 0x60}, []byte{0x02, 0x60, 1, 2, 3, 0x03, 0x15, 0x9b, 0x06}},
+		{"unknown command", nil, 0, []byte{0x02, 0x42}, []byte{0x15}},
+		{"set config", nil, 0, []byte{0x02, 0x6b, 0x40}, []byte{0x02, 0x6b, 0x40, 0x06}},
+		{"get config", nil, 0, []byte{0x02, 0x73}, []byte{0x02, 0x73, 0x00, 0x00, 0x00, 0x06}},
+		{"get first (empty)", nil, 0, []byte{0x02, 0x69}, []byte{0x02, 0x69, 0x15}},
+		{"get first", []*insteon.LinkRecord{{Flags: 0xe2, Group: 1, Address: insteon.Address{4, 5, 6}, Data: [3]byte{1, 2, 3}}}, 0, []byte{0x02, 0x69}, append([]byte{0x02, 0x69, 0x06, 0x02, 0x57}, link...)},
+		{"find first", []*insteon.LinkRecord{{Flags: 0xe2, Group: 1, Address: insteon.Address{4, 5, 6}, Data: [3]byte{1, 2, 3}}}, 0, []byte{0x02, 0x6f, 0x00, 0x00, 0x01, 4, 5, 6, 0, 0, 0}, []byte{0x02, 0x6f, 0x00, 0x00, 0x01, 4, 5, 6, 0, 0, 0, 0x06, 0x02, 0x57, 0xe2, 0x01, 4, 5, 6, 0x01, 0x02, 0x03}},
+		{"find first (missing)", nil, 0, []byte{0x02, 0x6f, 0x00, 0x00, 0x01, 4, 5, 6, 0, 0, 0}, []byte{0x02, 0x6f, 0x00, 0x00, 0x01, 4, 5, 6, 0, 0, 0, 0x15}},
+		{"delete (missing)", nil, 0, []byte{0x02, 0x6f, 0x80, 0x00, 0x01, 4, 5, 6, 0, 0, 0}, []byte{0x02, 0x6f, 0x80, 0x00, 0x01, 4, 5, 6, 0, 0, 0, 0x15}},
+		{"send all-link", nil, 0, []byte{0x02, 0x61, 0x01, 0x11, 0x00}, []byte{0x02, 0x61, 0x01, 0x11, 0x00, 0x06, 0x02, 0x58, 0x06}},
+		{"send insteon message", nil, 0, []byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff}, []byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff, 0x06}},
+	}
+
+	for _, test := range tests {
+		modem := NewPLM(insteon.Address{1, 2, 3})
+		for _, link := range test.links {
+			modem.AddLink(link)
+		}
+		modem.NakNext(test.naks)
+
+		// write one byte at a time to exercise the framing
+		for _, b := range test.input {
+			modem.Write([]byte{b})
+		}
+
+		got := readOutput(t, modem, len(test.expected))
+		if !bytes.Equal(test.expected, got) {
+			t.Errorf("%s: expected %x got %x", test.desc, test.expected, got)
+		}
+		modem.Close()
+	}
+}
+
+func TestPLMManageLinks(t *testing.T) {
+	modem := NewPLM(insteon.Address{1, 2, 3})
+	defer modem.Close()
+
+	commands := [][]byte{
+		{0x02, 0x6f, 0x40, 0x00, 0x01, 4, 5, 6, 1, 2, 3},
+		{0x02, 0x6f, 0x41, 0x00, 0x01, 4, 5, 6, 0, 0, 0},
+		{0x02, 0x6f, 0x40, 0x00, 0x01, 4, 5, 6, 7, 8, 9},
+		{0x02, 0x6f, 0x41, 0x00, 0x02, 7, 8, 9, 0, 0, 0},
+		{0x02, 0x6f, 0x80, 0x00, 0x01, 4, 5, 6, 0, 0, 0},
+	}
+
+	for _, command := range commands {
+		modem.Write(command)
+		readOutput(t, modem, len(command)+1)
+	}
+
+	expected := []*insteon.LinkRecord{
+		{Flags: 0xa2, Group: 1, Address: insteon.Address{4, 5, 6}},
+		{Flags: 0xa2, Group: 2, Address: insteon.Address{7, 8, 9}},
+	}
+
+	links := modem.Links()
+	if len(links) != len(expected) {
+		t.Fatalf("expected %d links got %d", len(expected), len(links))
+	}
+
+	for i, link := range links {
+		if *link != *expected[i] {
+			t.Errorf("links[%d] expected %v got %v", i, expected[i], link)
+		}
+	}
+}
+
+func TestPLMRouting(t *testing.T) {
+	modem := NewPLM(insteon.Address{1, 2, 3})
+	defer modem.Close()
+	device1 := &testDevice{address: insteon.Address{4, 5, 6}}
+	device2 := &testDevice{address: insteon.Address{7, 8, 9}}
+	modem.AddDevice(device1)
+	modem.AddDevice(device2)
+
+	// direct message from the host is acknowledged by the device
+	modem.Write([]byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff})
+	expected := []byte{0x02, 0x62, 4, 5, 6, 0x0f, 0x11, 0xff, 0x06, 0x02, 0x50, 4, 5, 6, 1, 2, 3, 0x2a, 0x11, 0x02}
+	if got := readOutput(t, modem, len(expected)); !bytes.Equal(expected, got) {
+		t.Errorf("expected %x got %x", expected, got)
+	}
+
+	// broadcasts from a device reach the host and the other devices
+	device1.network.Send(&insteon.Message{Src: device1.address, Dst: insteon.Address{0, 0, 1}, Flags: insteon.StandardAllLinkBroadcast, Command: insteon.Command{0x00, 0x11, 0x00}})
+	expected = []byte{0x02, 0x50, 4, 5, 6, 0, 0, 1, 0xca, 0x11, 0x00}
+	if got := readOutput(t, modem, len(expected)); !bytes.Equal(expected, got) {
+		t.Errorf("expected %x got %x", expected, got)
+	}
+
+	for i := 0; i < 100 && len(device2.Received()) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(device1.Received()) != 1 || len(device2.Received()) != 1 {
+		t.Errorf("expected each device to receive one message, got %d and %d", len(device1.Received()), len(device2.Received()))
+	}
+}
+
+func TestPLMStack(t *testing.T) {
+	modem := NewPLM(insteon.Address{1, 2, 3})
+	modem.AddDevice(&testDevice{address: insteon.Address{4, 5, 6}})
+	local := plm.New(plm.NewPort(modem, time.Second), time.Second)
+	defer local.Close()
+
+	info, err := local.Info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Address != modem.Address() {
+		t.Errorf("expected %v got %v", modem.Address(), info.Address)
+	}
+
+	link := &insteon.LinkRecord{Flags: 0xe2, Group: 1, Address: insteon.Address{4, 5, 6}}
+	if err = local.AddLink(link); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	links, err := local.Links()
+	if err != nil || len(links) != 1 || !links[0].Equal(link) {
+		t.Errorf("expected %v got %v (%v)", link, links, err)
+	}
+
+	version, err := local.Network.EngineVersion(insteon.Address{4, 5, 6})
+	if err != nil || version != insteon.VerI2Cs {
+		t.Errorf("expected %v got %v (%v)", insteon.VerI2Cs, version, err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	modem := NewPLM(testPLMAddress)
+	dimmer := NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{4, 5, 6}, DevCat: insteon.DevCat{0x01, 0x20}, FirmwareVersion: 0x41, EngineVersion: insteon.VerI2Cs})
+	// the switch is only linked to the dimmer, not the modem
+	sw := NewLightingDevice(insteon.DeviceInfo{Address: insteon.Address{7, 8, 9}, DevCat: insteon.DevCat{0x02, 0x2a}, FirmwareVersion: 0x43, EngineVersion: insteon.VerI2})
+	dimmer.AddLink(testPLMLink)
+	dimmer.AddLink(&insteon.LinkRecord{Flags: 0xe2, Group: 1, Address: sw.Address()})
+	sw.AddLink(&insteon.LinkRecord{Flags: 0xa2, Group: 1, Address: dimmer.Address()})
+	modem.AddDevice(dimmer)
+	modem.AddDevice(sw)
+
+	local := plm.New(plm.NewPort(modem, time.Second), 100*time.Millisecond)
+	defer local.Close()
+
+	local.AddLink(&insteon.LinkRecord{Flags: 0xe2, Group: 1, Address: dimmer.Address()})
+	local.AddLink(&insteon.LinkRecord{Flags: 0xe2, Group: 2, Address: insteon.Address{0x0a, 0x0b, 0x0c}})
+
+	inventory, err := local.Network.Discover(local)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		address      insteon.Address
+		reachable    bool
+		devCat       insteon.DevCat
+		referencedBy []insteon.Address
+	}{
+		{insteon.Address{4, 5, 6}, true, insteon.DevCat{0x01, 0x20}, []insteon.Address{testPLMAddress, sw.Address()}},
+		{insteon.Address{7, 8, 9}, true, insteon.DevCat{0x02, 0x2a}, []insteon.Address{dimmer.Address()}},
+		{insteon.Address{0x0a, 0x0b, 0x0c}, false, insteon.DevCat{}, []insteon.Address{testPLMAddress}},
+	}
+
+	if len(inventory) != len(tests) {
+		t.Fatalf("expected %d devices got %d", len(tests), len(inventory))
+	}
+
+	for i, test := range tests {
+		dd := inventory[i]
+		if dd.Info.Address != test.address {
+			t.Errorf("tests[%d] expected %v got %v", i, test.address, dd.Info.Address)
+		}
+
+		if dd.Reachable != test.reachable {
+			t.Errorf("tests[%d] expected reachable %v got %v (%v)", i, test.reachable, dd.Reachable, dd.Err)
+		}
+
+		if dd.Info.DevCat != test.devCat {
+			t.Errorf("tests[%d] expected %v got %v", i, test.devCat, dd.Info.DevCat)
+		}
+
+		if !reflect.DeepEqual(dd.ReferencedBy, test.referencedBy) {
+			t.Errorf("tests[%d] expected referenced by %v got %v", i, test.referencedBy, dd.ReferencedBy)
+		}
+	}
+
+	if info, found := local.Network.DB.Find(sw.Address()); !found || info.EngineVersion != insteon.VerI2 {
+		t.Errorf("expected switch to be added to the product database got %v", info)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sim simulates an Insteon PowerLinc Modem (PLM) and the devices
+// on its network.  The simulated PLM speaks the IM serial protocol over an
+// io.ReadWriter so that it can be handed to plm.NewPort in place of a
+// serial port:
+//
+//	modem := sim.NewPLM(insteon.Address{1, 2, 3})
+//	local := plm.New(plm.NewPort(modem, time.Second), time.Second)
+//
+// This allows tests and demos to run without any hardware
+package sim
+
+import "github.com/abates/insteon"
+
+// Network delivers messages sent by simulated devices
+type Network interface {
+	// Send delivers the message to the PLM and any other devices
+	// it is addressed to
+	Send(msg *insteon.Message)
+}
+
+// Device is a simulated Insteon device.  Devices receive every direct
+// message addressed to them as well as all broadcast messages
+type Device interface {
+	// Address returns the Insteon address of the device
+	Address() insteon.Address
+
+	// Attach is called when the device is added to a network.  Any
+	// messages the device sends (acknowledgements, responses and
+	// broadcasts) are sent to the network
+	Attach(network Network)
+
+	// Receive is called for each message delivered to the device
+	Receive(msg *insteon.Message)
+}
+
+func copyMessage(msg *insteon.Message) *insteon.Message {
+	c := *msg
+	c.Payload = append([]byte(nil), msg.Payload...)
+	return &c
+}
@@ -0,0 +1,354 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotSwitch is returned when a device's state is requested but the device
+// does not implement the Switch interface
+var ErrNotSwitch = errors.New("Device does not implement the Switch interface")
+
+// DeviceState is the last known state of a device
+type DeviceState struct {
+	// Address of the device
+	Address Address `json:"address"`
+
+	// Level is the last known level of the device. 0 is off and 255 is fully on
+	Level int `json:"level"`
+
+	// Updated is the time the state was last recorded
+	Updated time.Time `json:"updated"`
+}
+
+// On indicates whether the device is on at any level
+func (ds DeviceState) On() bool { return ds.Level > 0 }
+
+// StateChange is delivered to StateTracker subscribers whenever a device's
+// level changes.  If the device was not previously tracked then Previous
+// will be the zero value
+type StateChange struct {
+	Previous DeviceState
+	Current  DeviceState
+}
+
+// StateTracker listens to traffic on the Insteon network and remembers the
+// last known level of each device.  Levels are updated from the ACKs of
+// direct lighting commands and status requests, from All-Link broadcasts
+// and cleanups, and from status requests made with Refresh.  Group
+// commands are resolved to the affected responders using the link
+// databases given to the tracker with SetLinks or LoadLinks
+type StateTracker struct {
+	mutex  sync.Mutex
+	states map[Address]DeviceState
+	links  map[Address][]*LinkRecord
+
+	subscriberMutex sync.Mutex
+	subscribers     []chan<- StateChange
+
+	network *Network
+	status  *statusRequests
+	timeout time.Duration
+	recvCh  chan *Message
+	doneCh  chan bool
+}
+
+// NewStateTracker creates a StateTracker that subscribes to all the messages
+// received by the network
+func NewStateTracker(network *Network) *StateTracker {
+	tracker := newStateTracker(make(chan *Message, 1))
+	tracker.network = network
+	tracker.status = network.status
+	tracker.timeout = network.timeout
+	network.Subscribe(tracker.recvCh)
+	return tracker
+}
+
+func newStateTracker(recvCh chan *Message) *StateTracker {
+	tracker := &StateTracker{
+		states: make(map[Address]DeviceState),
+		links:  make(map[Address][]*LinkRecord),
+		recvCh: recvCh,
+		doneCh: make(chan bool),
+	}
+	go tracker.process()
+	return tracker
+}
+
+func (st *StateTracker) process() {
+	defer close(st.doneCh)
+	for msg := range st.recvCh {
+		st.receive(msg)
+	}
+}
+
+func (st *StateTracker) receive(msg *Message) {
+	switch msg.Flags.Type() {
+	case MsgTypeDirectAck:
+		if st.status.ack(msg, time.Now(), st.timeout) {
+			// the level is in cmd2 of a status request ACK
+			st.Update(msg.Src, int(msg.Command[2]))
+		} else if level, ok := directLevel(msg.Command); ok {
+			st.Update(msg.Src, level)
+		}
+	case MsgTypeAllLinkBroadcast:
+		st.groupCommand(msg.Src, Group(msg.Dst[2]), msg.Command[1])
+	case MsgTypeAllLinkCleanup:
+		// the group is in the cmd2 field of a cleanup message
+		st.groupCommand(msg.Src, Group(msg.Command[2]), msg.Command[1])
+	case MsgTypeAllLinkCleanupAck:
+		// cleanup acks come from a responder, the destination
+		// is the controller that sent the cleanup
+		st.responderCommand(msg.Src, msg.Dst, Group(msg.Command[2]), msg.Command[1])
+	}
+}
+
+// directLevel returns the level a device is at after acknowledging the
+// direct command.  The ACK for most lighting commands carries the new
+// level in cmd2
+func directLevel(cmd Command) (level int, ok bool) {
+	switch cmd[1] {
+	case CmdLightOn[1], CmdLightOnFast[1], CmdLightOff[1], CmdLightOffFast[1], CmdLightInstantChange[1], CmdLightSetStatus[1]:
+		return int(cmd[2]), true
+	case CmdLightOnAtRamp[1], CmdLightOnAtRampV67[1]:
+		// the level is stored in the upper nibble
+		return int(cmd[2]>>4) * 0x11, true
+	case CmdLightOffAtRamp[1], CmdLightOffAtRampV67[1]:
+		return 0, true
+	}
+	return 0, false
+}
+
+// groupLevel returns the level a responder goes to when it receives the
+// group command (cmd1).  Fast on always goes to full brightness, while a
+// normal on command recalls the level stored in the responder's link record
+func groupLevel(cmd1 byte, link *LinkRecord) (level int, ok bool) {
+	switch cmd1 {
+	case CmdLightOn[1]:
+		return int(link.Data[0]), true
+	case CmdLightOnFast[1]:
+		return 0xff, true
+	case CmdLightOff[1], CmdLightOffFast[1]:
+		return 0, true
+	}
+	return 0, false
+}
+
+func (st *StateTracker) groupCommand(controller Address, group Group, cmd1 byte) {
+	// the controlling device's own load (button 1) follows the commands
+	// it sends, the exact on level is only known after a Refresh
+	if group == 1 {
+		switch cmd1 {
+		case CmdLightOn[1], CmdLightOnFast[1]:
+			st.Update(controller, 0xff)
+		case CmdLightOff[1], CmdLightOffFast[1]:
+			st.Update(controller, 0)
+		}
+	}
+
+	st.mutex.Lock()
+	responders := []Address{}
+	for address, links := range st.links {
+		if findResponderLink(links, controller, group) != nil {
+			responders = append(responders, address)
+		}
+	}
+	st.mutex.Unlock()
+
+	for _, responder := range responders {
+		st.responderCommand(responder, controller, group, cmd1)
+	}
+}
+
+func (st *StateTracker) responderCommand(responder, controller Address, group Group, cmd1 byte) {
+	st.mutex.Lock()
+	link := findResponderLink(st.links[responder], controller, group)
+	st.mutex.Unlock()
+
+	if link != nil {
+		if level, ok := groupLevel(cmd1, link); ok {
+			st.Update(responder, level)
+		}
+	}
+}
+
+func findResponderLink(links []*LinkRecord, controller Address, group Group) *LinkRecord {
+	for _, link := range links {
+		if link.Flags.InUse() && link.Flags.Responder() && link.Address == controller && link.Group == group {
+			return link
+		}
+	}
+	return nil
+}
+
+// SetLinks will cache the link database for the device at address.  The
+// cached links are used to determine which devices respond to All-Link
+// group commands
+func (st *StateTracker) SetLinks(address Address, links []*LinkRecord) {
+	st.mutex.Lock()
+	st.links[address] = links
+	st.mutex.Unlock()
+}
+
+// LoadLinks will read the link database from the device and cache it
+// with SetLinks
+func (st *StateTracker) LoadLinks(device LinkableDevice) error {
+	links, err := device.Links()
+	if err == nil {
+		st.SetLinks(device.Address(), links)
+	}
+	return err
+}
+
+// Update will record the level for the device at address.  If the level
+// differs from the previously known level (or the device was not previously
+// tracked) then a StateChange is sent to each subscriber.  Subscribers
+// that are not ready to receive the change miss it
+func (st *StateTracker) Update(address Address, level int) {
+	st.mutex.Lock()
+	previous, found := st.states[address]
+	current := DeviceState{Address: address, Level: level, Updated: time.Now()}
+	st.states[address] = current
+	st.mutex.Unlock()
+
+	if !found || previous.Level != level {
+		Log.Debugf("%v level changed to %d", address, level)
+		st.subscriberMutex.Lock()
+		for _, subscriber := range st.subscribers {
+			select {
+			case subscriber <- StateChange{Previous: previous, Current: current}:
+			default:
+				Log.Infof("Dropped %v state change for a slow subscriber", address)
+			}
+		}
+		st.subscriberMutex.Unlock()
+	}
+}
+
+// Refresh will query the device for its current status and record the
+// resulting level
+func (st *StateTracker) Refresh(device Device) (level int, err error) {
+	if sw, ok := device.(Switch); ok {
+		level, err = sw.Status()
+		if err == nil {
+			st.Update(device.Address(), level)
+		}
+	} else {
+		err = ErrNotSwitch
+	}
+	return level, err
+}
+
+// State returns the last known state of the device at address
+func (st *StateTracker) State(address Address) (DeviceState, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	state, found := st.states[address]
+	return state, found
+}
+
+// States returns the last known state of every tracked device
+func (st *StateTracker) States() []DeviceState {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	states := []DeviceState{}
+	for _, state := range st.states {
+		states = append(states, state)
+	}
+	return states
+}
+
+// Subscribe will register the channel to receive a StateChange every
+// time a device's level changes.  Changes are never waited for, so
+// subscribers should read from the channel promptly, or give it a buffer,
+// to avoid missing them
+func (st *StateTracker) Subscribe(ch chan<- StateChange) {
+	st.subscriberMutex.Lock()
+	st.subscribers = append(st.subscribers, ch)
+	st.subscriberMutex.Unlock()
+}
+
+// Unsubscribe will remove the channel from the list of subscribers and
+// close it
+func (st *StateTracker) Unsubscribe(ch chan<- StateChange) {
+	st.subscriberMutex.Lock()
+	defer st.subscriberMutex.Unlock()
+	for i, subscriber := range st.subscribers {
+		if subscriber == ch {
+			close(subscriber)
+			st.subscribers = append(st.subscribers[0:i], st.subscribers[i+1:]...)
+			break
+		}
+	}
+}
+
+// Close will stop tracking network traffic
+func (st *StateTracker) Close() {
+	if st.network == nil {
+		close(st.recvCh)
+	} else {
+		st.network.Unsubscribe(st.recvCh)
+	}
+	<-st.doneCh
+}
+
+// statusRequests records when a status request was last sent to each
+// device.  The ACK of a status request has the ALDB delta in cmd1, rather
+// than the command, so it can only be recognised by remembering the request
+type statusRequests struct {
+	mutex sync.Mutex
+	sent  map[Address]time.Time
+}
+
+func newStatusRequests() *statusRequests {
+	return &statusRequests{sent: make(map[Address]time.Time)}
+}
+
+// send records the direct message sent to the device.  Any other command
+// replaces an outstanding status request, since a device only has one
+// request in flight at a time
+func (sr *statusRequests) send(msg *Message, now time.Time) {
+	if sr == nil || msg.Flags.Type() != MsgTypeDirect {
+		return
+	}
+
+	sr.mutex.Lock()
+	if msg.Command[1] == CmdLightStatusRequest[1] {
+		sr.sent[msg.Dst] = now
+	} else {
+		delete(sr.sent, msg.Dst)
+	}
+	sr.mutex.Unlock()
+}
+
+// ack returns true if the message is a direct ACK from a device that was
+// sent a status request less than timeout ago
+func (sr *statusRequests) ack(msg *Message, now time.Time, timeout time.Duration) bool {
+	if sr == nil || msg.Flags.Type() != MsgTypeDirectAck {
+		return false
+	}
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sent, found := sr.sent[msg.Src]
+	if found && now.Sub(sent) >= timeout {
+		delete(sr.sent, msg.Src)
+		found = false
+	}
+	return found
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateTrackerReceive(t *testing.T) {
+	plm := Address{0xaa, 0xbb, 0xcc}
+	keypad := Address{0x0a, 0x0b, 0x0c}
+	device := Address{1, 2, 3}
+	responder := Address{4, 5, 6}
+	links := []*LinkRecord{
+		{Flags: 0xa2, Group: 5, Address: keypad, Data: [3]byte{0x80, 0x1c, 0x01}},
+		{Flags: 0xa2, Group: 9, Address: plm, Data: [3]byte{0x40, 0x1c, 0x01}},
+	}
+
+	tests := []struct {
+		desc     string
+		msg      *Message
+		address  Address
+		expected int
+		found    bool
+	}{
+		{"on ack", &Message{Src: device, Dst: plm, Flags: StandardDirectAck, Command: Command{0x02, 0x11, 0xff}}, device, 0xff, true},
+		{"off ack", &Message{Src: device, Dst: plm, Flags: StandardDirectAck, Command: Command{0x02, 0x13, 0x00}}, device, 0x00, true},
+		{"on at ramp ack", &Message{Src: device, Dst: plm, Flags: StandardDirectAck, Command: Command{0x02, 0x2e, 0x8f}}, device, 0x88, true},
+		{"ping ack", &Message{Src: device, Dst: plm, Flags: StandardDirectAck, Command: Command{0x02, 0x0f, 0x00}}, device, 0x88, true},
+		{"direct command", &Message{Src: device, Dst: plm, Flags: StandardDirectMessage, Command: Command{0x00, 0x11, 0xff}}, device, 0x88, true},
+		{"group on", &Message{Src: keypad, Dst: Address{0, 0, 5}, Flags: StandardAllLinkBroadcast, Command: Command{0x0c, 0x11, 0x00}}, responder, 0x80, true},
+		{"group fast on", &Message{Src: keypad, Dst: Address{0, 0, 5}, Flags: StandardAllLinkBroadcast, Command: Command{0x0c, 0x12, 0x00}}, responder, 0xff, true},
+		{"group off cleanup", &Message{Src: keypad, Dst: plm, Flags: Flags(0x4a), Command: Command{0x04, 0x13, 0x05}}, responder, 0x00, true},
+		{"unlinked group", &Message{Src: keypad, Dst: Address{0, 0, 6}, Flags: StandardAllLinkBroadcast, Command: Command{0x0c, 0x11, 0x00}}, responder, 0x00, true},
+		{"controller load", &Message{Src: keypad, Dst: Address{0, 0, 1}, Flags: StandardAllLinkBroadcast, Command: Command{0x0c, 0x11, 0x00}}, keypad, 0xff, true},
+		{"cleanup ack", &Message{Src: responder, Dst: plm, Flags: Flags(0x6a), Command: Command{0x06, 0x11, 0x09}}, responder, 0x40, true},
+	}
+
+	tracker := newStateTracker(make(chan *Message))
+	tracker.SetLinks(responder, links)
+	for _, test := range tests {
+		tracker.receive(test.msg)
+		state, found := tracker.State(test.address)
+		if found != test.found {
+			t.Errorf("%s: expected found to be %v got %v", test.desc, test.found, found)
+		} else if state.Level != test.expected {
+			t.Errorf("%s: expected level %d got %d", test.desc, test.expected, state.Level)
+		}
+	}
+	tracker.Close()
+}
+
+func TestStateTrackerSubscribe(t *testing.T) {
+	recvCh := make(chan *Message)
+	tracker := newStateTracker(recvCh)
+	changeCh := make(chan StateChange, 2)
+	tracker.Subscribe(changeCh)
+
+	address := Address{1, 2, 3}
+	tracker.Update(address, 0xff)
+	// unchanged levels are not delivered
+	tracker.Update(address, 0xff)
+	recvCh <- &Message{Src: address, Flags: StandardDirectAck, Command: Command{0x02, 0x13, 0x00}}
+	tracker.Close()
+
+	change := <-changeCh
+	if change.Previous.Level != 0 || change.Current.Level != 0xff {
+		t.Errorf("expected change from 0 to 255 got %d to %d", change.Previous.Level, change.Current.Level)
+	}
+
+	change = <-changeCh
+	if change.Previous.Level != 0xff || change.Current.Level != 0 || change.Current.On() {
+		t.Errorf("expected change from 255 to 0 got %d to %d", change.Previous.Level, change.Current.Level)
+	}
+
+	tracker.Unsubscribe(changeCh)
+	if _, open := <-changeCh; open {
+		t.Errorf("expected subscriber channel to be closed")
+	}
+
+	if len(tracker.States()) != 1 {
+		t.Errorf("expected 1 state got %d", len(tracker.States()))
+	}
+}
+
+func TestStateTrackerSlowSubscriber(t *testing.T) {
+	tracker := newStateTracker(make(chan *Message))
+	defer tracker.Close()
+	tracker.Subscribe(make(chan StateChange))
+
+	doneCh := make(chan bool)
+	go func() {
+		tracker.Update(Address{1, 2, 3}, 0xff)
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatalf("Update blocked on a subscriber that is not reading")
+	}
+
+	if state, _ := tracker.State(Address{1, 2, 3}); state.Level != 0xff {
+		t.Errorf("expected level 255 got %d", state.Level)
+	}
+}
+
+func TestStateTrackerStatusAck(t *testing.T) {
+	device := Address{1, 2, 3}
+	tracker := newStateTracker(make(chan *Message))
+	defer tracker.Close()
+	tracker.status = newStatusRequests()
+	tracker.timeout = time.Second
+
+	tests := []struct {
+		desc     string
+		sent     Command
+		ack      Command
+		expected int
+	}{
+		{"status", CmdLightStatusRequest, Command{0x00, 0x05, 0x20}, 0x20},
+		// the ALDB delta in cmd1 must not be mistaken for on at ramp
+		{"delta matches a command", CmdLightStatusRequest, Command{0x00, 0x2e, 0x8f}, 0x8f},
+		{"on at ramp", CmdLightOnAtRamp, Command{0x00, 0x2e, 0x8f}, 0x88},
+	}
+
+	for _, test := range tests {
+		tracker.status.send(&Message{Dst: device, Flags: StandardDirectMessage, Command: test.sent}, time.Now())
+		tracker.receive(&Message{Src: device, Flags: StandardDirectAck, Command: test.ack})
+		if state, _ := tracker.State(device); state.Level != test.expected {
+			t.Errorf("%s: expected level %d got %d", test.desc, test.expected, state.Level)
+		}
+	}
+}
+
+func TestStatusRequests(t *testing.T) {
+	device := Address{1, 2, 3}
+	now := time.Now()
+	request := &Message{Dst: device, Flags: StandardDirectMessage, Command: CmdLightStatusRequest}
+	ack := &Message{Src: device, Flags: StandardDirectAck, Command: Command{0x00, 0x05, 0x60}}
+
+	tests := []struct {
+		desc     string
+		sent     *Message
+		elapsed  time.Duration
+		expected bool
+	}{
+		{"status request", request, 0, true},
+		{"expired", request, time.Second, false},
+		{"other command", &Message{Dst: device, Flags: StandardDirectMessage, Command: CmdLightOn}, 0, false},
+		{"other device", &Message{Dst: Address{4, 5, 6}, Flags: StandardDirectMessage, Command: CmdLightStatusRequest}, 0, true},
+	}
+
+	for _, test := range tests {
+		sr := newStatusRequests()
+		sr.send(request, now)
+		sr.send(test.sent, now)
+		if got := sr.ack(ack, now.Add(test.elapsed), time.Second); got != test.expected {
+			t.Errorf("%s: expected %v got %v", test.desc, test.expected, got)
+		}
+	}
+
+	var sr *statusRequests
+	sr.send(request, now)
+	if sr.ack(ack, now, time.Second) {
+		t.Errorf("expected nil statusRequests to never match")
+	}
+}
+
+func TestStateTrackerLinks(t *testing.T) {
+	responder := &testLinkable{address: Address{1, 2, 3}, links: []*LinkRecord{{Flags: 0xa2, Group: 1, Address: Address{4, 5, 6}, Data: [3]byte{0x20, 0, 0}}}}
+	tracker := newStateTracker(make(chan *Message))
+	defer tracker.Close()
+
+	err := tracker.LoadLinks(responder)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tracker.receive(&Message{Src: Address{4, 5, 6}, Dst: Address{0, 0, 1}, Flags: StandardAllLinkBroadcast, Command: Command{0x0c, 0x11, 0x00}})
+	if state, _ := tracker.State(responder.address); state.Level != 0x20 {
+		t.Errorf("expected level 0x20 got %d", state.Level)
+	}
+
+	_, err = tracker.Refresh(NewI1Device(Address{1, 2, 3}, make(chan *MessageRequest), nil, 0))
+	if err != ErrNotSwitch {
+		t.Errorf("expected %v got %v", ErrNotSwitch, err)
+	}
+}
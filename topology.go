@@ -0,0 +1,204 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DeviceLinks is the All-Link database read from a device
+type DeviceLinks struct {
+	Info  DeviceInfo
+	Links []*LinkRecord
+}
+
+// TopologyDevice is a node in the link topology
+type TopologyDevice struct {
+	Address Address `json:"address"`
+	Name    string  `json:"name,omitempty"`
+
+	// Known indicates whether the device's All-Link database was
+	// included in the topology.  Devices that are only referenced by
+	// other databases are not known
+	Known bool `json:"known"`
+}
+
+// TopologyLink is a controller to responder relationship for a group.
+// OnLevel and RampRate are taken from the responder's link record
+type TopologyLink struct {
+	Controller Address `json:"controller"`
+	Responder  Address `json:"responder"`
+	Group      Group   `json:"group"`
+	OnLevel    int     `json:"onLevel"`
+	RampRate   int     `json:"rampRate"`
+
+	// ControllerRecord and ResponderRecord indicate which of the two
+	// devices have a record for the link
+	ControllerRecord bool `json:"controllerRecord"`
+	ResponderRecord  bool `json:"responderRecord"`
+
+	// OneSided is set when the database of one device has a record for
+	// the link, but the database of the other device was read and does
+	// not have the matching record
+	OneSided bool `json:"oneSided"`
+}
+
+// Topology is the graph of controller to responder links between the
+// devices on the network
+type Topology struct {
+	Devices []*TopologyDevice `json:"devices"`
+	Links   []*TopologyLink   `json:"links"`
+}
+
+type topologyKey struct {
+	controller Address
+	responder  Address
+	group      Group
+}
+
+// NewTopology builds the link topology from the All-Link databases of
+// the devices.  Only in-use records are considered
+func NewTopology(databases ...DeviceLinks) *Topology {
+	devices := make(map[Address]*TopologyDevice)
+	links := make(map[topologyKey]*TopologyLink)
+
+	device := func(address Address) *TopologyDevice {
+		if _, found := devices[address]; !found {
+			devices[address] = &TopologyDevice{Address: address}
+		}
+		return devices[address]
+	}
+
+	for _, db := range databases {
+		d := device(db.Info.Address)
+		d.Name = db.Info.Name
+		d.Known = true
+
+		for _, record := range db.Links {
+			if !record.Flags.InUse() {
+				continue
+			}
+
+			device(record.Address)
+			key := topologyKey{controller: db.Info.Address, responder: record.Address, group: record.Group}
+			if record.Flags.Responder() {
+				key = topologyKey{controller: record.Address, responder: db.Info.Address, group: record.Group}
+			}
+
+			link, found := links[key]
+			if !found {
+				link = &TopologyLink{Controller: key.controller, Responder: key.responder, Group: key.group}
+				links[key] = link
+			}
+
+			if record.Flags.Controller() {
+				link.ControllerRecord = true
+			} else {
+				link.ResponderRecord = true
+				link.OnLevel = int(record.Data[0])
+				link.RampRate = int(record.Data[1])
+			}
+		}
+	}
+
+	topology := &Topology{}
+	for _, d := range devices {
+		topology.Devices = append(topology.Devices, d)
+	}
+
+	for _, link := range links {
+		if !link.ControllerRecord {
+			link.OneSided = devices[link.Controller].Known
+		} else if !link.ResponderRecord {
+			link.OneSided = devices[link.Responder].Known
+		}
+		topology.Links = append(topology.Links, link)
+	}
+
+	sort.Slice(topology.Devices, func(i, j int) bool {
+		return topology.Devices[i].Address.String() < topology.Devices[j].Address.String()
+	})
+
+	sort.Slice(topology.Links, func(i, j int) bool {
+		l1, l2 := topology.Links[i], topology.Links[j]
+		if l1.Controller != l2.Controller {
+			return l1.Controller.String() < l2.Controller.String()
+		} else if l1.Group != l2.Group {
+			return l1.Group < l2.Group
+		}
+		return l1.Responder.String() < l2.Responder.String()
+	})
+	return topology
+}
+
+// OneSided returns the links that are missing either the controller or
+// the responder record
+func (t *Topology) OneSided() []*TopologyLink {
+	links := []*TopologyLink{}
+	for _, link := range t.Links {
+		if link.OneSided {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// WriteDOT writes the topology as a Graphviz digraph.  Devices whose
+// database was not read are drawn dashed and one-sided links are drawn
+// in red
+func (t *Topology) WriteDOT(w io.Writer) (err error) {
+	printf := func(format string, v ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, v...)
+		}
+	}
+
+	printf("digraph insteon {\n")
+	for _, d := range t.Devices {
+		label := d.Address.String()
+		if d.Name != "" {
+			label = fmt.Sprintf("%s\\n%s", strings.Replace(d.Name, `"`, `\"`, -1), d.Address)
+		}
+
+		style := ""
+		if !d.Known {
+			style = ", style=dashed"
+		}
+		printf("  \"%s\" [label=\"%s\"%s];\n", d.Address, label, style)
+	}
+
+	for _, link := range t.Links {
+		label := fmt.Sprintf("group %d", link.Group)
+		if link.ResponderRecord {
+			label = fmt.Sprintf("%s\\nlevel %d ramp %d", label, link.OnLevel, link.RampRate)
+		}
+
+		style := ""
+		if link.OneSided {
+			missing := "responder"
+			if !link.ControllerRecord {
+				missing = "controller"
+			}
+			label = fmt.Sprintf("%s\\nno %s record", label, missing)
+			style = ", color=red, style=dashed"
+		}
+		printf("  \"%s\" -> \"%s\" [label=\"%s\"%s];\n", link.Controller, link.Responder, label, style)
+	}
+	printf("}\n")
+	return err
+}
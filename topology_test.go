@@ -0,0 +1,90 @@
+// Copyright 2019 Andrew Bates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package insteon
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewTopology(t *testing.T) {
+	a, b, c, d, e := Address{1, 1, 1}, Address{2, 2, 2}, Address{3, 3, 3}, Address{4, 4, 4}, Address{5, 5, 5}
+	topology := NewTopology(
+		DeviceLinks{
+			Info: DeviceInfo{Address: a, Name: "Kitchen"},
+			Links: []*LinkRecord{
+				{Flags: 0xe2, Group: 1, Address: b},
+				{Flags: 0xe2, Group: 2, Address: c},
+				{Flags: 0xe2, Group: 4, Address: e},
+				// deleted records are ignored
+				{Flags: 0x62, Group: 5, Address: b},
+			},
+		},
+		DeviceLinks{Info: DeviceInfo{Address: b}, Links: []*LinkRecord{{Flags: 0xa2, Group: 1, Address: a, Data: [3]byte{0xff, 0x1c, 0x01}}}},
+		DeviceLinks{Info: DeviceInfo{Address: c}},
+		DeviceLinks{Info: DeviceInfo{Address: d}, Links: []*LinkRecord{{Flags: 0xa2, Group: 3, Address: a, Data: [3]byte{0x80, 0x1f, 0x01}}}},
+	)
+
+	expected := []*TopologyLink{
+		{Controller: a, Responder: b, Group: 1, OnLevel: 0xff, RampRate: 0x1c, ControllerRecord: true, ResponderRecord: true},
+		{Controller: a, Responder: c, Group: 2, ControllerRecord: true, OneSided: true},
+		{Controller: a, Responder: d, Group: 3, OnLevel: 0x80, RampRate: 0x1f, ResponderRecord: true, OneSided: true},
+		// e's database was not read, so the link can't be checked
+		{Controller: a, Responder: e, Group: 4, ControllerRecord: true},
+	}
+
+	if !reflect.DeepEqual(expected, topology.Links) {
+		for i, link := range topology.Links {
+			t.Logf("links[%d] %+v", i, link)
+		}
+		t.Errorf("expected %d links got %d", len(expected), len(topology.Links))
+	}
+
+	if len(topology.Devices) != 5 || !topology.Devices[0].Known || topology.Devices[4].Known {
+		t.Errorf("expected 5 devices with only %v unknown, got %v", e, topology.Devices)
+	}
+
+	if got := topology.OneSided(); len(got) != 2 {
+		t.Errorf("expected 2 one-sided links got %d", len(got))
+	}
+}
+
+func TestTopologyWriteDOT(t *testing.T) {
+	a, b := Address{1, 1, 1}, Address{2, 2, 2}
+	topology := NewTopology(
+		DeviceLinks{Info: DeviceInfo{Address: a, Name: "Kitchen"}, Links: []*LinkRecord{{Flags: 0xe2, Group: 1, Address: b}}},
+		DeviceLinks{Info: DeviceInfo{Address: b}},
+	)
+
+	buf := &bytes.Buffer{}
+	if err := topology.WriteDOT(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []string{
+		"digraph insteon {\n",
+		`"01.01.01" [label="Kitchen\n01.01.01"];`,
+		`"02.02.02" [label="02.02.02"];`,
+		`"01.01.01" -> "02.02.02" [label="group 1\nno responder record", color=red, style=dashed];`,
+	}
+
+	for _, test := range tests {
+		if !strings.Contains(buf.String(), test) {
+			t.Errorf("expected output to contain %q got:\n%s", test, buf.String())
+		}
+	}
+}